@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -30,16 +32,877 @@ type WordpressSpec struct {
 
 	// Foo is an example field of Wordpress. Edit Wordpress_types.go to remove/update
 	SqlRootPassword string `json:"sqlRootPassword,omitempty"`
+
+	// Database configures the MySQL tier
+	// +optional
+	Database DatabaseSpec `json:"database,omitempty"`
+
+	// Frontend configures the WordPress tier
+	// +optional
+	Frontend FrontendSpec `json:"frontend,omitempty"`
+
+	// PHP holds php.ini directives rendered into an ini file mounted under
+	// conf.d of the WordPress container, e.g. upload_max_filesize: "64M".
+	// Only common runtime directives such as memory_limit,
+	// max_execution_time and the opcache settings are accepted.
+	// +optional
+	PHP map[string]string `json:"php,omitempty"`
+
+	// Backup configures scheduled backups of the instance
+	// +optional
+	Backup *BackupSpec `json:"backup,omitempty"`
+
+	// CloneFrom bootstraps the database and content of the instance from
+	// another instance, through a fresh backup or CSI volume clones
+	// +optional
+	CloneFrom *CloneSource `json:"cloneFrom,omitempty"`
+
+	// Bootstrap populates a new instance before its frontend first starts
+	// +optional
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// AllowedCloneNamespaces lists the other namespaces whose instances may
+	// clone this one through spec.cloneFrom. Instances in the same namespace
+	// may always clone it.
+	// +optional
+	AllowedCloneNamespaces []string `json:"allowedCloneNamespaces,omitempty"`
+
+	// Site installs WordPress with wp-cli once the instance is up, so that
+	// nobody has to go through the setup wizard. Sites that are already
+	// installed, such as imported or cloned ones, are left as they are, and
+	// later changes are not applied to an installed site.
+	// +optional
+	Site *SiteSpec `json:"site,omitempty"`
+
+	// Multisite turns the installed site into a WordPress Multisite network,
+	// whose sub-sites are managed with WordpressSite resources. A network
+	// cannot be turned back into a single site, nor can its mode or domain
+	// be changed; such a change sets the Multisite condition to False.
+	// +optional
+	Multisite *MultisiteSpec `json:"multisite,omitempty"`
+
+	// Cron runs WP-Cron from a CronJob instead of on page views, which the
+	// frontend stops doing
+	// +optional
+	Cron *CronSpec `json:"cron,omitempty"`
+
+	// ObjectCache runs a Redis object cache for the instance
+	// +optional
+	ObjectCache *ObjectCacheSpec `json:"objectCache,omitempty"`
+
+	// PageCache puts a Varnish full-page cache in front of the frontend
+	// +optional
+	PageCache *PageCacheSpec `json:"pageCache,omitempty"`
+
+	// DeletionPolicy decides what happens to the data of the instance when
+	// it is deleted
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Plugins are installed, and activated or deactivated, on the running
+	// instance with wp-cli. Plugins removed from the list are left as they
+	// are.
+	// +listType=map
+	// +listMapKey=slug
+	// +optional
+	Plugins []PluginSpec `json:"plugins,omitempty"`
+
+	// Themes are installed on the running instance with wp-cli. Themes
+	// removed from the list are left as they are.
+	// +listType=map
+	// +listMapKey=slug
+	// +optional
+	Themes []ThemeSpec `json:"themes,omitempty"`
+
+	// ActiveTheme is the slug of the theme to activate, which may be a theme
+	// of spec.themes or one already installed. The active theme is left as
+	// it is when empty.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][-_a-z0-9]*$`
+	// +optional
+	ActiveTheme string `json:"activeTheme,omitempty"`
+}
+
+// SiteSpec declares how WordPress is installed
+type SiteSpec struct {
+	// Title is the title of the site
+	Title string `json:"title"`
+
+	// URL is the URL the site is served at
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// AdminUser is the login of the administrator account
+	AdminUser string `json:"adminUser"`
+
+	// AdminEmail is the email address of the administrator
+	AdminEmail string `json:"adminEmail"`
+
+	// AdminPasswordSecretRef names a Secret in the instance's namespace
+	// holding the password of the administrator under the password key
+	AdminPasswordSecretRef corev1.LocalObjectReference `json:"adminPasswordSecretRef"`
+
+	// Locale is the language the site is installed in, e.g. de_DE. Defaults
+	// to en_US.
+	// +kubebuilder:validation:Pattern=`^[a-z]{2,3}(_[A-Z]{2})?(_[a-z]+)?$`
+	// +optional
+	Locale string `json:"locale,omitempty"`
+}
+
+// MultisiteSpec declares a Multisite network
+type MultisiteSpec struct {
+	// Mode decides whether sub-sites are served at subdomains or
+	// subdirectories of the network domain
+	Mode MultisiteMode `json:"mode"`
+
+	// Domain is the domain of the network, which must be the host of the
+	// site URL, e.g. example.com
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	Domain string `json:"domain"`
+
+	// Ingress routes the network domain, the subdomains of subdomain
+	// networks and the domains mapped by WordpressSites to the instance
+	// +optional
+	Ingress *MultisiteIngressSpec `json:"ingress,omitempty"`
+}
+
+// MultisiteMode is how the sub-sites of a network are addressed
+// +kubebuilder:validation:Enum=Subdomain;Subdirectory
+type MultisiteMode string
+
+const (
+	MultisiteModeSubdomain    MultisiteMode = "Subdomain"
+	MultisiteModeSubdirectory MultisiteMode = "Subdirectory"
+)
+
+// MultisiteIngressSpec configures the Ingress of a network
+type MultisiteIngressSpec struct {
+	// IngressClassName is the class of the Ingress
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// Annotations are set on the Ingress, e.g. to configure the controller
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// TLSSecretName names a Secret holding a certificate for every host of
+	// the Ingress. TLS is not terminated when empty.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+// CronSpec configures the CronJob running WP-Cron
+type CronSpec struct {
+	// Schedule is a cron expression at which due events are run
+	// +kubebuilder:default="*/5 * * * *"
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// ConcurrencyPolicy decides whether a run may start while the previous
+	// one is still running
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	// +kubebuilder:default=Forbid
+	// +optional
+	ConcurrencyPolicy string `json:"concurrencyPolicy,omitempty"`
+}
+
+// ObjectCacheSpec configures the Redis object cache of an instance
+type ObjectCacheSpec struct {
+	// Enabled deploys Redis and enables the Redis Object Cache drop-in.
+	// Disabling it removes the drop-in before Redis is deleted.
+	Enabled bool `json:"enabled"`
+
+	// Resources of the Redis container. With a memory limit, Redis evicts
+	// the least recently used keys once 90% of it is used.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Persistence keeps the cache on a volume across restarts of Redis,
+	// which otherwise starts empty
+	// +optional
+	Persistence *ObjectCachePersistence `json:"persistence,omitempty"`
+}
+
+// ObjectCachePersistence configures the volume of the object cache
+type ObjectCachePersistence struct {
+	// Size of the volume. Defaults to 1Gi.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// StorageClassName is the storage class of the volume. Defaults to the
+	// default class of the cluster.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// PageCacheSpec configures the Varnish full-page cache of an instance
+type PageCacheSpec struct {
+	// Enabled deploys Varnish and routes the wordpress Service through it
+	// once it is ready. Requests of logged-in users, commenters and
+	// password-protected posts, wp-admin and previews bypass the cache.
+	Enabled bool `json:"enabled"`
+
+	// TTL is how long pages are cached. The operator purges the cache after
+	// plugin and theme changes and restores. Defaults to 2m.
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// Size of the in-memory cache storage. Defaults to 256Mi.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// Resources of the Varnish container. The memory limit must leave room
+	// above Size for the overhead of Varnish.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// PluginSpec declares a plugin of the instance
+type PluginSpec struct {
+	// Slug is the directory name of the plugin, e.g. "akismet"
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][-_a-z0-9]*$`
+	Slug string `json:"slug"`
+
+	// Version pins the plugin. The plugin is reinstalled from its source
+	// whenever the installed version differs. When empty, a missing plugin
+	// is installed at the latest version and never updated.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Source is where the plugin is installed from. Defaults to the
+	// wordpress.org plugin directory.
+	// +optional
+	Source PluginSource `json:"source,omitempty"`
+
+	// Active activates or deactivates the plugin. Defaults to true.
+	// +optional
+	Active *bool `json:"active,omitempty"`
+}
+
+// PluginSource locates the zip archive of a plugin. At most one field may be
+// set; the wordpress.org plugin directory is used when none is.
+type PluginSource struct {
+	// URL downloads the archive, e.g. https://example.com/my-plugin.zip
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// PersistentVolumeClaim reads the archive from a claim in the
+	// instance's namespace
+	// +optional
+	PersistentVolumeClaim *PVCFileSource `json:"persistentVolumeClaim,omitempty"`
+}
+
+// ThemeSpec declares a theme of the instance
+type ThemeSpec struct {
+	// Slug is the directory name of the theme, e.g. "twentytwentyone"
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][-_a-z0-9]*$`
+	Slug string `json:"slug"`
+
+	// Version pins the theme. The theme is reinstalled from its source
+	// whenever the installed version differs. When empty, a missing theme is
+	// installed at the latest version and never updated.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Source is where the theme is installed from. Defaults to the
+	// wordpress.org theme directory.
+	// +optional
+	Source ThemeSource `json:"source,omitempty"`
+}
+
+// ThemeSource locates a theme. At most one field may be set; the
+// wordpress.org theme directory is used when none is.
+type ThemeSource struct {
+	// URL downloads the zip archive of the theme
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// PersistentVolumeClaim reads the theme from a claim in the instance's
+	// namespace. A path ending in .zip is installed as an archive; any other
+	// path is a directory copied into place on every sync.
+	// +optional
+	PersistentVolumeClaim *PVCFileSource `json:"persistentVolumeClaim,omitempty"`
+
+	// ConfigMap holds the files of the theme, e.g. the style.css and
+	// functions.php of a child theme, copied into place on every sync
+	// +optional
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+}
+
+// PVCFileSource is a file on a PersistentVolumeClaim
+type PVCFileSource struct {
+	// ClaimName is the name of the PersistentVolumeClaim
+	ClaimName string `json:"claimName"`
+
+	// Path is the path of the file on the claim
+	Path string `json:"path"`
+}
+
+// DeletionPolicy decides what happens to the data of a deleted instance
+// +kubebuilder:validation:Enum=Delete;Retain;Snapshot
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes both claims and the credentials Secret
+	// with the instance
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+
+	// DeletionPolicyRetain keeps both claims and the credentials Secret.
+	// An instance created later in the namespace reuses them.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+
+	// DeletionPolicySnapshot shuts the instance down, takes a VolumeSnapshot
+	// of both claims and keeps the snapshots and the credentials Secret
+	DeletionPolicySnapshot DeletionPolicy = "Snapshot"
+)
+
+// CloneSource names the instance to clone. It is cloned from a staging
+// backup, or with CSI volume cloning when requested and possible. The
+// children of an instance have fixed names, so a clone in the namespace of
+// its source names its children after itself instead; see
+// AnnotationScopedNames.
+type CloneSource struct {
+	// Name of the source Wordpress instance
+	Name string `json:"name"`
+
+	// Namespace of the source Wordpress instance. Defaults to the namespace
+	// of the clone. A source in another namespace must list the namespace of
+	// the clone in spec.allowedCloneNamespaces. A source in the same
+	// namespace can only be named when the clone is created.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Staging is where the backup of the source is written to and restored
+	// from. Claims cannot be mounted across namespaces, so for a source in
+	// another namespace it must be an s3 target; the same credentials Secret
+	// must exist in both namespaces. It can be omitted when the volumes of
+	// the source are cloned.
+	// +optional
+	Staging *BackupTarget `json:"staging,omitempty"`
+
+	// VolumeClone provisions the claims of the clone as CSI volume clones of
+	// the claims of the source instead of going through the staging backup.
+	// It needs a source in the same namespace whose claims are bound in a
+	// storage class provisioned by a CSI driver when the clone is created;
+	// the staging backup is used otherwise. The driver must support cloning,
+	// or the claims of the clone stay pending. The claims of the clone get the
+	// storage class and size of those of the source, and the clone shares
+	// the database root password of the source, which the cloned data
+	// directory carries, instead of spec.sqlRootPassword. The source keeps
+	// running, so its database is cloned as it would be found after a crash.
+	// +optional
+	VolumeClone bool `json:"volumeClone,omitempty"`
+
+	// SiteURL is the URL the clone is served at. The database is
+	// search-replaced from the URL of the source to this one.
+	// +optional
+	SiteURL string `json:"siteURL,omitempty"`
+}
+
+// BootstrapSpec defines how a new instance is populated. It is ignored for
+// instances whose frontend already exists.
+type BootstrapSpec struct {
+	// Import migrates an existing site from a SQL dump and a wp-content
+	// archive
+	// +optional
+	Import *ImportSpec `json:"import,omitempty"`
+}
+
+// ImportSpec imports an existing site. The dump is of the site's database
+// alone, as written by mysqldump without --databases, optionally gzipped.
+// The archive is a tarball, optionally gzipped, of wp-content or of its
+// contents.
+type ImportSpec struct {
+	// Source holds the dump and the archive
+	Source ImportSource `json:"source"`
+
+	// Database is the file name of the dump in the source. Defaults to
+	// database.sql.gz.
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// Content is the file name of the archive in the source. Defaults to
+	// wp-content.tar.gz.
+	// +optional
+	Content string `json:"content,omitempty"`
+
+	// TablePrefix is the table prefix of the imported site. Defaults to wp_.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_]+$`
+	// +optional
+	TablePrefix string `json:"tablePrefix,omitempty"`
+
+	// SiteURL is the URL the instance is served at. The database is
+	// search-replaced from the URL of the imported site to this one.
+	SiteURL string `json:"siteURL"`
+}
+
+// ImportSource locates the files of an import. Exactly one field must be set.
+type ImportSource struct {
+	// PersistentVolumeClaim reads the files from the directory Path of a
+	// claim in the instance's namespace
+	// +optional
+	PersistentVolumeClaim *PVCBackupTarget `json:"persistentVolumeClaim,omitempty"`
+
+	// ConfigMap reads the files from the keys of a ConfigMap, under the
+	// binaryData of the ConfigMap for gzipped files. ConfigMaps are limited
+	// to 1MiB, which only suits small sites.
+	// +optional
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+
+	// HTTP downloads the files from a web server
+	// +optional
+	HTTP *HTTPImportSource `json:"http,omitempty"`
+}
+
+// HTTPImportSource downloads the files of an import over HTTP
+type HTTPImportSource struct {
+	// URL is the location the file names are resolved against, e.g.
+	// http://legacy.example.com/export
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+}
+
+// DatabaseSpec defines the desired state of the MySQL tier
+type DatabaseSpec struct {
+	// Config holds mysqld server options rendered into the [mysqld] section of
+	// a my.cnf file mounted under conf.d, e.g. max_connections: "200".
+	// An option with an empty value is rendered as a bare flag.
+	// +optional
+	Config map[string]string `json:"config,omitempty"`
+
+	// Probes tunes the mysqladmin ping readiness and startup probes and the
+	// TCP liveness probe of the MySQL container
+	// +optional
+	Probes ProbesSpec `json:"probes,omitempty"`
+}
+
+// FrontendSpec defines the desired state of the WordPress tier
+type FrontendSpec struct {
+	// ProbePath is the HTTP path requested by the readiness and startup
+	// probes of the WordPress container. Defaults to /wp-login.php.
+	// +optional
+	ProbePath string `json:"probePath,omitempty"`
+
+	// Probes tunes the HTTP readiness and startup probes and the TCP liveness
+	// probe of the WordPress container
+	// +optional
+	Probes ProbesSpec `json:"probes,omitempty"`
+}
+
+// BackupSpec defines scheduled backups of a Wordpress instance
+type BackupSpec struct {
+	// Schedule is a cron expression, e.g. "0 2 * * *", at which a
+	// WordpressBackup is created. Scheduled backups are disabled when empty.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Method is how scheduled backups are taken
+	// +kubebuilder:default=Archive
+	// +optional
+	Method BackupMethod `json:"method,omitempty"`
+
+	// Target is where scheduled archive backups are written
+	// +optional
+	Target BackupTarget `json:"target,omitempty"`
+
+	// VolumeSnapshotClassName is the class of the snapshots taken by
+	// scheduled snapshot backups. Defaults to the default class.
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// Encryption encrypts scheduled archive backups and shipped binlogs
+	// +optional
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+
+	// Verify test-restores every scheduled archive backup. Backups count
+	// toward retention once verified, and failed verifications are pruned
+	// like failed backups.
+	// +optional
+	Verify bool `json:"verify,omitempty"`
+
+	// Retention decides which scheduled backups are pruned, together with
+	// their artifacts
+	// +optional
+	Retention BackupRetention `json:"retention,omitempty"`
+
+	// PointInTime turns on MySQL binary logging and ships the binlogs
+	// continuously, so that a WordpressRestore can roll an archive backup
+	// forward to any later time
+	// +optional
+	PointInTime *PointInTimeSpec `json:"pointInTime,omitempty"`
+
+	// OnDelete takes a last WordpressBackup when the instance is deleted.
+	// Deletion waits for it to complete.
+	// +optional
+	OnDelete *OnDeleteBackupSpec `json:"onDelete,omitempty"`
+}
+
+// OnDeleteBackupSpec configures the backup taken when an instance is deleted.
+// It is taken like a scheduled backup but keeps its artifacts when deleted.
+type OnDeleteBackupSpec struct {
+	// Timeout bounds how long the backup may take. Deletion stays blocked
+	// when it times out or fails, until the backup is deleted to retry it or
+	// the instance is annotated with wordpress.example.com/force-delete.
+	// Defaults to 1h.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// PointInTimeSpec configures binlog shipping for point-in-time recovery
+type PointInTimeSpec struct {
+	// Target is where binlogs are shipped, under <instance>-binlogs.
+	// Defaults to spec.backup.target. A persistentVolumeClaim must be
+	// ReadWriteMany, as the database pod keeps it mounted.
+	// +optional
+	Target *BackupTarget `json:"target,omitempty"`
+
+	// ShipInterval is how often the current binlog is rotated and shipped,
+	// which bounds what is lost together with the database volume.
+	// Defaults to 5m.
+	// +optional
+	ShipInterval *metav1.Duration `json:"shipInterval,omitempty"`
+
+	// ExpireLogsDays is how long binlogs are kept on the database volume
+	// once shipped. Defaults to 7.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ExpireLogsDays int32 `json:"expireLogsDays,omitempty"`
+}
+
+// BackupRetention limits the scheduled backups kept for an instance. The most
+// recent completed backup is always kept.
+type BackupRetention struct {
+	// KeepLast is the number of completed scheduled backups to keep. Zero
+	// keeps all of them.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepLast int32 `json:"keepLast,omitempty"`
+
+	// MaxAge prunes scheduled backups older than this, e.g. "720h"
+	// +optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// ProbesSpec tunes the probes of a container. Unset probes keep the
+// operator defaults.
+type ProbesSpec struct {
+	// +optional
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+
+	// +optional
+	Liveness *ProbeSpec `json:"liveness,omitempty"`
+
+	// Startup holds off the other probes until it succeeds, which covers the
+	// first start of a tier while it initialises its volume
+	// +optional
+	Startup *ProbeSpec `json:"startup,omitempty"`
+}
+
+// ProbeSpec overrides the timing of a probe. Zero values keep the operator
+// defaults.
+type ProbeSpec struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
 }
 
 // WordpressStatus defines the observed state of Wordpress
 type WordpressStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Conditions describe the progress of the instance. The Ready condition's
+	// reason names the bring-up stage that is currently blocking.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// LastScheduleTime is when the last scheduled backup was created
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastSuccessfulBackup is the name of the most recent completed
+	// WordpressBackup of the instance
+	// +optional
+	LastSuccessfulBackup string `json:"lastSuccessfulBackup,omitempty"`
+
+	// LastSuccessfulBackupTime is when LastSuccessfulBackup completed
+	// +optional
+	LastSuccessfulBackupTime *metav1.Time `json:"lastSuccessfulBackupTime,omitempty"`
+
+	// Plugins reports the plugins of spec.plugins as of the last sync
+	// +listType=map
+	// +listMapKey=slug
+	// +optional
+	Plugins []PluginStatus `json:"plugins,omitempty"`
+
+	// Themes reports the themes of spec.themes as of the last sync
+	// +listType=map
+	// +listMapKey=slug
+	// +optional
+	Themes []ThemeStatus `json:"themes,omitempty"`
+
+	// ActiveTheme reports the active theme as of the last sync
+	// +optional
+	ActiveTheme *ActiveThemeStatus `json:"activeTheme,omitempty"`
+
+	// Cron reports the runs of the WP-Cron CronJob
+	// +optional
+	Cron *CronStatus `json:"cron,omitempty"`
+
+	// ObjectCache reports the statistics of the Redis object cache
+	// +optional
+	ObjectCache *ObjectCacheStatus `json:"objectCache,omitempty"`
+}
+
+// PluginStatus is the observed state of a plugin
+type PluginStatus struct {
+	Slug string `json:"slug"`
+
+	// Version is the installed version, empty when not installed
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Active is whether the plugin is active
+	Active bool `json:"active"`
+
+	// Error is why the plugin could not be brought to its declared state
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// ThemeStatus is the observed state of a theme
+type ThemeStatus struct {
+	Slug string `json:"slug"`
+
+	// Version is the installed version, empty when not installed
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Error is why the theme could not be brought to its declared state
+	// +optional
+	Error string `json:"error,omitempty"`
 }
 
+// ActiveThemeStatus is the observed active theme
+type ActiveThemeStatus struct {
+	// Slug is the active theme
+	Slug string `json:"slug"`
+
+	// Matches is whether the active theme is spec.activeTheme, or true when
+	// spec.activeTheme is empty
+	Matches bool `json:"matches"`
+}
+
+// CronStatus is the observed state of the WP-Cron CronJob
+type CronStatus struct {
+	// LastScheduleTime is when a run was last started
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastRunJob is the Job of the most recent finished run
+	// +optional
+	LastRunJob string `json:"lastRunJob,omitempty"`
+
+	// LastRunTime is when the most recent finished run ended
+	// +optional
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+
+	// LastRunSucceeded is whether the most recent finished run succeeded
+	// +optional
+	LastRunSucceeded bool `json:"lastRunSucceeded,omitempty"`
+
+	// LastRunMessage is why the most recent finished run failed
+	// +optional
+	LastRunMessage string `json:"lastRunMessage,omitempty"`
+
+	// LastSuccessfulTime is when a run last succeeded
+	// +optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+}
+
+// ObjectCacheStatus reports the statistics of the Redis object cache since
+// Redis last started
+type ObjectCacheStatus struct {
+	// Hits is the number of lookups of keys that were cached
+	Hits int64 `json:"hits"`
+
+	// Misses is the number of lookups of keys that were not cached
+	Misses int64 `json:"misses"`
+
+	// HitRatio is the share of lookups that were hits, e.g. "0.93"
+	// +optional
+	HitRatio string `json:"hitRatio,omitempty"`
+
+	// LastUpdateTime is when the statistics were last read
+	// +optional
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// Error is why the statistics could not be read at LastUpdateTime
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// ConditionReady is true once both tiers are available
+const ConditionReady = "Ready"
+
+// ConditionCloned is true once an instance with spec.cloneFrom has been
+// populated from its source
+const ConditionCloned = "Cloned"
+
+// ConditionImported is true once an instance with spec.bootstrap.import has
+// been populated from the imported site
+const ConditionImported = "Imported"
+
+// ConditionInstalled is true once WordPress is installed on an instance with
+// spec.site
+const ConditionInstalled = "Installed"
+
+// ConditionMultisite is true once an instance with spec.multisite has been
+// converted into a network
+const ConditionMultisite = "Multisite"
+
+// ConditionObjectCache is true while the Redis object cache of an instance
+// with spec.objectCache is enabled
+const ConditionObjectCache = "ObjectCache"
+
+// ConditionPageCache is true while the wordpress Service of an instance
+// with spec.pageCache is routed through Varnish
+const ConditionPageCache = "PageCache"
+
+// ConditionPluginsSynced is true once every plugin of spec.plugins is in its
+// declared state
+const ConditionPluginsSynced = "PluginsSynced"
+
+// ConditionThemesSynced is true once every theme of spec.themes is in its
+// declared state and spec.activeTheme is active
+const ConditionThemesSynced = "ThemesSynced"
+
+// ConditionFinalBackup tracks the backup taken when an instance with
+// spec.backup.onDelete is deleted
+const ConditionFinalBackup = "FinalBackup"
+
+// AnnotationForceDelete, set to "true" on an instance, lets its deletion
+// proceed without waiting for the final backup. Set on a WordpressPlugin,
+// WordpressUser or WordpressSite, it lets its deletion proceed without
+// removing the plugin, the account or the sub-site.
+const AnnotationForceDelete = "wordpress.example.com/force-delete"
+
+// AnnotationScopedNames is set to "true" by the operator on a clone created
+// in the namespace of its source, before any of its children exist. The
+// children of such an instance are prefixed with its name, and its pods
+// carry its instance label, so that they do not collide with those of the
+// source. The children of other instances keep their fixed names.
+const AnnotationScopedNames = "wordpress.example.com/scoped-names"
+
+// AnnotationVolumeClone is set to "true" by the operator, along with
+// AnnotationScopedNames, on a clone with spec.cloneFrom.volumeClone whose
+// source has claims that can be cloned. Its claims are then provisioned as
+// CSI volume clones of those of the source; without it the clone goes
+// through the staging backup.
+const AnnotationVolumeClone = "wordpress.example.com/volume-clone"
+
+// Reasons of the Ready condition, in bring-up order
+const (
+	ReasonCreatingSecret          = "CreatingSecret"
+	ReasonCreatingDatabaseStorage = "CreatingDatabaseStorage"
+	ReasonCreatingDatabase        = "CreatingDatabase"
+	ReasonWaitingForDatabase      = "WaitingForDatabase"
+	ReasonImporting               = "Importing"
+	ReasonCreatingFrontend        = "CreatingFrontend"
+	ReasonWaitingForFrontend      = "WaitingForFrontend"
+	ReasonCloning                 = "Cloning"
+	ReasonInstalling              = "Installing"
+	ReasonEnablingMultisite       = "EnablingMultisite"
+	ReasonRestoringSnapshots      = "RestoringSnapshots"
+	ReasonAvailable               = "Available"
+	ReasonDeleting                = "Deleting"
+)
+
+// Reasons of the Imported condition
+const (
+	ReasonImported      = "Imported"
+	ReasonImportSkipped = "Skipped"
+	ReasonImportFailed  = "Failed"
+)
+
+// Reasons of the Cloned condition
+const (
+	ReasonCloned      = "Cloned"
+	ReasonCloneFailed = "Failed"
+)
+
+// Reasons of the Installed condition
+const (
+	ReasonInstalled        = "Installed"
+	ReasonAlreadyInstalled = "AlreadyInstalled"
+	ReasonInstallFailed    = "Failed"
+)
+
+// Reasons of the Multisite condition
+const (
+	ReasonMultisiteEnabled = "Enabled"
+	ReasonMultisiteFailed  = "Failed"
+)
+
+// Reasons of the ObjectCache condition
+const (
+	ReasonObjectCacheDeploying     = "Deploying"
+	ReasonObjectCacheEnabling      = "Enabling"
+	ReasonObjectCacheEnabled       = "Enabled"
+	ReasonObjectCacheEnableFailed  = "EnableFailed"
+	ReasonObjectCacheDisabling     = "Disabling"
+	ReasonObjectCacheDisabled      = "Disabled"
+	ReasonObjectCacheDisableFailed = "DisableFailed"
+)
+
+// Reasons of the PageCache condition
+const (
+	ReasonPageCacheDeploying = "Deploying"
+	ReasonPageCacheEnabled   = "Enabled"
+	ReasonPageCacheDisabled  = "Disabled"
+)
+
+// Reasons of the PluginsSynced and ThemesSynced conditions
+const (
+	ReasonExtensionsInvalid    = "Invalid"
+	ReasonExtensionsSyncing    = "Syncing"
+	ReasonExtensionsSyncFailed = "Failed"
+	ReasonExtensionsSynced     = "Synced"
+
+	// ReasonActiveThemeMismatch is only set on ThemesSynced
+	ReasonActiveThemeMismatch = "ActiveThemeMismatch"
+)
+
+// Reasons of the FinalBackup condition
+const (
+	ReasonFinalBackupInProgress = "InProgress"
+	ReasonFinalBackupCompleted  = "Completed"
+	ReasonFinalBackupFailed     = "Failed"
+	ReasonFinalBackupTimedOut   = "TimedOut"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Stage",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+// +kubebuilder:printcolumn:name="Last Backup",type=date,JSONPath=`.status.lastSuccessfulBackupTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Wordpress is the Schema for the wordpresses API
 type Wordpress struct {
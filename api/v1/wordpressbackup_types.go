@@ -0,0 +1,269 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WordpressBackupSpec defines the desired state of WordpressBackup
+type WordpressBackupSpec struct {
+	// WordpressRef is the name of the Wordpress instance, in the same
+	// namespace, to back up
+	WordpressRef string `json:"wordpressRef"`
+
+	// Method is how the backup is taken. Archive dumps the database and
+	// archives wp-content to the target; Snapshot takes CSI VolumeSnapshots of
+	// both volumes while the database is locked, which is much faster for
+	// large sites but keeps the backup in the cluster's storage.
+	// +kubebuilder:default=Archive
+	// +optional
+	Method BackupMethod `json:"method,omitempty"`
+
+	// Target is where the artifacts of an archive backup are written.
+	// Required for the Archive method.
+	// +optional
+	Target BackupTarget `json:"target,omitempty"`
+
+	// VolumeSnapshotClassName is the class of the snapshots taken by the
+	// Snapshot method. Defaults to the default class of the CSI driver.
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// Encryption encrypts the artifacts of an archive backup before they
+	// leave the pod
+	// +optional
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+
+	// Verify test-restores a completed archive backup into a throwaway MySQL
+	// server and checks it, recording the outcome in the Verified condition.
+	// A scheduled backup being verified only counts toward retention once
+	// verified.
+	// +optional
+	Verify bool `json:"verify,omitempty"`
+
+	// ArtifactPolicy decides whether the artifacts, or the VolumeSnapshots,
+	// are removed when the WordpressBackup is deleted. Scheduled backups use
+	// Delete so that pruning frees the space.
+	// +kubebuilder:default=Retain
+	// +optional
+	ArtifactPolicy ArtifactPolicy `json:"artifactPolicy,omitempty"`
+}
+
+// BackupEncryption configures client-side encryption of backup artifacts
+// with AES-256-GCM. To rotate keys, add the new key to the Secret and switch
+// keyID to it; older backups record the key they were encrypted with and stay
+// restorable for as long as that key is kept in the Secret.
+type BackupEncryption struct {
+	// SecretRef names a Secret in the backup's namespace holding the keys,
+	// each a 32-byte key, raw or base64 encoded, under its key ID
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// KeyID is the key in the Secret new artifacts are encrypted with
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	KeyID string `json:"keyID"`
+}
+
+// BackupMethod is how a backup is taken
+// +kubebuilder:validation:Enum=Archive;Snapshot
+type BackupMethod string
+
+const (
+	BackupMethodArchive  BackupMethod = "Archive"
+	BackupMethodSnapshot BackupMethod = "Snapshot"
+)
+
+// ArtifactPolicy decides what happens to the artifacts of a deleted backup
+// +kubebuilder:validation:Enum=Retain;Delete
+type ArtifactPolicy string
+
+const (
+	ArtifactPolicyRetain ArtifactPolicy = "Retain"
+	ArtifactPolicyDelete ArtifactPolicy = "Delete"
+)
+
+const (
+	// LabelInstance names the Wordpress instance a backup belongs to
+	LabelInstance = "wordpress.example.com/instance"
+
+	// LabelScheduled marks backups created from spec.backup.schedule
+	LabelScheduled = "wordpress.example.com/scheduled"
+)
+
+// BackupTarget describes where backup artifacts are stored. Exactly one
+// destination must be set.
+type BackupTarget struct {
+	// PersistentVolumeClaim stores the artifacts on a claim in the backup's
+	// namespace
+	// +optional
+	PersistentVolumeClaim *PVCBackupTarget `json:"persistentVolumeClaim,omitempty"`
+
+	// S3 stores the artifacts in an S3-compatible object store
+	// +optional
+	S3 *S3BackupTarget `json:"s3,omitempty"`
+}
+
+// PVCBackupTarget stores backup artifacts on a PersistentVolumeClaim
+type PVCBackupTarget struct {
+	// ClaimName is the name of the PersistentVolumeClaim
+	ClaimName string `json:"claimName"`
+
+	// Path is the directory on the claim under which a directory per backup
+	// is created. Defaults to the root of the claim.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// S3BackupTarget stores backup artifacts in a bucket of an S3-compatible
+// object store such as AWS S3 or MinIO
+type S3BackupTarget struct {
+	// Endpoint is the URL of the store, e.g. http://minio.minio:9000.
+	// Defaults to the AWS S3 endpoint of the region.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Bucket must already exist
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to the key of every artifact, e.g. "mysite"
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Region defaults to us-east-1
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// CredentialsSecretRef names a Secret in the backup's namespace holding
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+
+	// ForcePathStyle addresses the bucket as <endpoint>/<bucket> instead of
+	// <bucket>.<endpoint>, which MinIO and most self-hosted stores require
+	// +optional
+	ForcePathStyle bool `json:"forcePathStyle,omitempty"`
+}
+
+// BackupPhase is the lifecycle phase of a WordpressBackup
+// +kubebuilder:validation:Enum=Pending;Running;Completed;Failed
+type BackupPhase string
+
+const (
+	BackupPhasePending   BackupPhase = "Pending"
+	BackupPhaseRunning   BackupPhase = "Running"
+	BackupPhaseCompleted BackupPhase = "Completed"
+	BackupPhaseFailed    BackupPhase = "Failed"
+)
+
+// WordpressBackupStatus defines the observed state of WordpressBackup
+type WordpressBackupStatus struct {
+	// +optional
+	Phase BackupPhase `json:"phase,omitempty"`
+
+	// Message explains why the backup is pending or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// JobName is the Job taking the backup
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Location is the URL of the directory holding the artifacts, e.g.
+	// pvc://backups/mysite/mysite-20210101 or s3://backups/mysite/mysite-20210101
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Size is the total size of the artifacts in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// Checksum is the SHA-256 of the SHA256SUMS file listing the checksum of
+	// every artifact
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// EncryptionKeyID is the key the artifacts were encrypted with
+	// +optional
+	EncryptionKeyID string `json:"encryptionKeyID,omitempty"`
+
+	// Snapshots names the VolumeSnapshots taken by the Snapshot method
+	// +optional
+	Snapshots *BackupSnapshots `json:"snapshots,omitempty"`
+
+	// Conditions holds the Verified condition of backups with spec.verify
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionVerified is true once a test restore of the backup succeeded
+const ConditionVerified = "Verified"
+
+// Reasons of the Verified condition
+const (
+	ReasonVerifying          = "Verifying"
+	ReasonVerificationPassed = "Passed"
+	ReasonVerificationFailed = "Failed"
+)
+
+// BackupSnapshots names the VolumeSnapshots of a snapshot backup, in the
+// backup's namespace
+type BackupSnapshots struct {
+	// Database is the snapshot of the MySQL volume
+	Database string `json:"database"`
+
+	// Content is the snapshot of the wp-content volume
+	Content string `json:"content"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Wordpress",type=string,JSONPath=`.spec.wordpressRef`
+// +kubebuilder:printcolumn:name="Method",type=string,JSONPath=`.spec.method`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Verified",type=string,JSONPath=`.status.conditions[?(@.type=="Verified")].status`
+// +kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.size`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WordpressBackup is the Schema for the wordpressbackups API
+type WordpressBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WordpressBackupSpec   `json:"spec,omitempty"`
+	Status WordpressBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WordpressBackupList contains a list of WordpressBackup
+type WordpressBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WordpressBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WordpressBackup{}, &WordpressBackupList{})
+}
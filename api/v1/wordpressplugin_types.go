@@ -0,0 +1,101 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WordpressPluginSpec defines the desired state of WordpressPlugin
+type WordpressPluginSpec struct {
+	// WordpressRef is the name of the Wordpress instance, in the same
+	// namespace, to install the plugin on
+	WordpressRef string `json:"wordpressRef"`
+
+	PluginSpec `json:",inline"`
+}
+
+// PluginPhase is the lifecycle phase of a WordpressPlugin
+// +kubebuilder:validation:Enum=Pending;Syncing;Synced;Failed;Conflict;Removing
+type PluginPhase string
+
+const (
+	PluginPhasePending  PluginPhase = "Pending"
+	PluginPhaseSyncing  PluginPhase = "Syncing"
+	PluginPhaseSynced   PluginPhase = "Synced"
+	PluginPhaseFailed   PluginPhase = "Failed"
+	PluginPhaseConflict PluginPhase = "Conflict"
+	PluginPhaseRemoving PluginPhase = "Removing"
+)
+
+// WordpressPluginStatus defines the observed state of WordpressPlugin
+type WordpressPluginStatus struct {
+	// +optional
+	Phase PluginPhase `json:"phase,omitempty"`
+
+	// Message explains why the plugin is pending, in conflict or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Version is the installed version as of the last sync, empty when not
+	// installed
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Active is whether the plugin was active as of the last sync
+	// +optional
+	Active bool `json:"active,omitempty"`
+
+	// JobName is the Job syncing or removing the plugin
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// LastSyncTime is when the plugin was last brought to its declared state
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Wordpress",type=string,JSONPath=`.spec.wordpressRef`
+// +kubebuilder:printcolumn:name="Slug",type=string,JSONPath=`.spec.slug`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+// +kubebuilder:printcolumn:name="Active",type=boolean,JSONPath=`.status.active`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WordpressPlugin is the Schema for the wordpressplugins API
+type WordpressPlugin struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WordpressPluginSpec   `json:"spec,omitempty"`
+	Status WordpressPluginStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WordpressPluginList contains a list of WordpressPlugin
+type WordpressPluginList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WordpressPlugin `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WordpressPlugin{}, &WordpressPluginList{})
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WordpressRestoreSpec defines the desired state of WordpressRestore
+type WordpressRestoreSpec struct {
+	// WordpressRef is the name of the Wordpress instance, in the same
+	// namespace, to restore into
+	WordpressRef string `json:"wordpressRef"`
+
+	// BackupRef is the name of a completed WordpressBackup in the same
+	// namespace to restore from
+	// +optional
+	BackupRef string `json:"backupRef,omitempty"`
+
+	// Source locates the artifacts directly, for backups without a
+	// WordpressBackup object. The path of a persistentVolumeClaim, or the
+	// prefix of an s3 target, is the directory holding the artifacts.
+	// Exactly one of backupRef and source must be set, except for
+	// point-in-time restores, which take an optional backupRef.
+	// +optional
+	Source *BackupTarget `json:"source,omitempty"`
+
+	// DecryptionSecretRef names the Secret holding the keys of an encrypted
+	// source. Backups referenced by backupRef are decrypted with the Secret
+	// of their spec.encryption.
+	// +optional
+	DecryptionSecretRef *corev1.LocalObjectReference `json:"decryptionSecretRef,omitempty"`
+
+	// PointInTime rolls the restored database forward to this time by
+	// replaying the binlogs shipped through spec.backup.pointInTime of the
+	// instance. Without backupRef the latest archive backup completed before
+	// this time is used. Only the database is rolled forward; wp-content is
+	// restored as of the backup.
+	// +optional
+	PointInTime *metav1.Time `json:"pointInTime,omitempty"`
+
+	// SiteURL is the URL the restored site is served at. The database is
+	// search-replaced from the URL recorded in the backup to this one.
+	// Defaults to the site URL of the instance before the restore. Not
+	// supported for snapshot backups.
+	// +optional
+	SiteURL string `json:"siteURL,omitempty"`
+}
+
+// AnnotationRestoring is set on a Wordpress instance, to the name of the
+// WordpressRestore, while its volumes are replaced from snapshots. The
+// Wordpress controller leaves the instance alone while it is set.
+const AnnotationRestoring = "wordpress.example.com/restoring"
+
+// RestorePhase is the lifecycle phase of a WordpressRestore
+// +kubebuilder:validation:Enum=Pending;ScalingDown;Restoring;ScalingUp;Completed;Failed
+type RestorePhase string
+
+const (
+	RestorePhasePending     RestorePhase = "Pending"
+	RestorePhaseScalingDown RestorePhase = "ScalingDown"
+	RestorePhaseRestoring   RestorePhase = "Restoring"
+	RestorePhaseScalingUp   RestorePhase = "ScalingUp"
+	RestorePhaseCompleted   RestorePhase = "Completed"
+	RestorePhaseFailed      RestorePhase = "Failed"
+)
+
+// WordpressRestoreStatus defines the observed state of WordpressRestore
+type WordpressRestoreStatus struct {
+	// +optional
+	Phase RestorePhase `json:"phase,omitempty"`
+
+	// Message explains why the restore is pending or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Location is the URL of the artifacts being restored
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Backup is the WordpressBackup being restored, which for point-in-time
+	// restores is picked by the operator
+	// +optional
+	Backup string `json:"backup,omitempty"`
+
+	// JobName is the Job restoring the artifacts
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// FrontendReplicas is the replica count of the WordPress deployment
+	// before it was scaled down, restored once the data is in place
+	// +optional
+	FrontendReplicas *int32 `json:"frontendReplicas,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Wordpress",type=string,JSONPath=`.spec.wordpressRef`
+// +kubebuilder:printcolumn:name="Backup",type=string,JSONPath=`.status.backup`
+// +kubebuilder:printcolumn:name="Point In Time",type=date,JSONPath=`.spec.pointInTime`,priority=1
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WordpressRestore is the Schema for the wordpressrestores API
+type WordpressRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WordpressRestoreSpec   `json:"spec,omitempty"`
+	Status WordpressRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WordpressRestoreList contains a list of WordpressRestore
+type WordpressRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WordpressRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WordpressRestore{}, &WordpressRestoreList{})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WordpressSiteSpec defines the desired state of WordpressSite
+type WordpressSiteSpec struct {
+	// WordpressRef is the name of the Wordpress instance, in the same
+	// namespace, whose network the sub-site belongs to. The instance must
+	// have spec.multisite.
+	WordpressRef string `json:"wordpressRef"`
+
+	// Slug is the subdomain or subdirectory of the sub-site, depending on
+	// the mode of the network. Changing it moves the sub-site.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Slug string `json:"slug"`
+
+	// Title is the title of the sub-site
+	Title string `json:"title"`
+
+	// AdminEmail is the email address of the administrator the sub-site is
+	// created with, whose account is created when no account has it.
+	// Defaults to the network administrator.
+	// +optional
+	AdminEmail string `json:"adminEmail,omitempty"`
+
+	// Domain maps the sub-site to a domain of its own, e.g. shop.example.org,
+	// which is added to the Ingress of the network
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	// +optional
+	Domain string `json:"domain,omitempty"`
+
+	// Archived takes the sub-site offline without deleting it
+	// +optional
+	Archived bool `json:"archived,omitempty"`
+}
+
+// SitePhase is the lifecycle phase of a WordpressSite
+// +kubebuilder:validation:Enum=Pending;Syncing;Synced;Failed;Conflict;Removing
+type SitePhase string
+
+const (
+	SitePhasePending  SitePhase = "Pending"
+	SitePhaseSyncing  SitePhase = "Syncing"
+	SitePhaseSynced   SitePhase = "Synced"
+	SitePhaseFailed   SitePhase = "Failed"
+	SitePhaseConflict SitePhase = "Conflict"
+	SitePhaseRemoving SitePhase = "Removing"
+)
+
+// WordpressSiteStatus defines the observed state of WordpressSite
+type WordpressSiteStatus struct {
+	// +optional
+	Phase SitePhase `json:"phase,omitempty"`
+
+	// Message explains why the sub-site is pending, in conflict or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// BlogID is the WordPress ID of the sub-site
+	// +optional
+	BlogID int64 `json:"blogID,omitempty"`
+
+	// URL is the home URL of the sub-site
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// JobName is the Job syncing or deleting the sub-site
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// LastSyncTime is when the sub-site was last brought to its declared
+	// state
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Wordpress",type=string,JSONPath=`.spec.wordpressRef`
+// +kubebuilder:printcolumn:name="Slug",type=string,JSONPath=`.spec.slug`
+// +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`
+// +kubebuilder:printcolumn:name="Archived",type=boolean,JSONPath=`.spec.archived`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WordpressSite is the Schema for the wordpresssites API
+type WordpressSite struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WordpressSiteSpec   `json:"spec,omitempty"`
+	Status WordpressSiteStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WordpressSiteList contains a list of WordpressSite
+type WordpressSiteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WordpressSite `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WordpressSite{}, &WordpressSiteList{})
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WordpressUserSpec defines the desired state of WordpressUser
+type WordpressUserSpec struct {
+	// WordpressRef is the name of the Wordpress instance, in the same
+	// namespace, the account is created on
+	WordpressRef string `json:"wordpressRef"`
+
+	// Login is the user name of the account. WordPress cannot rename
+	// accounts, so changing it creates a new account and leaves the old one.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.@-]+$`
+	Login string `json:"login"`
+
+	// Email is the email address of the account
+	Email string `json:"email"`
+
+	// Role is the role of the account
+	Role UserRole `json:"role"`
+
+	// DisplayName is the name shown on the site. Defaults to the login.
+	// +optional
+	DisplayName string `json:"displayName,omitempty"`
+
+	// PasswordSecretRef names a Secret in the same namespace holding the
+	// password under the password key. The password is reset whenever the
+	// Secret changes. When empty, a password is generated into a Secret
+	// named after the WordpressUser.
+	// +optional
+	PasswordSecretRef *corev1.LocalObjectReference `json:"passwordSecretRef,omitempty"`
+
+	// ReassignPostsTo is the login of the account the posts of this one are
+	// given to when the WordpressUser is deleted. When empty, an account
+	// with posts is not deleted.
+	// +optional
+	ReassignPostsTo string `json:"reassignPostsTo,omitempty"`
+}
+
+// UserRole is the role of a WordPress account
+// +kubebuilder:validation:Enum=administrator;editor;author;contributor;subscriber
+type UserRole string
+
+const (
+	UserRoleAdministrator UserRole = "administrator"
+	UserRoleEditor        UserRole = "editor"
+	UserRoleAuthor        UserRole = "author"
+	UserRoleContributor   UserRole = "contributor"
+	UserRoleSubscriber    UserRole = "subscriber"
+)
+
+// UserPhase is the lifecycle phase of a WordpressUser
+// +kubebuilder:validation:Enum=Pending;Syncing;Synced;Failed;Conflict;Removing
+type UserPhase string
+
+const (
+	UserPhasePending  UserPhase = "Pending"
+	UserPhaseSyncing  UserPhase = "Syncing"
+	UserPhaseSynced   UserPhase = "Synced"
+	UserPhaseFailed   UserPhase = "Failed"
+	UserPhaseConflict UserPhase = "Conflict"
+	UserPhaseRemoving UserPhase = "Removing"
+)
+
+// WordpressUserStatus defines the observed state of WordpressUser
+type WordpressUserStatus struct {
+	// +optional
+	Phase UserPhase `json:"phase,omitempty"`
+
+	// Message explains why the account is pending, in conflict or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// UserID is the WordPress ID of the account
+	// +optional
+	UserID int64 `json:"userID,omitempty"`
+
+	// PasswordSecret is the Secret holding the password of the account
+	// +optional
+	PasswordSecret string `json:"passwordSecret,omitempty"`
+
+	// PasswordVersion is the resourceVersion of the password Secret as of
+	// the last time the password was set
+	// +optional
+	PasswordVersion string `json:"passwordVersion,omitempty"`
+
+	// JobName is the Job syncing or deleting the account
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// LastSyncTime is when the account was last brought to its declared
+	// state
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Wordpress",type=string,JSONPath=`.spec.wordpressRef`
+// +kubebuilder:printcolumn:name="Login",type=string,JSONPath=`.spec.login`
+// +kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.spec.role`
+// +kubebuilder:printcolumn:name="User ID",type=integer,JSONPath=`.status.userID`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WordpressUser is the Schema for the wordpressusers API
+type WordpressUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WordpressUserSpec   `json:"spec,omitempty"`
+	Status WordpressUserStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WordpressUserList contains a list of WordpressUser
+type WordpressUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WordpressUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WordpressUser{}, &WordpressUserList{})
+}
@@ -21,30 +21,1123 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveThemeStatus) DeepCopyInto(out *ActiveThemeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveThemeStatus.
+func (in *ActiveThemeStatus) DeepCopy() *ActiveThemeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveThemeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupEncryption) DeepCopyInto(out *BackupEncryption) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupEncryption.
+func (in *BackupEncryption) DeepCopy() *BackupEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupRetention) DeepCopyInto(out *BackupRetention) {
+	*out = *in
+	if in.MaxAge != nil {
+		in, out := &in.MaxAge, &out.MaxAge
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupRetention.
+func (in *BackupRetention) DeepCopy() *BackupRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupSnapshots) DeepCopyInto(out *BackupSnapshots) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupSnapshots.
+func (in *BackupSnapshots) DeepCopy() *BackupSnapshots {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupSnapshots)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupSpec) DeepCopyInto(out *BackupSpec) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(BackupEncryption)
+		**out = **in
+	}
+	in.Retention.DeepCopyInto(&out.Retention)
+	if in.PointInTime != nil {
+		in, out := &in.PointInTime, &out.PointInTime
+		*out = new(PointInTimeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OnDelete != nil {
+		in, out := &in.OnDelete, &out.OnDelete
+		*out = new(OnDeleteBackupSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupSpec.
+func (in *BackupSpec) DeepCopy() *BackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupTarget) DeepCopyInto(out *BackupTarget) {
+	*out = *in
+	if in.PersistentVolumeClaim != nil {
+		in, out := &in.PersistentVolumeClaim, &out.PersistentVolumeClaim
+		*out = new(PVCBackupTarget)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3BackupTarget)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupTarget.
+func (in *BackupTarget) DeepCopy() *BackupTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BootstrapSpec) DeepCopyInto(out *BootstrapSpec) {
+	*out = *in
+	if in.Import != nil {
+		in, out := &in.Import, &out.Import
+		*out = new(ImportSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootstrapSpec.
+func (in *BootstrapSpec) DeepCopy() *BootstrapSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BootstrapSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloneSource) DeepCopyInto(out *CloneSource) {
+	*out = *in
+	if in.Staging != nil {
+		in, out := &in.Staging, &out.Staging
+		*out = new(BackupTarget)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloneSource.
+func (in *CloneSource) DeepCopy() *CloneSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CloneSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronSpec) DeepCopyInto(out *CronSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronSpec.
+func (in *CronSpec) DeepCopy() *CronSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CronSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CronStatus) DeepCopyInto(out *CronStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastRunTime != nil {
+		in, out := &in.LastRunTime, &out.LastRunTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulTime != nil {
+		in, out := &in.LastSuccessfulTime, &out.LastSuccessfulTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CronStatus.
+func (in *CronStatus) DeepCopy() *CronStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CronStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatabaseSpec) DeepCopyInto(out *DatabaseSpec) {
+	*out = *in
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Probes.DeepCopyInto(&out.Probes)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatabaseSpec.
+func (in *DatabaseSpec) DeepCopy() *DatabaseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DatabaseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FrontendSpec) DeepCopyInto(out *FrontendSpec) {
+	*out = *in
+	in.Probes.DeepCopyInto(&out.Probes)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FrontendSpec.
+func (in *FrontendSpec) DeepCopy() *FrontendSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FrontendSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPImportSource) DeepCopyInto(out *HTTPImportSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPImportSource.
+func (in *HTTPImportSource) DeepCopy() *HTTPImportSource {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPImportSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImportSource) DeepCopyInto(out *ImportSource) {
+	*out = *in
+	if in.PersistentVolumeClaim != nil {
+		in, out := &in.PersistentVolumeClaim, &out.PersistentVolumeClaim
+		*out = new(PVCBackupTarget)
+		**out = **in
+	}
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPImportSource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImportSource.
+func (in *ImportSource) DeepCopy() *ImportSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ImportSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImportSpec) DeepCopyInto(out *ImportSpec) {
+	*out = *in
+	in.Source.DeepCopyInto(&out.Source)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImportSpec.
+func (in *ImportSpec) DeepCopy() *ImportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultisiteIngressSpec) DeepCopyInto(out *MultisiteIngressSpec) {
+	*out = *in
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultisiteIngressSpec.
+func (in *MultisiteIngressSpec) DeepCopy() *MultisiteIngressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MultisiteIngressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultisiteSpec) DeepCopyInto(out *MultisiteSpec) {
+	*out = *in
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(MultisiteIngressSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultisiteSpec.
+func (in *MultisiteSpec) DeepCopy() *MultisiteSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MultisiteSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectCachePersistence) DeepCopyInto(out *ObjectCachePersistence) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectCachePersistence.
+func (in *ObjectCachePersistence) DeepCopy() *ObjectCachePersistence {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectCachePersistence)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectCacheSpec) DeepCopyInto(out *ObjectCacheSpec) {
+	*out = *in
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.Persistence != nil {
+		in, out := &in.Persistence, &out.Persistence
+		*out = new(ObjectCachePersistence)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectCacheSpec.
+func (in *ObjectCacheSpec) DeepCopy() *ObjectCacheSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectCacheSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectCacheStatus) DeepCopyInto(out *ObjectCacheStatus) {
+	*out = *in
+	if in.LastUpdateTime != nil {
+		in, out := &in.LastUpdateTime, &out.LastUpdateTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectCacheStatus.
+func (in *ObjectCacheStatus) DeepCopy() *ObjectCacheStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectCacheStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnDeleteBackupSpec) DeepCopyInto(out *OnDeleteBackupSpec) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnDeleteBackupSpec.
+func (in *OnDeleteBackupSpec) DeepCopy() *OnDeleteBackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OnDeleteBackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PVCBackupTarget) DeepCopyInto(out *PVCBackupTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PVCBackupTarget.
+func (in *PVCBackupTarget) DeepCopy() *PVCBackupTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(PVCBackupTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PVCFileSource) DeepCopyInto(out *PVCFileSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PVCFileSource.
+func (in *PVCFileSource) DeepCopy() *PVCFileSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PVCFileSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PageCacheSpec) DeepCopyInto(out *PageCacheSpec) {
+	*out = *in
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PageCacheSpec.
+func (in *PageCacheSpec) DeepCopy() *PageCacheSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PageCacheSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginSource) DeepCopyInto(out *PluginSource) {
+	*out = *in
+	if in.PersistentVolumeClaim != nil {
+		in, out := &in.PersistentVolumeClaim, &out.PersistentVolumeClaim
+		*out = new(PVCFileSource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginSource.
+func (in *PluginSource) DeepCopy() *PluginSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginSpec) DeepCopyInto(out *PluginSpec) {
+	*out = *in
+	in.Source.DeepCopyInto(&out.Source)
+	if in.Active != nil {
+		in, out := &in.Active, &out.Active
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginSpec.
+func (in *PluginSpec) DeepCopy() *PluginSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginStatus) DeepCopyInto(out *PluginStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginStatus.
+func (in *PluginStatus) DeepCopy() *PluginStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PointInTimeSpec) DeepCopyInto(out *PointInTimeSpec) {
+	*out = *in
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(BackupTarget)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ShipInterval != nil {
+		in, out := &in.ShipInterval, &out.ShipInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PointInTimeSpec.
+func (in *PointInTimeSpec) DeepCopy() *PointInTimeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PointInTimeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbeSpec) DeepCopyInto(out *ProbeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbeSpec.
+func (in *ProbeSpec) DeepCopy() *ProbeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbesSpec) DeepCopyInto(out *ProbesSpec) {
+	*out = *in
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(ProbeSpec)
+		**out = **in
+	}
+	if in.Liveness != nil {
+		in, out := &in.Liveness, &out.Liveness
+		*out = new(ProbeSpec)
+		**out = **in
+	}
+	if in.Startup != nil {
+		in, out := &in.Startup, &out.Startup
+		*out = new(ProbeSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbesSpec.
+func (in *ProbesSpec) DeepCopy() *ProbesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3BackupTarget) DeepCopyInto(out *S3BackupTarget) {
+	*out = *in
+	out.CredentialsSecretRef = in.CredentialsSecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3BackupTarget.
+func (in *S3BackupTarget) DeepCopy() *S3BackupTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(S3BackupTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SiteSpec) DeepCopyInto(out *SiteSpec) {
+	*out = *in
+	out.AdminPasswordSecretRef = in.AdminPasswordSecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SiteSpec.
+func (in *SiteSpec) DeepCopy() *SiteSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SiteSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThemeSource) DeepCopyInto(out *ThemeSource) {
+	*out = *in
+	if in.PersistentVolumeClaim != nil {
+		in, out := &in.PersistentVolumeClaim, &out.PersistentVolumeClaim
+		*out = new(PVCFileSource)
+		**out = **in
+	}
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThemeSource.
+func (in *ThemeSource) DeepCopy() *ThemeSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ThemeSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThemeSpec) DeepCopyInto(out *ThemeSpec) {
+	*out = *in
+	in.Source.DeepCopyInto(&out.Source)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThemeSpec.
+func (in *ThemeSpec) DeepCopy() *ThemeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ThemeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThemeStatus) DeepCopyInto(out *ThemeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThemeStatus.
+func (in *ThemeStatus) DeepCopy() *ThemeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ThemeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Wordpress) DeepCopyInto(out *Wordpress) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Wordpress.
+func (in *Wordpress) DeepCopy() *Wordpress {
+	if in == nil {
+		return nil
+	}
+	out := new(Wordpress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Wordpress) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressBackup) DeepCopyInto(out *WordpressBackup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressBackup.
+func (in *WordpressBackup) DeepCopy() *WordpressBackup {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressBackup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressBackup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressBackupList) DeepCopyInto(out *WordpressBackupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WordpressBackup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressBackupList.
+func (in *WordpressBackupList) DeepCopy() *WordpressBackupList {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressBackupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressBackupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressBackupSpec) DeepCopyInto(out *WordpressBackupSpec) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(BackupEncryption)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressBackupSpec.
+func (in *WordpressBackupSpec) DeepCopy() *WordpressBackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressBackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressBackupStatus) DeepCopyInto(out *WordpressBackupStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Snapshots != nil {
+		in, out := &in.Snapshots, &out.Snapshots
+		*out = new(BackupSnapshots)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressBackupStatus.
+func (in *WordpressBackupStatus) DeepCopy() *WordpressBackupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressBackupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressList) DeepCopyInto(out *WordpressList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Wordpress, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressList.
+func (in *WordpressList) DeepCopy() *WordpressList {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressPlugin) DeepCopyInto(out *WordpressPlugin) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressPlugin.
+func (in *WordpressPlugin) DeepCopy() *WordpressPlugin {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressPlugin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressPlugin) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressPluginList) DeepCopyInto(out *WordpressPluginList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WordpressPlugin, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressPluginList.
+func (in *WordpressPluginList) DeepCopy() *WordpressPluginList {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressPluginList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressPluginList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressPluginSpec) DeepCopyInto(out *WordpressPluginSpec) {
+	*out = *in
+	in.PluginSpec.DeepCopyInto(&out.PluginSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressPluginSpec.
+func (in *WordpressPluginSpec) DeepCopy() *WordpressPluginSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressPluginSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressPluginStatus) DeepCopyInto(out *WordpressPluginStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressPluginStatus.
+func (in *WordpressPluginStatus) DeepCopy() *WordpressPluginStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressPluginStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressRestore) DeepCopyInto(out *WordpressRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressRestore.
+func (in *WordpressRestore) DeepCopy() *WordpressRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressRestoreList) DeepCopyInto(out *WordpressRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WordpressRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressRestoreList.
+func (in *WordpressRestoreList) DeepCopy() *WordpressRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressRestoreSpec) DeepCopyInto(out *WordpressRestoreSpec) {
+	*out = *in
+	if in.Source != nil {
+		in, out := &in.Source, &out.Source
+		*out = new(BackupTarget)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DecryptionSecretRef != nil {
+		in, out := &in.DecryptionSecretRef, &out.DecryptionSecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.PointInTime != nil {
+		in, out := &in.PointInTime, &out.PointInTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressRestoreSpec.
+func (in *WordpressRestoreSpec) DeepCopy() *WordpressRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressRestoreStatus) DeepCopyInto(out *WordpressRestoreStatus) {
+	*out = *in
+	if in.FrontendReplicas != nil {
+		in, out := &in.FrontendReplicas, &out.FrontendReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressRestoreStatus.
+func (in *WordpressRestoreStatus) DeepCopy() *WordpressRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressSite) DeepCopyInto(out *WordpressSite) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Wordpress.
-func (in *Wordpress) DeepCopy() *Wordpress {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressSite.
+func (in *WordpressSite) DeepCopy() *WordpressSite {
 	if in == nil {
 		return nil
 	}
-	out := new(Wordpress)
+	out := new(WordpressSite)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *Wordpress) DeepCopyObject() runtime.Object {
+func (in *WordpressSite) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -52,40 +1145,142 @@ func (in *Wordpress) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *WordpressList) DeepCopyInto(out *WordpressList) {
+func (in *WordpressSiteList) DeepCopyInto(out *WordpressSiteList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]Wordpress, len(*in))
+		*out = make([]WordpressSite, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressList.
-func (in *WordpressList) DeepCopy() *WordpressList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressSiteList.
+func (in *WordpressSiteList) DeepCopy() *WordpressSiteList {
 	if in == nil {
 		return nil
 	}
-	out := new(WordpressList)
+	out := new(WordpressSiteList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *WordpressList) DeepCopyObject() runtime.Object {
+func (in *WordpressSiteList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressSiteSpec) DeepCopyInto(out *WordpressSiteSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressSiteSpec.
+func (in *WordpressSiteSpec) DeepCopy() *WordpressSiteSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressSiteSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressSiteStatus) DeepCopyInto(out *WordpressSiteStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressSiteStatus.
+func (in *WordpressSiteStatus) DeepCopy() *WordpressSiteStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressSiteStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WordpressSpec) DeepCopyInto(out *WordpressSpec) {
 	*out = *in
+	in.Database.DeepCopyInto(&out.Database)
+	in.Frontend.DeepCopyInto(&out.Frontend)
+	if in.PHP != nil {
+		in, out := &in.PHP, &out.PHP
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Backup != nil {
+		in, out := &in.Backup, &out.Backup
+		*out = new(BackupSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CloneFrom != nil {
+		in, out := &in.CloneFrom, &out.CloneFrom
+		*out = new(CloneSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Bootstrap != nil {
+		in, out := &in.Bootstrap, &out.Bootstrap
+		*out = new(BootstrapSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedCloneNamespaces != nil {
+		in, out := &in.AllowedCloneNamespaces, &out.AllowedCloneNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Site != nil {
+		in, out := &in.Site, &out.Site
+		*out = new(SiteSpec)
+		**out = **in
+	}
+	if in.Multisite != nil {
+		in, out := &in.Multisite, &out.Multisite
+		*out = new(MultisiteSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Cron != nil {
+		in, out := &in.Cron, &out.Cron
+		*out = new(CronSpec)
+		**out = **in
+	}
+	if in.ObjectCache != nil {
+		in, out := &in.ObjectCache, &out.ObjectCache
+		*out = new(ObjectCacheSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PageCache != nil {
+		in, out := &in.PageCache, &out.PageCache
+		*out = new(PageCacheSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Plugins != nil {
+		in, out := &in.Plugins, &out.Plugins
+		*out = make([]PluginSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Themes != nil {
+		in, out := &in.Themes, &out.Themes
+		*out = make([]ThemeSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressSpec.
@@ -101,6 +1296,46 @@ func (in *WordpressSpec) DeepCopy() *WordpressSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WordpressStatus) DeepCopyInto(out *WordpressStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulBackupTime != nil {
+		in, out := &in.LastSuccessfulBackupTime, &out.LastSuccessfulBackupTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Plugins != nil {
+		in, out := &in.Plugins, &out.Plugins
+		*out = make([]PluginStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Themes != nil {
+		in, out := &in.Themes, &out.Themes
+		*out = make([]ThemeStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.ActiveTheme != nil {
+		in, out := &in.ActiveTheme, &out.ActiveTheme
+		*out = new(ActiveThemeStatus)
+		**out = **in
+	}
+	if in.Cron != nil {
+		in, out := &in.Cron, &out.Cron
+		*out = new(CronStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ObjectCache != nil {
+		in, out := &in.ObjectCache, &out.ObjectCache
+		*out = new(ObjectCacheStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressStatus.
@@ -112,3 +1347,101 @@ func (in *WordpressStatus) DeepCopy() *WordpressStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressUser) DeepCopyInto(out *WordpressUser) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressUser.
+func (in *WordpressUser) DeepCopy() *WordpressUser {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressUser)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressUser) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressUserList) DeepCopyInto(out *WordpressUserList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WordpressUser, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressUserList.
+func (in *WordpressUserList) DeepCopy() *WordpressUserList {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressUserList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WordpressUserList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressUserSpec) DeepCopyInto(out *WordpressUserSpec) {
+	*out = *in
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressUserSpec.
+func (in *WordpressUserSpec) DeepCopy() *WordpressUserSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressUserSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WordpressUserStatus) DeepCopyInto(out *WordpressUserStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WordpressUserStatus.
+func (in *WordpressUserStatus) DeepCopy() *WordpressUserStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WordpressUserStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	backupMountPath = "/backup"
+	streamMountPath = "/stream"
+
+	awsCLIImage = "amazon/aws-cli:2.2.4"
+)
+
+// backupScript dumps the WordPress database in a single transaction, which
+// gives a consistent snapshot of its InnoDB tables without locking the site,
+// archives wp-content and reports the total size and the checksum of
+// SHA256SUMS through the termination message, which the operator reads back
+// into the WordpressBackup status.
+//
+// With CRYPT_KEY_ID set the artifacts are encrypted before they are written,
+// so SHA256SUMS lists the checksums of the ciphertext.
+//
+// With STREAM_ARTIFACTS set the artifacts are FIFOs read by the upload
+// container, so nothing is staged on disk. On failure the FIFOs that were not
+// reached yet are opened and closed so the uploader sees EOF instead of
+// blocking, and .failed tells it to exit non-zero.
+const backupScript = `set -Eeuo pipefail
+` + cryptFunctions + `
+work=$(mktemp -d)
+mkdir -p "$BACKUP_DIR"
+cd "$BACKUP_DIR"
+if [ -n "${STREAM_ARTIFACTS:-}" ]; then
+  mkfifo database.sql.gz wp-content.tar.gz
+  trap 'touch .failed; for f in database.sql.gz wp-content.tar.gz; do [ -p "$f" ] && timeout 5 sh -c ": > $f" || true; done' ERR
+fi
+
+# write_artifact NAME streams stdin into NAME, recording its checksum and size.
+write_artifact() {
+  mkfifo "$work/sum" "$work/count"
+  sha256sum < "$work/sum" | sed "s|-\$|$1|" >> "$work/SHA256SUMS" &
+  wc -c < "$work/count" >> "$work/sizes" &
+  tee "$work/sum" "$work/count" > "$1"
+  wait
+  rm "$work/sum" "$work/count"
+}
+
+# With binary logging on, the dump records the server UUID and its binlog
+# coordinates so a point-in-time restore can replay the binlogs after it.
+header=""
+master_data=""
+if [ "$(mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" -N -B -e 'SELECT @@log_bin')" = 1 ]; then
+  header="-- server_uuid: $(mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" -N -B -e 'SELECT @@server_uuid')"
+  master_data=--master-data=2
+fi
+
+{
+  [ -z "$header" ] || echo "$header"
+  mysqldump -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" $master_data \
+    --single-transaction --routines --triggers --events --databases wordpress
+} | gzip | seal | write_artifact database.sql.gz
+tar -C /var/www/html -cz wp-content | seal | write_artifact wp-content.tar.gz
+
+size=$(awk '{ total += $1 } END { print total }' "$work/sizes")
+checksum=$(sha256sum "$work/SHA256SUMS" | cut -d ' ' -f 1)
+cp "$work/SHA256SUMS" SHA256SUMS.tmp
+mv SHA256SUMS.tmp SHA256SUMS
+printf '{"size":%s,"checksum":"%s"}' "$size" "$checksum" > /dev/termination-log
+`
+
+// uploadScript streams each artifact from its FIFO to S3 with a multipart
+// upload, followed by SHA256SUMS once the backup container has written it.
+const uploadScript = `set -euo pipefail
+if [ -n "${S3_FORCE_PATH_STYLE:-}" ]; then
+  aws configure set default.s3.addressing_style path
+fi
+for f in database.sql.gz wp-content.tar.gz; do
+  until [ -p "$STREAM_DIR/$f" ]; do sleep 1; done
+  aws s3 cp --endpoint-url "$S3_ENDPOINT" - "$S3_URL/$f" < "$STREAM_DIR/$f"
+  [ ! -e "$STREAM_DIR/.failed" ]
+done
+until [ -f "$STREAM_DIR/SHA256SUMS" ] || [ -e "$STREAM_DIR/.failed" ]; do sleep 1; done
+[ ! -e "$STREAM_DIR/.failed" ]
+aws s3 cp --endpoint-url "$S3_ENDPOINT" "$STREAM_DIR/SHA256SUMS" "$S3_URL/SHA256SUMS"
+`
+
+// backupResult is written by backupScript to the termination message
+type backupResult struct {
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+func backupJobName(backup *wordpressv1.WordpressBackup) string {
+	return backup.Name + "-backup"
+}
+
+// backupDir is the directory, or for S3 the key prefix, of the artifacts
+// relative to the root of the target
+func backupDir(backup *wordpressv1.WordpressBackup) string {
+	if s3 := backup.Spec.Target.S3; s3 != nil {
+		return path.Join(s3.Prefix, backup.Name)
+	}
+	return path.Join(backup.Spec.Target.PersistentVolumeClaim.Path, backup.Name)
+}
+
+func backupLocation(backup *wordpressv1.WordpressBackup) string {
+	return targetLocation(backup.Spec.Target, backupDir(backup))
+}
+
+// targetLocation returns the URL of dir on the target
+func targetLocation(target wordpressv1.BackupTarget, dir string) string {
+	if target.S3 != nil {
+		return fmt.Sprintf("s3://%s/%s", target.S3.Bucket, dir)
+	}
+	return fmt.Sprintf("pvc://%s/%s", target.PersistentVolumeClaim.ClaimName, dir)
+}
+
+// validateBackupTarget checks that exactly one destination is set
+func validateBackupTarget(target wordpressv1.BackupTarget) error {
+	if (target.PersistentVolumeClaim == nil) == (target.S3 == nil) {
+		return fmt.Errorf("exactly one of persistentVolumeClaim and s3 must be set in the backup target")
+	}
+	return nil
+}
+
+func newBackupJob(backup *wordpressv1.WordpressBackup, wordpress *wordpressv1.Wordpress, operatorImage string) *batchv1.Job {
+	backoffLimit := int32(2)
+	activeDeadlineSeconds := int64(6 * 60 * 60)
+
+	backupContainer := v1.Container{
+		Image:   mysqlImage,
+		Name:    "backup",
+		Command: []string{"bash", "-c", backupScript},
+		Env: []v1.EnvVar{
+			mysqlHostEnv(wordpress),
+			mysqlRootPasswordEnv(wordpress),
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "wordpress-persistent-storage",
+				MountPath: "/var/www/html",
+				ReadOnly:  true,
+			},
+		},
+	}
+	containers := []v1.Container{}
+	volumes := []v1.Volume{
+		{
+			Name: "wordpress-persistent-storage",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: childName(wordpress, "wp-pv-claim"),
+					ReadOnly:  true,
+				},
+			},
+		},
+	}
+
+	if s3 := backup.Spec.Target.S3; s3 != nil {
+		backupContainer.Env = append(backupContainer.Env,
+			v1.EnvVar{Name: "BACKUP_DIR", Value: streamMountPath},
+			v1.EnvVar{Name: "STREAM_ARTIFACTS", Value: "true"},
+		)
+		backupContainer.VolumeMounts = append(backupContainer.VolumeMounts, v1.VolumeMount{
+			Name:      "stream",
+			MountPath: streamMountPath,
+		})
+		containers = append(containers, backupContainer, newS3UploadContainer(backup))
+		volumes = append(volumes, v1.Volume{
+			Name: "stream",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		})
+	} else {
+		backupContainer.Env = append(backupContainer.Env, v1.EnvVar{
+			Name:  "BACKUP_DIR",
+			Value: path.Join(backupMountPath, backupDir(backup)),
+		})
+		backupContainer.VolumeMounts = append(backupContainer.VolumeMounts, v1.VolumeMount{
+			Name:      "backup",
+			MountPath: backupMountPath,
+		})
+		containers = append(containers, backupContainer)
+		volumes = append(volumes, v1.Volume{
+			Name: "backup",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: backup.Spec.Target.PersistentVolumeClaim.ClaimName,
+				},
+			},
+		})
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupJobName(backup),
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				"app":                          "wordpress",
+				"wordpress.example.com/backup": backup.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                          "wordpress",
+						"wordpress.example.com/backup": backup.Name,
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Affinity:      frontendNodeAffinity(wordpress),
+					Containers:    containers,
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+
+	if encryption := backup.Spec.Encryption; encryption != nil {
+		addCrypt(&job.Spec.Template.Spec, operatorImage, []v1.LocalObjectReference{encryption.SecretRef}, encryption.KeyID, "backup")
+	}
+	return job
+}
+
+// newS3UploadContainer returns the container uploading the artifacts the
+// backup container streams into the shared FIFOs.
+func newS3UploadContainer(backup *wordpressv1.WordpressBackup) v1.Container {
+	s3 := backup.Spec.Target.S3
+
+	return v1.Container{
+		Image:   awsCLIImage,
+		Name:    "upload",
+		Command: []string{"bash", "-c", uploadScript},
+		Env:     append(s3Env(s3, backupLocation(backup)), v1.EnvVar{Name: "STREAM_DIR", Value: streamMountPath}),
+		EnvFrom: s3EnvFrom(s3),
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "stream",
+				MountPath: streamMountPath,
+			},
+		},
+	}
+}
+
+// s3Env configures the AWS CLI for the target. S3_URL is the s3:// URL of
+// the artifact directory.
+func s3Env(s3 *wordpressv1.S3BackupTarget, location string) []v1.EnvVar {
+	env := []v1.EnvVar{
+		{Name: "S3_URL", Value: location},
+		{Name: "S3_ENDPOINT", Value: s3Endpoint(s3)},
+		{Name: "AWS_DEFAULT_REGION", Value: s3Region(s3)},
+	}
+	if s3.ForcePathStyle {
+		env = append(env, v1.EnvVar{Name: "S3_FORCE_PATH_STYLE", Value: "true"})
+	}
+	return env
+}
+
+// s3EnvFrom loads the credentials Secret into the AWS CLI environment
+func s3EnvFrom(s3 *wordpressv1.S3BackupTarget) []v1.EnvFromSource {
+	return []v1.EnvFromSource{
+		{
+			SecretRef: &v1.SecretEnvSource{
+				LocalObjectReference: s3.CredentialsSecretRef,
+			},
+		},
+	}
+}
+
+// artifactCleanupJobName is the Job removing the artifacts of a deleted backup
+func artifactCleanupJobName(backup *wordpressv1.WordpressBackup) string {
+	return backup.Name + "-cleanup"
+}
+
+func newArtifactCleanupJob(backup *wordpressv1.WordpressBackup) *batchv1.Job {
+	backoffLimit := int32(2)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      artifactCleanupJobName(backup),
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				"app":                          "wordpress",
+				"wordpress.example.com/backup": backup.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                          "wordpress",
+						"wordpress.example.com/backup": backup.Name,
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers: []v1.Container{
+						{
+							Image:   mysqlImage,
+							Name:    "cleanup",
+							Command: []string{"sh", "-c", `rm -rf "$BACKUP_DIR"`},
+							Env: []v1.EnvVar{
+								{
+									Name:  "BACKUP_DIR",
+									Value: path.Join(backupMountPath, backupDir(backup)),
+								},
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "backup",
+									MountPath: backupMountPath,
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "backup",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+									ClaimName: backup.Spec.Target.PersistentVolumeClaim.ClaimName,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// frontendNodeAffinity schedules a pod next to the WordPress pods of the
+// instance, since its wp-pv-claim is ReadWriteOnce and can only be shared on
+// the same node.
+func frontendNodeAffinity(wordpress *wordpressv1.Wordpress) *v1.Affinity {
+	return &v1.Affinity{
+		PodAffinity: &v1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: tierLabels(wordpress, "frontend"),
+					},
+					TopologyKey: "kubernetes.io/hostname",
+				},
+			},
+		},
+	}
+}
+
+// jobTerminationMessage returns the termination message of the first
+// container that exited successfully in one of the Job's pods.
+func jobTerminationMessage(c client.Client, ctx context.Context, job *batchv1.Job) (string, error) {
+	pods := &v1.PodList{}
+	err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if terminated != nil && terminated.ExitCode == 0 && terminated.Message != "" {
+				return terminated.Message, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no termination message found for job %s", job.Name)
+}
+
+func parseBackupResult(message string) (backupResult, error) {
+	result := backupResult{}
+	err := json.Unmarshal([]byte(message), &result)
+	return result, err
+}
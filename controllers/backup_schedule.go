@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// reconcileBackupSchedule creates a WordpressBackup when spec.backup.schedule
+// is due, prunes scheduled backups outside the retention policy and records
+// the last successful backup in the status. The returned result requeues the
+// instance for the next scheduled run.
+func reconcileBackupSchedule(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	backups, err := listInstanceBackups(r, ctx, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = updateLastSuccessfulBackup(r, ctx, wordpress, backups)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if wordpress.Spec.Backup == nil || wordpress.Spec.Backup.Schedule == "" {
+		return ctrl.Result{}, nil
+	}
+
+	schedule, err := cron.ParseStandard(wordpress.Spec.Backup.Schedule)
+	if err != nil {
+		log.Error(err, "Invalid backup schedule", "schedule", wordpress.Spec.Backup.Schedule)
+		return ctrl.Result{}, err
+	}
+
+	err = pruneScheduledBackups(r, ctx, log, wordpress, backups, time.Now())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	last := wordpress.CreationTimestamp.Time
+	if wordpress.Status.LastScheduleTime != nil {
+		last = wordpress.Status.LastScheduleTime.Time
+	}
+
+	next := schedule.Next(last)
+	if next.After(now) {
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+	}
+
+	// Runs missed while the operator was down are collapsed into this one.
+	backup := newScheduledBackup(wordpress, next)
+	err = r.Create(ctx, backup)
+	if err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create scheduled WordpressBackup", "backup.name", backup.Name)
+		return ctrl.Result{}, err
+	}
+	log.Info("Created scheduled WordpressBackup", "backup.name", backup.Name)
+
+	scheduled := metav1.NewTime(now)
+	wordpress.Status.LastScheduleTime = &scheduled
+	err = r.Status().Update(ctx, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: schedule.Next(now).Sub(now)}, nil
+}
+
+// newScheduledBackup returns the backup for the run scheduled at t. Backups
+// deliberately have no owner reference so that they outlive the instance.
+func newScheduledBackup(wordpress *wordpressv1.Wordpress, t time.Time) *wordpressv1.WordpressBackup {
+	return &wordpressv1.WordpressBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", wordpress.Name, t.UTC().Format("20060102-1504")),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app":                      "wordpress",
+				wordpressv1.LabelInstance:  wordpress.Name,
+				wordpressv1.LabelScheduled: "true",
+			},
+		},
+		Spec: wordpressv1.WordpressBackupSpec{
+			WordpressRef:            wordpress.Name,
+			Method:                  wordpress.Spec.Backup.Method,
+			Target:                  *wordpress.Spec.Backup.Target.DeepCopy(),
+			VolumeSnapshotClassName: wordpress.Spec.Backup.VolumeSnapshotClassName,
+			Encryption:              wordpress.Spec.Backup.Encryption.DeepCopy(),
+			Verify:                  wordpress.Spec.Backup.Verify,
+			ArtifactPolicy:          wordpressv1.ArtifactPolicyDelete,
+		},
+	}
+}
+
+// listInstanceBackups returns the backups of the instance, newest first.
+func listInstanceBackups(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) ([]wordpressv1.WordpressBackup, error) {
+	list := &wordpressv1.WordpressBackupList{}
+	err := r.List(ctx, list, client.InNamespace(wordpress.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []wordpressv1.WordpressBackup{}
+	for _, backup := range list.Items {
+		if backup.Spec.WordpressRef == wordpress.Name {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[j].CreationTimestamp.Before(&backups[i].CreationTimestamp)
+	})
+	return backups, nil
+}
+
+func updateLastSuccessfulBackup(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress, backups []wordpressv1.WordpressBackup) error {
+	var latest *wordpressv1.WordpressBackup
+	for i := range backups {
+		backup := &backups[i]
+		if backup.Status.Phase != wordpressv1.BackupPhaseCompleted || backup.Status.CompletionTime == nil {
+			continue
+		}
+		if latest == nil || latest.Status.CompletionTime.Before(backup.Status.CompletionTime) {
+			latest = backup
+		}
+	}
+	if latest == nil || latest.Name == wordpress.Status.LastSuccessfulBackup {
+		return nil
+	}
+
+	wordpress.Status.LastSuccessfulBackup = latest.Name
+	wordpress.Status.LastSuccessfulBackupTime = latest.Status.CompletionTime
+	return r.Status().Update(ctx, wordpress)
+}
+
+// pruneScheduledBackups deletes the completed and failed scheduled backups
+// beyond spec.backup.retention. The artifacts are removed by the
+// WordpressBackup controller before the objects go away.
+func pruneScheduledBackups(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, backups []wordpressv1.WordpressBackup, now time.Time) error {
+	retention := wordpress.Spec.Backup.Retention
+	completed := int32(0)
+
+	for i := range backups {
+		backup := &backups[i]
+		if backup.Labels[wordpressv1.LabelScheduled] != "true" || backup.DeletionTimestamp != nil {
+			continue
+		}
+
+		switch backupRetentionPhase(backup) {
+		case wordpressv1.BackupPhaseCompleted:
+			completed++
+			if completed == 1 {
+				continue
+			}
+		case wordpressv1.BackupPhaseFailed:
+			// Failed runs are only kept until a newer backup completes.
+			if completed == 0 {
+				continue
+			}
+		default:
+			continue
+		}
+
+		expired := retention.KeepLast > 0 && completed > retention.KeepLast
+		if retention.MaxAge != nil && now.Sub(backup.CreationTimestamp.Time) > retention.MaxAge.Duration {
+			expired = true
+		}
+		if backupRetentionPhase(backup) == wordpressv1.BackupPhaseFailed {
+			expired = true
+		}
+		if !expired {
+			continue
+		}
+
+		err := r.Delete(ctx, backup)
+		if err != nil {
+			log.Error(err, "Failed to prune WordpressBackup", "backup.name", backup.Name)
+			return err
+		}
+		log.Info("Pruned WordpressBackup", "backup.name", backup.Name)
+	}
+	return nil
+}
+
+// backupRetentionPhase is the phase of a backup as far as retention is
+// concerned: a backup being verified only counts as completed once verified,
+// and one that failed verification counts as failed.
+func backupRetentionPhase(backup *wordpressv1.WordpressBackup) wordpressv1.BackupPhase {
+	if backup.Status.Phase != wordpressv1.BackupPhaseCompleted || !verificationRequested(backup) {
+		return backup.Status.Phase
+	}
+
+	verified := meta.FindStatusCondition(backup.Status.Conditions, wordpressv1.ConditionVerified)
+	switch {
+	case verified == nil || verified.Status == metav1.ConditionUnknown:
+		return wordpressv1.BackupPhaseRunning
+	case verified.Status == metav1.ConditionTrue:
+		return wordpressv1.BackupPhaseCompleted
+	default:
+		return wordpressv1.BackupPhaseFailed
+	}
+}
@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// testBackup returns a scheduled backup of mysite created age before now.
+func testBackup(name string, age time.Duration, phase wordpressv1.BackupPhase, now time.Time) wordpressv1.WordpressBackup {
+	return wordpressv1.WordpressBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			Labels:            map[string]string{wordpressv1.LabelScheduled: "true"},
+		},
+		Spec: wordpressv1.WordpressBackupSpec{
+			WordpressRef: "mysite",
+		},
+		Status: wordpressv1.WordpressBackupStatus{
+			Phase: phase,
+		},
+	}
+}
+
+// verified marks the backup as verified with status, or as awaiting
+// verification when status is empty.
+func verified(backup wordpressv1.WordpressBackup, status metav1.ConditionStatus) wordpressv1.WordpressBackup {
+	backup.Spec.Verify = true
+	if status != "" {
+		backup.Status.Conditions = []metav1.Condition{
+			{Type: wordpressv1.ConditionVerified, Status: status, Reason: "Test"},
+		}
+	}
+	return backup
+}
+
+func unscheduled(backup wordpressv1.WordpressBackup) wordpressv1.WordpressBackup {
+	backup.Labels = nil
+	return backup
+}
+
+func TestPruneScheduledBackups(t *testing.T) {
+	now := time.Now()
+	completed, failed, running := wordpressv1.BackupPhaseCompleted, wordpressv1.BackupPhaseFailed, wordpressv1.BackupPhaseRunning
+	maxAge := &metav1.Duration{Duration: 24 * time.Hour}
+
+	tests := []struct {
+		name      string
+		retention wordpressv1.BackupRetention
+		backups   []wordpressv1.WordpressBackup
+		want      []string
+	}{
+		{
+			name:      "no retention keeps everything",
+			retention: wordpressv1.BackupRetention{},
+			backups: []wordpressv1.WordpressBackup{
+				testBackup("b1", 1*time.Hour, completed, now),
+				testBackup("b2", 2*time.Hour, completed, now),
+				testBackup("b3", 300*time.Hour, completed, now),
+			},
+			want: []string{"b1", "b2", "b3"},
+		},
+		{
+			name:      "keepLast prunes the oldest completed backups",
+			retention: wordpressv1.BackupRetention{KeepLast: 2},
+			backups: []wordpressv1.WordpressBackup{
+				testBackup("b1", 1*time.Hour, completed, now),
+				testBackup("b2", 2*time.Hour, completed, now),
+				testBackup("b3", 3*time.Hour, completed, now),
+				testBackup("b4", 4*time.Hour, completed, now),
+			},
+			want: []string{"b1", "b2"},
+		},
+		{
+			name:      "maxAge prunes old backups but keeps the newest completed one",
+			retention: wordpressv1.BackupRetention{MaxAge: maxAge},
+			backups: []wordpressv1.WordpressBackup{
+				testBackup("b1", 48*time.Hour, completed, now),
+				testBackup("b2", 72*time.Hour, completed, now),
+			},
+			want: []string{"b1"},
+		},
+		{
+			name:      "maxAge keeps recent backups",
+			retention: wordpressv1.BackupRetention{MaxAge: maxAge},
+			backups: []wordpressv1.WordpressBackup{
+				testBackup("b1", 1*time.Hour, completed, now),
+				testBackup("b2", 12*time.Hour, completed, now),
+				testBackup("b3", 36*time.Hour, completed, now),
+			},
+			want: []string{"b1", "b2"},
+		},
+		{
+			name:      "failed backups are kept until a newer one completes",
+			retention: wordpressv1.BackupRetention{KeepLast: 5},
+			backups: []wordpressv1.WordpressBackup{
+				testBackup("b1", 1*time.Hour, failed, now),
+				testBackup("b2", 2*time.Hour, completed, now),
+				testBackup("b3", 3*time.Hour, failed, now),
+			},
+			want: []string{"b1", "b2"},
+		},
+		{
+			name:      "running and unscheduled backups are never pruned",
+			retention: wordpressv1.BackupRetention{KeepLast: 1, MaxAge: maxAge},
+			backups: []wordpressv1.WordpressBackup{
+				testBackup("b1", 1*time.Hour, completed, now),
+				testBackup("b2", 48*time.Hour, running, now),
+				unscheduled(testBackup("b3", 72*time.Hour, completed, now)),
+				testBackup("b4", 96*time.Hour, completed, now),
+			},
+			want: []string{"b1", "b2", "b3"},
+		},
+		{
+			name:      "a backup awaiting verification does not count as completed",
+			retention: wordpressv1.BackupRetention{KeepLast: 1},
+			backups: []wordpressv1.WordpressBackup{
+				verified(testBackup("b1", 1*time.Hour, completed, now), ""),
+				testBackup("b2", 2*time.Hour, completed, now),
+				testBackup("b3", 3*time.Hour, completed, now),
+			},
+			want: []string{"b1", "b2"},
+		},
+		{
+			name:      "a backup that failed verification counts as failed",
+			retention: wordpressv1.BackupRetention{KeepLast: 5},
+			backups: []wordpressv1.WordpressBackup{
+				verified(testBackup("b1", 1*time.Hour, completed, now), metav1.ConditionTrue),
+				verified(testBackup("b2", 2*time.Hour, completed, now), metav1.ConditionFalse),
+			},
+			want: []string{"b1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := wordpressv1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			for i := range tt.backups {
+				builder = builder.WithObjects(tt.backups[i].DeepCopy())
+			}
+			r := &WordpressReconciler{Client: builder.Build(), Scheme: scheme}
+
+			wordpress := &wordpressv1.Wordpress{}
+			wordpress.Spec.Backup = &wordpressv1.BackupSpec{Retention: tt.retention}
+			ctx := context.Background()
+			if err := pruneScheduledBackups(r, ctx, logf.NullLogger{}, wordpress, tt.backups, now); err != nil {
+				t.Fatalf("pruneScheduledBackups() error = %v", err)
+			}
+
+			list := &wordpressv1.WordpressBackupList{}
+			if err := r.List(ctx, list, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, backup := range list.Items {
+				got = append(got, backup.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remaining backups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupRetentionPhase(t *testing.T) {
+	now := time.Now()
+	completed := wordpressv1.BackupPhaseCompleted
+
+	snapshot := verified(testBackup("b", 0, completed, now), "")
+	snapshot.Spec.Method = wordpressv1.BackupMethodSnapshot
+
+	tests := []struct {
+		name   string
+		backup wordpressv1.WordpressBackup
+		want   wordpressv1.BackupPhase
+	}{
+		{
+			name:   "running",
+			backup: testBackup("b", 0, wordpressv1.BackupPhaseRunning, now),
+			want:   wordpressv1.BackupPhaseRunning,
+		},
+		{
+			name:   "failed",
+			backup: testBackup("b", 0, wordpressv1.BackupPhaseFailed, now),
+			want:   wordpressv1.BackupPhaseFailed,
+		},
+		{
+			name:   "completed without verification",
+			backup: testBackup("b", 0, completed, now),
+			want:   completed,
+		},
+		{
+			name:   "completed awaiting verification",
+			backup: verified(testBackup("b", 0, completed, now), ""),
+			want:   wordpressv1.BackupPhaseRunning,
+		},
+		{
+			name:   "completed with verification unknown",
+			backup: verified(testBackup("b", 0, completed, now), metav1.ConditionUnknown),
+			want:   wordpressv1.BackupPhaseRunning,
+		},
+		{
+			name:   "completed and verified",
+			backup: verified(testBackup("b", 0, completed, now), metav1.ConditionTrue),
+			want:   completed,
+		},
+		{
+			name:   "completed and failed verification",
+			backup: verified(testBackup("b", 0, completed, now), metav1.ConditionFalse),
+			want:   wordpressv1.BackupPhaseFailed,
+		},
+		{
+			name:   "snapshots are not verified",
+			backup: snapshot,
+			want:   completed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backupRetentionPhase(&tt.backup); got != tt.want {
+				t.Errorf("backupRetentionPhase() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"path"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	binlogBasename  = "mysql-bin"
+	binlogMountPath = "/binlogs"
+
+	defaultBinlogShipInterval   = 5 * time.Minute
+	defaultBinlogExpireLogsDays = 7
+)
+
+// binlogFlushScript rotates the binlog every SHIP_INTERVAL seconds, unless
+// nothing was written to it, so that the shipper picks it up.
+const binlogFlushScript = `set -u
+while true; do
+  sleep "$SHIP_INTERVAL"
+  position=$(mysql -h 127.0.0.1 -uroot -p"$MYSQL_ROOT_PASSWORD" -N -B -e "SHOW MASTER STATUS" 2>/dev/null | cut -f 2)
+  # A binlog holding nothing but its header is not worth rotating.
+  if [ -n "$position" ] && [ "$position" -gt 120 ]; then
+    mysql -h 127.0.0.1 -uroot -p"$MYSQL_ROOT_PASSWORD" -e "FLUSH BINARY LOGS" || true
+  fi
+done
+`
+
+// binlogShipScript copies every closed binlog listed in the index to the
+// target, under a directory per server UUID since a new data directory
+// starts numbering its binlogs again. Shipped binlogs are remembered for the
+// lifetime of the container; after a restart they are shipped again.
+// Binlogs are encrypted into /tmp first when CRYPT_KEY_ID is set.
+const binlogShipScript = `set -uo pipefail
+` + cryptFunctions + `if [ -n "${S3_FORCE_PATH_STYLE:-}" ]; then
+  aws configure set default.s3.addressing_style path
+fi
+touch /tmp/shipped
+while true; do
+  sleep 10
+  index="$DATA_DIR/` + binlogBasename + `.index"
+  [ -f "$DATA_DIR/auto.cnf" ] && [ -f "$index" ] || continue
+  uuid=$(sed -n 's/^server-uuid=//p' "$DATA_DIR/auto.cnf")
+  # The last binlog in the index is still being written.
+  for log in $(head -n -1 "$index"); do
+    name=$(basename "$log")
+    grep -qx "$name" /tmp/shipped && continue
+    src="$DATA_DIR/$name"
+    if [ -n "${CRYPT_KEY_ID:-}" ]; then
+      seal < "$src" > "/tmp/$name" || continue
+      src="/tmp/$name"
+    fi
+    if [ -n "${S3_URL:-}" ]; then
+      aws s3 cp --only-show-errors --endpoint-url "$S3_ENDPOINT" "$src" "$S3_URL/$uuid/$name" || continue
+    else
+      mkdir -p "$BINLOG_DIR/$uuid" &&
+        cp "$src" "$BINLOG_DIR/$uuid/$name.tmp" &&
+        mv "$BINLOG_DIR/$uuid/$name.tmp" "$BINLOG_DIR/$uuid/$name" || continue
+    fi
+    rm -f "/tmp/$name"
+    echo "$name" >> /tmp/shipped
+  done
+done
+`
+
+// binlogTarget returns where the binlogs of the instance are shipped and the
+// directory, or S3 key prefix, holding them.
+func binlogTarget(wordpress *wordpressv1.Wordpress) (wordpressv1.BackupTarget, string) {
+	target := wordpress.Spec.Backup.Target
+	if wordpress.Spec.Backup.PointInTime.Target != nil {
+		target = *wordpress.Spec.Backup.PointInTime.Target
+	}
+
+	dir := wordpress.Name + "-binlogs"
+	if target.S3 != nil {
+		return target, path.Join(target.S3.Prefix, dir)
+	}
+	if target.PersistentVolumeClaim != nil {
+		return target, path.Join(target.PersistentVolumeClaim.Path, dir)
+	}
+	return target, dir
+}
+
+func pointInTimeEnabled(wordpress *wordpressv1.Wordpress) bool {
+	return wordpress.Spec.Backup != nil && wordpress.Spec.Backup.PointInTime != nil
+}
+
+// binlogConfig returns the server options enabling row-based binary logging.
+func binlogConfig(wordpress *wordpressv1.Wordpress) map[string]string {
+	expireLogsDays := int32(defaultBinlogExpireLogsDays)
+	if days := wordpress.Spec.Backup.PointInTime.ExpireLogsDays; days != 0 {
+		expireLogsDays = days
+	}
+	return map[string]string{
+		"log-bin":          binlogBasename,
+		"binlog_format":    "ROW",
+		"server-id":        "1",
+		"expire_logs_days": fmt.Sprint(expireLogsDays),
+	}
+}
+
+// newBinlogContainers returns the sidecars of the MySQL pod rotating and
+// shipping binlogs, and the volumes they need besides the data volume.
+func newBinlogContainers(wordpress *wordpressv1.Wordpress) ([]v1.Container, []v1.Volume) {
+	interval := defaultBinlogShipInterval
+	if shipInterval := wordpress.Spec.Backup.PointInTime.ShipInterval; shipInterval != nil && shipInterval.Duration > 0 {
+		interval = shipInterval.Duration
+	}
+	target, dir := binlogTarget(wordpress)
+
+	flush := v1.Container{
+		Image:   mysqlImage,
+		Name:    "binlog-flush",
+		Command: []string{"bash", "-c", binlogFlushScript},
+		Env: []v1.EnvVar{
+			{Name: "SHIP_INTERVAL", Value: fmt.Sprint(int64(interval.Seconds()))},
+			mysqlRootPasswordEnv(wordpress),
+		},
+	}
+
+	ship := v1.Container{
+		Name:    "binlog-ship",
+		Command: []string{"bash", "-c", binlogShipScript},
+		Env: []v1.EnvVar{
+			{Name: "DATA_DIR", Value: "/var/lib/mysql"},
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "mysql-persistent-storage",
+				MountPath: "/var/lib/mysql",
+				ReadOnly:  true,
+			},
+		},
+	}
+	volumes := []v1.Volume{}
+
+	if target.S3 != nil {
+		ship.Image = awsCLIImage
+		ship.Env = append(ship.Env, s3Env(target.S3, targetLocation(target, dir))...)
+		ship.EnvFrom = s3EnvFrom(target.S3)
+	} else if target.PersistentVolumeClaim != nil {
+		ship.Image = mysqlImage
+		ship.Env = append(ship.Env, v1.EnvVar{Name: "BINLOG_DIR", Value: path.Join(binlogMountPath, dir)})
+		ship.VolumeMounts = append(ship.VolumeMounts, v1.VolumeMount{
+			Name:      "binlogs",
+			MountPath: binlogMountPath,
+		})
+		volumes = append(volumes, v1.Volume{
+			Name: "binlogs",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: target.PersistentVolumeClaim.ClaimName,
+				},
+			},
+		})
+	}
+
+	return []v1.Container{flush, ship}, volumes
+}
@@ -0,0 +1,431 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// cloneSearchReplaceScript rewrites the site URL of a volume clone, including
+// inside serialized options, when it differs from SITE_URL.
+const cloneSearchReplaceScript = wpCLIPrelude + `
+old=$(wp option get siteurl) || fail "reading the site URL failed"
+if [ "$old" != "$SITE_URL" ]; then
+  wp search-replace "$old" "$SITE_URL" --all-tables --skip-columns=guid || fail "wp search-replace failed"
+fi
+`
+
+// reconcileClone populates an instance with spec.cloneFrom once it is up:
+// a WordpressBackup of the source is taken to the staging target and
+// restored into the instance with a WordpressRestore, after which the
+// staging backup and its artifacts are deleted. Volume clones are finished by
+// reconcileVolumeClone instead. It returns true once the instance has been
+// cloned, or when there is nothing to clone.
+func reconcileClone(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, string, error) {
+	clone := wordpress.Spec.CloneFrom
+	if clone == nil || meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionCloned) {
+		return true, "", nil
+	}
+	namespace := cloneNamespace(wordpress)
+
+	if cloned := meta.FindStatusCondition(wordpress.Status.Conditions, wordpressv1.ConditionCloned); cloned != nil && cloned.Reason == wordpressv1.ReasonCloneFailed {
+		return false, cloned.Message, nil
+	}
+	if volumeClone(wordpress) {
+		return reconcileVolumeClone(r, ctx, log, wordpress)
+	}
+
+	err := validateCloneSource(r, ctx, wordpress)
+	if err != nil {
+		return false, "", err
+	}
+
+	backup := &wordpressv1.WordpressBackup{}
+	err = r.Get(ctx, types.NamespacedName{Name: cloneBackupName(wordpress), Namespace: namespace}, backup)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	if errors.IsNotFound(err) {
+		backup = newCloneBackup(wordpress)
+		err = r.Create(ctx, backup)
+		if err != nil {
+			log.Error(err, "Failed to create staging WordpressBackup", "backup.namespace", backup.Namespace, "backup.name", backup.Name)
+			return false, "", err
+		}
+		log.Info("Created staging WordpressBackup for clone", "backup.namespace", backup.Namespace, "backup.name", backup.Name)
+		return false, fmt.Sprintf("Backing up %s/%s", namespace, clone.Name), nil
+	}
+
+	switch backup.Status.Phase {
+	case wordpressv1.BackupPhaseCompleted:
+	case wordpressv1.BackupPhaseFailed:
+		// Drop the failed backup so that the next reconcile takes a new one.
+		log.Info("Staging WordpressBackup failed, retrying", "backup.namespace", backup.Namespace, "backup.name", backup.Name)
+		return false, "", r.Delete(ctx, backup)
+	default:
+		return false, fmt.Sprintf("Backing up %s/%s", namespace, clone.Name), nil
+	}
+
+	restore := &wordpressv1.WordpressRestore{}
+	err = r.Get(ctx, types.NamespacedName{Name: cloneRestoreName(wordpress), Namespace: wordpress.Namespace}, restore)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	if errors.IsNotFound(err) {
+		restore = newCloneRestore(wordpress, backup)
+		if err := controllerutil.SetControllerReference(wordpress, restore, r.Scheme); err != nil {
+			return false, "", err
+		}
+		err = r.Create(ctx, restore)
+		if err != nil {
+			log.Error(err, "Failed to create WordpressRestore for clone", "restore.name", restore.Name)
+			return false, "", err
+		}
+		log.Info("Created WordpressRestore for clone", "restore.name", restore.Name)
+		return false, fmt.Sprintf("Restoring %s/%s", namespace, clone.Name), nil
+	}
+
+	switch restore.Status.Phase {
+	case wordpressv1.RestorePhaseCompleted:
+	case wordpressv1.RestorePhaseFailed:
+		// A failed restore is not retried automatically since it may have
+		// partly overwritten the instance.
+		message := fmt.Sprintf("WordpressRestore %s failed: %s", restore.Name, restore.Status.Message)
+		return false, message, setCondition(r, ctx, wordpress, wordpressv1.ConditionCloned, metav1.ConditionFalse, wordpressv1.ReasonCloneFailed, message)
+	default:
+		return false, fmt.Sprintf("Restoring %s/%s", namespace, clone.Name), nil
+	}
+
+	err = r.Delete(ctx, backup)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	log.Info("Cloned Wordpress", "source", namespace+"/"+clone.Name)
+	return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionCloned, metav1.ConditionTrue, wordpressv1.ReasonCloned,
+		fmt.Sprintf("Cloned from %s/%s", namespace, clone.Name))
+}
+
+// validateCloneSource checks that the source exists and, when it lives in
+// another namespace, grants the namespace of the clone.
+func validateCloneSource(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) error {
+	clone := wordpress.Spec.CloneFrom
+	namespace := cloneNamespace(wordpress)
+	if namespace == wordpress.Namespace {
+		if clone.Name == wordpress.Name {
+			return fmt.Errorf("spec.cloneFrom cannot name the instance itself")
+		}
+		if !scopedNames(wordpress) {
+			return fmt.Errorf("spec.cloneFrom can only name an instance in the same namespace when the instance is created")
+		}
+	}
+	if clone.Staging == nil {
+		return fmt.Errorf("spec.cloneFrom.staging must be set unless the volumes of the source are cloned")
+	}
+	if err := validateBackupTarget(*clone.Staging); err != nil {
+		return err
+	}
+	if namespace != wordpress.Namespace && clone.Staging.S3 == nil {
+		return fmt.Errorf("spec.cloneFrom.staging must be an s3 target for a source in another namespace")
+	}
+
+	source := &wordpressv1.Wordpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: clone.Name, Namespace: namespace}, source)
+	if err != nil {
+		return err
+	}
+	if namespace == wordpress.Namespace {
+		return nil
+	}
+	for _, allowed := range source.Spec.AllowedCloneNamespaces {
+		if allowed == wordpress.Namespace {
+			return nil
+		}
+	}
+	return fmt.Errorf("Wordpress %s/%s does not allow clones into namespace %s", namespace, clone.Name, wordpress.Namespace)
+}
+
+// cloneNamespace returns the namespace of the source of a clone.
+func cloneNamespace(wordpress *wordpressv1.Wordpress) string {
+	if namespace := wordpress.Spec.CloneFrom.Namespace; namespace != "" {
+		return namespace
+	}
+	return wordpress.Namespace
+}
+
+// reconcileCloneAnnotations prepares a clone in the namespace of its source
+// before any of its children are created: it gets scoped names, so that its
+// children do not collide with those of the source, and is marked for volume
+// cloning when requested and possible. An instance that has been reconciled
+// before keeps the names of its children. It returns true when the instance
+// was updated.
+func reconcileCloneAnnotations(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, error) {
+	if wordpress.Spec.CloneFrom == nil || cloneNamespace(wordpress) != wordpress.Namespace ||
+		scopedNames(wordpress) || len(wordpress.Status.Conditions) > 0 {
+		return false, nil
+	}
+
+	cloneable := false
+	if wordpress.Spec.CloneFrom.VolumeClone && wordpress.Spec.CloneFrom.Name != wordpress.Name {
+		var err error
+		cloneable, err = volumesCloneable(r, ctx, wordpress)
+		if err != nil {
+			return false, err
+		}
+		if !cloneable {
+			log.Info("The claims of the source cannot be cloned, cloning through the staging backup", "source", wordpress.Spec.CloneFrom.Name)
+		}
+	}
+
+	if wordpress.Annotations == nil {
+		wordpress.Annotations = map[string]string{}
+	}
+	wordpress.Annotations[wordpressv1.AnnotationScopedNames] = "true"
+	if cloneable {
+		wordpress.Annotations[wordpressv1.AnnotationVolumeClone] = "true"
+	}
+	err := r.Update(ctx, wordpress)
+	if err != nil {
+		log.Error(err, "Failed to scope the names of the children of the clone")
+		return false, err
+	}
+	log.Info("Scoped the names of the children of the clone", "source", wordpress.Spec.CloneFrom.Name, "volumeClone", cloneable)
+	return true, nil
+}
+
+// volumeClone reports whether the claims of the instance are cloned from
+// those of its source, see wordpressv1.AnnotationVolumeClone.
+func volumeClone(wordpress *wordpressv1.Wordpress) bool {
+	return wordpress.Annotations[wordpressv1.AnnotationVolumeClone] == "true"
+}
+
+// cloningVolumes reports whether missing claims and credentials of the
+// instance are to be taken from its source. Once cloned, the instance
+// provisions them as any other.
+func cloningVolumes(wordpress *wordpressv1.Wordpress) bool {
+	return volumeClone(wordpress) && !meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionCloned)
+}
+
+// volumesCloneable reports whether the claims of the source of a clone in its
+// namespace are bound and in a storage class provisioned by a CSI driver,
+// which CSI volume cloning requires. Whether the driver supports cloning
+// only shows once the claims of the clone are provisioned.
+func volumesCloneable(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) (bool, error) {
+	source, err := getCloneSource(r, ctx, wordpress)
+	if err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	for _, kind := range []string{"mysql", "wp"} {
+		claim := &v1.PersistentVolumeClaim{}
+		err = r.Get(ctx, types.NamespacedName{Name: childName(source, kind+"-pv-claim"), Namespace: source.Namespace}, claim)
+		if err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		if claim.Status.Phase != v1.ClaimBound || claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName == "" {
+			return false, nil
+		}
+
+		class := &storagev1.StorageClass{}
+		err = r.Get(ctx, types.NamespacedName{Name: *claim.Spec.StorageClassName}, class)
+		if err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		err = r.Get(ctx, types.NamespacedName{Name: class.Provisioner}, &storagev1.CSIDriver{})
+		if err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+	}
+	return true, nil
+}
+
+// getCloneSource returns the source of a clone.
+func getCloneSource(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) (*wordpressv1.Wordpress, error) {
+	source := &wordpressv1.Wordpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: wordpress.Spec.CloneFrom.Name, Namespace: cloneNamespace(wordpress)}, source)
+	return source, err
+}
+
+// cloneSourceClaim returns the claim of the source of a volume clone that
+// the claim of the given kind is cloned from.
+func cloneSourceClaim(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress, kind string) (*v1.PersistentVolumeClaim, error) {
+	source, err := getCloneSource(r, ctx, wordpress)
+	if err != nil {
+		return nil, err
+	}
+	claim := &v1.PersistentVolumeClaim{}
+	err = r.Get(ctx, types.NamespacedName{Name: childName(source, kind+"-pv-claim"), Namespace: source.Namespace}, claim)
+	return claim, err
+}
+
+// cloneSourceSecret returns the credentials Secret of the source of a volume
+// clone, whose root password the cloned database carries.
+func cloneSourceSecret(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) (*v1.Secret, error) {
+	source, err := getCloneSource(r, ctx, wordpress)
+	if err != nil {
+		return nil, err
+	}
+	secret := &v1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: childName(source, "mysql-pass"), Namespace: source.Namespace}, secret)
+	return secret, err
+}
+
+// newPVCFromClaim returns the claim of the instance provisioned as a CSI
+// volume clone of claim. A clone must be in the storage class of its source
+// and at least as large.
+func newPVCFromClaim(wordpress *wordpressv1.Wordpress, kind string, claim *v1.PersistentVolumeClaim) *v1.PersistentVolumeClaim {
+	pvc := newPVC(wordpress, kind)
+	pvc.Spec.StorageClassName = claim.Spec.StorageClassName
+	pvc.Spec.AccessModes = claim.Spec.AccessModes
+	pvc.Spec.DataSource = &v1.TypedLocalObjectReference{
+		Kind: "PersistentVolumeClaim",
+		Name: claim.Name,
+	}
+	size, ok := claim.Status.Capacity[v1.ResourceStorage]
+	if !ok {
+		size = claim.Spec.Resources.Requests[v1.ResourceStorage]
+	}
+	if !size.IsZero() {
+		pvc.Spec.Resources.Requests[v1.ResourceStorage] = size
+	}
+	return pvc
+}
+
+// reconcileVolumeClone finishes an instance whose claims were cloned from
+// those of its source: a Job search-replaces the site URL when
+// spec.cloneFrom.siteURL is set. A failed Job is not retried automatically.
+func reconcileVolumeClone(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, string, error) {
+	source := wordpress.Namespace + "/" + wordpress.Spec.CloneFrom.Name
+	if wordpress.Spec.CloneFrom.SiteURL != "" {
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: cloneJobName(wordpress), Namespace: wordpress.Namespace}, job)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, "", err
+		}
+		if errors.IsNotFound(err) {
+			job = newCloneJob(wordpress)
+			if err := controllerutil.SetControllerReference(wordpress, job, r.Scheme); err != nil {
+				return false, "", err
+			}
+			err = r.Create(ctx, job)
+			if err != nil {
+				log.Error(err, "Failed to create search-replace Job for clone", "job.name", job.Name)
+				return false, "", err
+			}
+			log.Info("Created search-replace Job for clone", "job.name", job.Name)
+			return false, fmt.Sprintf("Replacing the site URL of the clone of %s", source), nil
+		}
+
+		if jobFailed(job) {
+			message, err := jobFailureMessage(r.Client, ctx, job)
+			if err != nil {
+				return false, "", err
+			}
+			if message == "" {
+				message = fmt.Sprintf("Job %s failed", job.Name)
+			}
+			log.Info("Search-replace Job for clone failed", "job.name", job.Name)
+			return false, message, setCondition(r, ctx, wordpress, wordpressv1.ConditionCloned, metav1.ConditionFalse, wordpressv1.ReasonCloneFailed, message)
+		}
+		if job.Status.Succeeded == 0 {
+			return false, fmt.Sprintf("Replacing the site URL of the clone of %s", source), nil
+		}
+	}
+
+	log.Info("Cloned Wordpress volumes", "source", source)
+	return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionCloned, metav1.ConditionTrue, wordpressv1.ReasonCloned,
+		fmt.Sprintf("Cloned the volumes of %s", source))
+}
+
+func cloneJobName(wordpress *wordpressv1.Wordpress) string {
+	return wordpress.Name + "-clone-search-replace"
+}
+
+// newCloneJob returns the Job running cloneSearchReplaceScript with wp-cli
+// against the running volume clone.
+func newCloneJob(wordpress *wordpressv1.Wordpress) *batchv1.Job {
+	return newWPCLIJob(wordpress, cloneJobName(wordpress), "search-replace", cloneSearchReplaceScript, []v1.EnvVar{
+		{Name: "SITE_URL", Value: wordpress.Spec.CloneFrom.SiteURL},
+	})
+}
+
+// maxCloneBackupName leaves room for the longest suffix of the Jobs of a
+// backup, "-cleanup", within the 63 characters of the job-name label.
+const maxCloneBackupName = 55
+
+// cloneBackupName names the staging backup after the clone. Names too long
+// for the labels of its Jobs are truncated and made unique with a hash of
+// the clone.
+func cloneBackupName(wordpress *wordpressv1.Wordpress) string {
+	name := fmt.Sprintf("clone-%s-%s", wordpress.Namespace, wordpress.Name)
+	if len(name) <= maxCloneBackupName {
+		return name
+	}
+	sum := sha256.Sum256([]byte(wordpress.Namespace + "/" + wordpress.Name))
+	return fmt.Sprintf("%s-%x", name[:maxCloneBackupName-11], sum[:5])
+}
+
+func cloneRestoreName(wordpress *wordpressv1.Wordpress) string {
+	return wordpress.Name + "-clone"
+}
+
+// newCloneBackup returns the staging backup, created in the namespace of the
+// source. It cannot be owned by the clone across namespaces and is deleted,
+// along with its artifacts, once the clone is restored.
+func newCloneBackup(wordpress *wordpressv1.Wordpress) *wordpressv1.WordpressBackup {
+	clone := wordpress.Spec.CloneFrom
+	return &wordpressv1.WordpressBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cloneBackupName(wordpress),
+			Namespace: cloneNamespace(wordpress),
+			Labels: map[string]string{
+				"app":                     "wordpress",
+				wordpressv1.LabelInstance: clone.Name,
+			},
+		},
+		Spec: wordpressv1.WordpressBackupSpec{
+			WordpressRef:   clone.Name,
+			Target:         *clone.Staging.DeepCopy(),
+			ArtifactPolicy: wordpressv1.ArtifactPolicyDelete,
+		},
+	}
+}
+
+// newCloneRestore restores the staging backup. A backup in another namespace
+// cannot be referenced by a WordpressRestore and is restored through its raw
+// location.
+func newCloneRestore(wordpress *wordpressv1.Wordpress, backup *wordpressv1.WordpressBackup) *wordpressv1.WordpressRestore {
+	restore := &wordpressv1.WordpressRestore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cloneRestoreName(wordpress),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app":                     "wordpress",
+				wordpressv1.LabelInstance: wordpress.Name,
+			},
+		},
+		Spec: wordpressv1.WordpressRestoreSpec{
+			WordpressRef: wordpress.Name,
+			SiteURL:      wordpress.Spec.CloneFrom.SiteURL,
+		},
+	}
+
+	if backup.Namespace == wordpress.Namespace {
+		restore.Spec.BackupRef = backup.Name
+		return restore
+	}
+	source := wordpress.Spec.CloneFrom.Staging.DeepCopy()
+	source.S3.Prefix = path.Join(source.S3.Prefix, backup.Name)
+	restore.Spec.Source = source
+	return restore
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// TestCloneBackupName checks that the names of the Jobs of the staging
+// backup fit in a label value, and that truncated names stay unique.
+func TestCloneBackupName(t *testing.T) {
+	long := strings.Repeat("a", 63)
+	tests := []struct {
+		name      string
+		namespace string
+		want      string
+	}{
+		{name: "mysite", namespace: "staging", want: "clone-staging-mysite"},
+		{name: long, namespace: "staging"},
+		{name: "mysite", namespace: long},
+		{name: long + "b", namespace: long},
+	}
+
+	names := map[string]bool{}
+	for _, tt := range tests {
+		wordpress := &wordpressv1.Wordpress{ObjectMeta: metav1.ObjectMeta{Name: tt.name, Namespace: tt.namespace}}
+		name := cloneBackupName(wordpress)
+		if tt.want != "" && name != tt.want {
+			t.Errorf("cloneBackupName(%s/%s) = %q, want %q", tt.namespace, tt.name, name, tt.want)
+		}
+		backup := &wordpressv1.WordpressBackup{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		for _, job := range []string{backupJobName(backup), artifactCleanupJobName(backup), lockJobName(backup), verifyJobName(backup)} {
+			if len(job) > 63 {
+				t.Errorf("cloneBackupName(%s/%s) = %q, Job name %q is longer than a label value", tt.namespace, tt.name, name, job)
+			}
+		}
+		if names[name] {
+			t.Errorf("cloneBackupName(%s/%s) = %q, which another clone has", tt.namespace, tt.name, name)
+		}
+		names[name] = true
+	}
+}
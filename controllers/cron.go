@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	cronJobName       = "wordpress-cron"
+	cronConfigMapName = "wordpress-cron"
+	cronMuPluginFile  = "wordpress-operator-cron.php"
+
+	// cronSpecHashAnnotation records the hash of the CronJob spec the
+	// operator rendered, so that changes to spec.cron can be detected without
+	// comparing against the defaults filled in by the API server.
+	cronSpecHashAnnotation = "wordpress.example.com/cron-spec-hash"
+)
+
+// cronMuPlugin is mounted into the frontend as a must-use plugin, which is
+// loaded before WP-Cron would be spawned on a page view.
+const cronMuPlugin = `<?php
+// Managed by the wordpress operator: WP-Cron is run by the wordpress-cron CronJob.
+if ( ! defined( 'DISABLE_WP_CRON' ) ) {
+	define( 'DISABLE_WP_CRON', true );
+}
+`
+
+// cronScript runs the due WP-Cron events of the site, or of every active
+// site of a Multisite network, failing when any of them failed.
+const cronScript = wpCLIPrelude + `
+if wp core is-installed --network 2>/dev/null; then
+  urls=$(wp site list --field=url --archived=0 --deleted=0 --spam=0)
+else
+  urls=$(wp option get home) || fail "WordPress is not installed"
+fi
+failed=""
+for url in $urls; do
+  wp cron event run --due-now --url="$url" || failed="$failed $url"
+done
+[ -z "$failed" ] || fail "running due events failed on$failed"
+`
+
+// createCronConfigMap creates the ConfigMap holding the must-use plugin that
+// disables WP-Cron in the frontend of an instance with spec.cron.
+func createCronConfigMap(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	if wordpress.Spec.Cron == nil || !objectNotFound(r, ctx, childName(wordpress, cronConfigMapName), &v1.ConfigMap{}, *wordpress) {
+		return ctrl.Result{}, nil
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, cronConfigMapName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Data: map[string]string{
+			cronMuPluginFile: cronMuPlugin,
+		},
+	}
+	if err := controllerutil.SetControllerReference(wordpress, configMap, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	err := r.Create(ctx, configMap)
+	if err != nil {
+		log.Error(err, "Failed to create cron ConfigMap", "configmap.name", configMap.Name)
+		return ctrl.Result{}, err
+	}
+	log.Info("Returned custom cron ConfigMap object", "name", req.NamespacedName.Name)
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// addCronMuPlugin mounts the must-use plugin disabling WP-Cron into the
+// WordPress container of the frontend pod spec.
+func addCronMuPlugin(spec *v1.PodSpec, wordpress *wordpressv1.Wordpress) {
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: "wordpress-cron",
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{
+					Name: childName(wordpress, cronConfigMapName),
+				},
+			},
+		},
+	})
+	for i := range spec.Containers {
+		if spec.Containers[i].Name != "wordpress" {
+			continue
+		}
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, v1.VolumeMount{
+			Name:      "wordpress-cron",
+			MountPath: "/var/www/html/wp-content/mu-plugins/" + cronMuPluginFile,
+			SubPath:   cronMuPluginFile,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// reconcileCron keeps the CronJob running WP-Cron of an instance with
+// spec.cron in line with the spec and reports its runs, and removes the
+// CronJob and the must-use plugin once spec.cron is removed, which the
+// frontend has stopped mounting by then.
+func reconcileCron(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	found := &batchv1beta1.CronJob{}
+	err := r.Get(ctx, types.NamespacedName{Name: childName(wordpress, cronJobName), Namespace: wordpress.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if wordpress.Spec.Cron == nil {
+		if exists && metav1.IsControlledBy(found, wordpress) {
+			log.Info("Deleting cron CronJob", "cronjob.name", found.Name)
+			err = r.Delete(ctx, found, client.PropagationPolicy(metav1.DeletePropagationBackground))
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+		configMap := &v1.ConfigMap{}
+		err = r.Get(ctx, types.NamespacedName{Name: childName(wordpress, cronConfigMapName), Namespace: wordpress.Namespace}, configMap)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err == nil && metav1.IsControlledBy(configMap, wordpress) {
+			err = r.Delete(ctx, configMap)
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+		if wordpress.Status.Cron == nil {
+			return nil
+		}
+		wordpress.Status.Cron = nil
+		return r.Status().Update(ctx, wordpress)
+	}
+
+	desired := newCronJob(wordpress)
+	if !exists {
+		if err := controllerutil.SetControllerReference(wordpress, desired, r.Scheme); err != nil {
+			return err
+		}
+		err = r.Create(ctx, desired)
+		if err != nil {
+			log.Error(err, "Failed to create cron CronJob", "cronjob.name", desired.Name)
+			return err
+		}
+		log.Info("Returned custom cron CronJob object", "cronjob.name", desired.Name)
+		return nil
+	}
+
+	if found.Annotations[cronSpecHashAnnotation] != desired.Annotations[cronSpecHashAnnotation] {
+		found.Annotations = desired.Annotations
+		found.Spec = desired.Spec
+		err = r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update cron CronJob", "cronjob.name", found.Name)
+			return err
+		}
+		log.Info("Updated cron CronJob object", "cronjob.name", found.Name)
+	}
+	return updateCronStatus(r, ctx, wordpress, found)
+}
+
+// updateCronStatus reports the last schedule of the CronJob and the outcome
+// of its most recent finished run. The time of the last successful run is
+// kept once the Jobs of older runs have been cleaned up.
+func updateCronStatus(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress, cronJob *batchv1beta1.CronJob) error {
+	jobs := &batchv1.JobList{}
+	err := r.List(ctx, jobs, client.InNamespace(wordpress.Namespace),
+		client.MatchingLabels{wordpressv1.LabelInstance: wordpress.Name})
+	if err != nil {
+		return err
+	}
+
+	status := &wordpressv1.CronStatus{}
+	if wordpress.Status.Cron != nil {
+		status = wordpress.Status.Cron.DeepCopy()
+	}
+	status.LastScheduleTime = cronJob.Status.LastScheduleTime
+
+	var last *batchv1.Job
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		finished := jobFinishedAt(job)
+		if !metav1.IsControlledBy(job, cronJob) || finished == nil {
+			continue
+		}
+		if !jobFailed(job) && (status.LastSuccessfulTime == nil || status.LastSuccessfulTime.Before(finished)) {
+			status.LastSuccessfulTime = finished
+		}
+		if last == nil || jobFinishedAt(last).Before(finished) {
+			last = job
+		}
+	}
+	if last != nil && last.Name != status.LastRunJob {
+		status.LastRunJob = last.Name
+		status.LastRunTime = jobFinishedAt(last)
+		status.LastRunSucceeded = !jobFailed(last)
+		status.LastRunMessage = ""
+		if jobFailed(last) {
+			message, err := jobFailureMessage(r.Client, ctx, last)
+			if err != nil {
+				return err
+			}
+			if message == "" {
+				message = fmt.Sprintf("Job %s failed", last.Name)
+			}
+			status.LastRunMessage = message
+		}
+	}
+
+	if equality.Semantic.DeepEqual(wordpress.Status.Cron, status) {
+		return nil
+	}
+	wordpress.Status.Cron = status
+	return r.Status().Update(ctx, wordpress)
+}
+
+// newCronJob returns the CronJob running cronScript with wp-cli against the
+// running instance.
+func newCronJob(wordpress *wordpressv1.Wordpress) *batchv1beta1.CronJob {
+	cron := wordpress.Spec.Cron
+	schedule := cron.Schedule
+	if schedule == "" {
+		schedule = "*/5 * * * *"
+	}
+	policy := batchv1beta1.ConcurrencyPolicy(cron.ConcurrencyPolicy)
+	if policy == "" {
+		policy = batchv1beta1.ForbidConcurrent
+	}
+
+	cronJob := &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, cronJobName),
+			Namespace: wordpress.Namespace,
+			Labels:    wpCLIJobLabels(wordpress),
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:          schedule,
+			ConcurrencyPolicy: policy,
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: wpCLIJobLabels(wordpress),
+				},
+				Spec: wpCLIJobSpec(wordpress, "cron", cronScript, nil),
+			},
+		},
+	}
+
+	data, _ := json.Marshal(cronJob.Spec)
+	cronJob.Annotations = map[string]string{
+		cronSpecHashAnnotation: fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+	return cronJob
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// deletionPolicyFinalizer holds an instance with the Retain or Snapshot
+// deletion policy, or with spec.backup.onDelete, until its data has been kept
+// as requested. Every child is owned by the instance, so without it the
+// claims are garbage-collected.
+const deletionPolicyFinalizer = "wordpress.example.com/deletion-policy"
+
+// defaultFinalBackupTimeout bounds the backup taken on deletion
+const defaultFinalBackupTimeout = time.Hour
+
+// reconcileDeletionFinalizer adds the finalizer when the instance keeps data
+// on deletion and removes it when it no longer does. It returns true when the
+// instance was updated.
+func reconcileDeletionFinalizer(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) (bool, error) {
+	keepsData := wordpress.Spec.DeletionPolicy == wordpressv1.DeletionPolicyRetain || wordpress.Spec.DeletionPolicy == wordpressv1.DeletionPolicySnapshot ||
+		finalBackupRequested(wordpress)
+	hasFinalizer := controllerutil.ContainsFinalizer(wordpress, deletionPolicyFinalizer)
+
+	switch {
+	case keepsData && !hasFinalizer:
+		controllerutil.AddFinalizer(wordpress, deletionPolicyFinalizer)
+	case !keepsData && hasFinalizer:
+		controllerutil.RemoveFinalizer(wordpress, deletionPolicyFinalizer)
+	default:
+		return false, nil
+	}
+	return true, r.Update(ctx, wordpress)
+}
+
+// finalizeWordpress takes the final backup of a deleted instance, applies its
+// deletion policy and releases the finalizer once its data has been kept.
+func finalizeWordpress(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(wordpress, deletionPolicyFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	done, err := reconcileFinalBackup(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !done {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, nil
+	}
+
+	switch wordpress.Spec.DeletionPolicy {
+	case wordpressv1.DeletionPolicyRetain:
+		for _, name := range []string{"mysql-pv-claim", "wp-pv-claim"} {
+			if err := orphanChild(r, ctx, log, wordpress, childName(wordpress, name), &v1.PersistentVolumeClaim{}); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	case wordpressv1.DeletionPolicySnapshot:
+		done, waiting, err := snapshotForDeletion(r, ctx, log, wordpress)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !done {
+			return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+				wordpressv1.ReasonDeleting, waiting)
+		}
+	}
+
+	// The root password is baked into the database, so the Secret is kept
+	// along with the data.
+	if wordpress.Spec.DeletionPolicy == wordpressv1.DeletionPolicyRetain || wordpress.Spec.DeletionPolicy == wordpressv1.DeletionPolicySnapshot {
+		if err := orphanChild(r, ctx, log, wordpress, childName(wordpress, "mysql-pass"), &v1.Secret{}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(wordpress, deletionPolicyFinalizer)
+	return ctrl.Result{}, r.Update(ctx, wordpress)
+}
+
+func finalBackupRequested(wordpress *wordpressv1.Wordpress) bool {
+	return wordpress.Spec.Backup != nil && wordpress.Spec.Backup.OnDelete != nil
+}
+
+// reconcileFinalBackup takes a WordpressBackup of a deleted instance with
+// spec.backup.onDelete and reports progress through the FinalBackup
+// condition. It returns true once the backup has completed, when none was
+// requested, or when the instance is annotated for forced deletion. The
+// instance keeps running, and Ready, meanwhile so that it can be backed up.
+func reconcileFinalBackup(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, error) {
+	if !finalBackupRequested(wordpress) || meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionFinalBackup) {
+		return true, nil
+	}
+	if wordpress.Annotations[wordpressv1.AnnotationForceDelete] == "true" {
+		log.Info("Deleting Wordpress without waiting for the final backup")
+		return true, nil
+	}
+
+	backup := &wordpressv1.WordpressBackup{}
+	err := r.Get(ctx, types.NamespacedName{Name: finalBackupName(wordpress), Namespace: wordpress.Namespace}, backup)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	if errors.IsNotFound(err) {
+		backup = newFinalBackup(wordpress)
+		err = r.Create(ctx, backup)
+		if err != nil {
+			log.Error(err, "Failed to create final WordpressBackup", "backup.name", backup.Name)
+			return false, err
+		}
+		log.Info("Created final WordpressBackup", "backup.name", backup.Name)
+		return false, setCondition(r, ctx, wordpress, wordpressv1.ConditionFinalBackup, metav1.ConditionFalse, wordpressv1.ReasonFinalBackupInProgress,
+			fmt.Sprintf("Waiting for WordpressBackup %s", backup.Name))
+	}
+
+	escape := fmt.Sprintf("delete it to retry, or annotate the instance with %s=true to delete it anyway", wordpressv1.AnnotationForceDelete)
+	switch backup.Status.Phase {
+	case wordpressv1.BackupPhaseCompleted:
+		log.Info("Final WordpressBackup completed", "backup.name", backup.Name)
+		return true, setCondition(r, ctx, wordpress, wordpressv1.ConditionFinalBackup, metav1.ConditionTrue, wordpressv1.ReasonFinalBackupCompleted,
+			fmt.Sprintf("WordpressBackup %s completed", backup.Name))
+	case wordpressv1.BackupPhaseFailed:
+		return false, setCondition(r, ctx, wordpress, wordpressv1.ConditionFinalBackup, metav1.ConditionFalse, wordpressv1.ReasonFinalBackupFailed,
+			fmt.Sprintf("WordpressBackup %s failed: %s; %s", backup.Name, backup.Status.Message, escape))
+	}
+
+	timeout := defaultFinalBackupTimeout
+	if t := wordpress.Spec.Backup.OnDelete.Timeout; t != nil && t.Duration > 0 {
+		timeout = t.Duration
+	}
+	if time.Since(backup.CreationTimestamp.Time) > timeout {
+		return false, setCondition(r, ctx, wordpress, wordpressv1.ConditionFinalBackup, metav1.ConditionFalse, wordpressv1.ReasonFinalBackupTimedOut,
+			fmt.Sprintf("WordpressBackup %s did not complete within %s; %s", backup.Name, timeout, escape))
+	}
+	return false, nil
+}
+
+func finalBackupName(wordpress *wordpressv1.Wordpress) string {
+	return fmt.Sprintf("%s-final-%s", wordpress.Name, wordpress.DeletionTimestamp.UTC().Format("20060102-1504"))
+}
+
+// newFinalBackup returns the backup taken on deletion. It is taken like a
+// scheduled backup, but is not subject to retention and keeps its
+// artifacts when deleted. It is verified like one too: the verify Job runs
+// once the instance may be gone, so it does not follow its frontend.
+func newFinalBackup(wordpress *wordpressv1.Wordpress) *wordpressv1.WordpressBackup {
+	backup := newScheduledBackup(wordpress, wordpress.DeletionTimestamp.Time)
+	backup.Name = finalBackupName(wordpress)
+	delete(backup.Labels, wordpressv1.LabelScheduled)
+	backup.Spec.ArtifactPolicy = wordpressv1.ArtifactPolicyRetain
+	return backup
+}
+
+// orphanChild removes the owner reference to the instance from the named
+// child, so that it is not garbage-collected with the instance.
+func orphanChild(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, name string, obj client.Object) error {
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: wordpress.Namespace}, obj)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	refs := []metav1.OwnerReference{}
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != wordpress.UID {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == len(obj.GetOwnerReferences()) {
+		return nil
+	}
+
+	obj.SetOwnerReferences(refs)
+	err = r.Update(ctx, obj)
+	if err != nil {
+		log.Error(err, "Failed to orphan object", "name", name)
+		return err
+	}
+	log.Info("Retained object of deleted Wordpress", "name", name)
+	return nil
+}
+
+// snapshotForDeletion shuts the instance down and takes a VolumeSnapshot of
+// both claims. It returns true once the snapshots are ready to use, or what
+// it is waiting for.
+func snapshotForDeletion(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, string, error) {
+	for _, name := range []string{childName(wordpress, "wordpress"), childName(wordpress, "wordpress-mysql")} {
+		scaled, err := scaleDeployment(r.Client, ctx, log, wordpress.Namespace, name, 0)
+		if err != nil {
+			return false, "", err
+		}
+		if !scaled {
+			return false, fmt.Sprintf("Waiting for the %s deployment to shut down", name), nil
+		}
+	}
+
+	className := ""
+	if wordpress.Spec.Backup != nil {
+		className = wordpress.Spec.Backup.VolumeSnapshotClassName
+	}
+
+	ready := true
+	for _, kind := range []string{"mysql", "wp"} {
+		name := deletionSnapshotName(wordpress, kind)
+		state, err := getSnapshotState(r.Client, ctx, wordpress.Namespace, name)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, "", err
+		}
+		if errors.IsNotFound(err) {
+			snapshot := newClaimSnapshot(wordpress.Namespace, name, childName(wordpress, kind+"-pv-claim"), className, map[string]string{
+				"app":                     "wordpress",
+				wordpressv1.LabelInstance: wordpress.Name,
+			})
+			err = r.Create(ctx, snapshot)
+			if err != nil {
+				log.Error(err, "Failed to create VolumeSnapshot", "volumesnapshot.name", name)
+				return false, "", err
+			}
+			log.Info("Created VolumeSnapshot of deleted Wordpress", "volumesnapshot.name", name)
+			ready = false
+			continue
+		}
+		if state.err != "" {
+			return false, fmt.Sprintf("VolumeSnapshot %s failed: %s", name, state.err), nil
+		}
+		ready = ready && state.ready
+	}
+	if !ready {
+		return false, "Waiting for the VolumeSnapshots to become ready", nil
+	}
+	return true, "", nil
+}
+
+// deletionSnapshotName names the snapshot of the claim of kind after the
+// deletion time, so that instances deleted under the same name do not
+// collide.
+func deletionSnapshotName(wordpress *wordpressv1.Wordpress, kind string) string {
+	return fmt.Sprintf("%s-%s-%s", wordpress.Name, kind, wordpress.DeletionTimestamp.UTC().Format("20060102-150405"))
+}
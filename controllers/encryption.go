@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	wordpressv1 "wordpress-operator/api/v1"
+	"wordpress-operator/crypt"
+)
+
+const (
+	cryptMountPath     = "/crypt"
+	cryptKeysMountPath = "/crypt-keys"
+)
+
+// cryptFunctions are shell helpers for scripts handling encrypted artifacts.
+// seal encrypts stdin with CRYPT_KEY_ID when set; unseal writes the artifact
+// $1 to stdout, decrypting it when ARTIFACTS_ENCRYPTED is set.
+const cryptFunctions = `
+seal() {
+  if [ -n "${CRYPT_KEY_ID:-}" ]; then
+    "$CRYPT" crypt encrypt --keys "$CRYPT_KEYS" --key-id "$CRYPT_KEY_ID"
+  else
+    cat
+  fi
+}
+unseal() {
+  if [ -n "${ARTIFACTS_ENCRYPTED:-}" ]; then
+    "$CRYPT" crypt decrypt --keys "$CRYPT_KEYS" < "$1"
+  else
+    cat "$1"
+  fi
+}
+`
+
+// addCrypt makes the crypt command of the operator image available to the
+// named containers of spec: an init container copies the binary into a
+// shared volume, and the keys of the Secrets are mounted next to it. keyID is
+// the key to encrypt with, if any.
+func addCrypt(spec *v1.PodSpec, operatorImage string, secrets []v1.LocalObjectReference, keyID string, containers ...string) {
+	spec.InitContainers = append([]v1.Container{
+		{
+			Image:   operatorImage,
+			Name:    "install-crypt",
+			Command: []string{"/manager", "crypt", "install", cryptMountPath + "/wpcrypt"},
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      "crypt",
+					MountPath: cryptMountPath,
+				},
+			},
+		},
+	}, spec.InitContainers...)
+
+	sources := []v1.VolumeProjection{}
+	seen := map[string]bool{}
+	for _, secret := range secrets {
+		if seen[secret.Name] {
+			continue
+		}
+		seen[secret.Name] = true
+		sources = append(sources, v1.VolumeProjection{
+			Secret: &v1.SecretProjection{LocalObjectReference: secret},
+		})
+	}
+	spec.Volumes = append(spec.Volumes,
+		v1.Volume{
+			Name: "crypt",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+		v1.Volume{
+			Name: "crypt-keys",
+			VolumeSource: v1.VolumeSource{
+				Projected: &v1.ProjectedVolumeSource{Sources: sources},
+			},
+		},
+	)
+
+	env := []v1.EnvVar{
+		{Name: "CRYPT", Value: cryptMountPath + "/wpcrypt"},
+		{Name: "CRYPT_KEYS", Value: cryptKeysMountPath},
+	}
+	if keyID != "" {
+		env = append(env, v1.EnvVar{Name: "CRYPT_KEY_ID", Value: keyID})
+	}
+	mounts := []v1.VolumeMount{
+		{
+			Name:      "crypt",
+			MountPath: cryptMountPath,
+			ReadOnly:  true,
+		},
+		{
+			Name:      "crypt-keys",
+			MountPath: cryptKeysMountPath,
+			ReadOnly:  true,
+		},
+	}
+
+	for _, name := range containers {
+		for _, list := range [][]v1.Container{spec.InitContainers, spec.Containers} {
+			for i := range list {
+				if list[i].Name == name {
+					list[i].Env = append(list[i].Env, env...)
+					list[i].VolumeMounts = append(list[i].VolumeMounts, mounts...)
+				}
+			}
+		}
+	}
+}
+
+// validateEncryption checks that the key to encrypt with is in the Secret
+// and that the operator image, which provides the crypt command, is known.
+func validateEncryption(c client.Client, ctx context.Context, namespace string, encryption *wordpressv1.BackupEncryption, operatorImage string) error {
+	if operatorImage == "" {
+		return fmt.Errorf("encryption needs the operator image; start the manager with --operator-image")
+	}
+
+	secret := &v1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: encryption.SecretRef.Name, Namespace: namespace}, secret)
+	if err != nil {
+		return fmt.Errorf("encryption Secret %s: %v", encryption.SecretRef.Name, err)
+	}
+	data, ok := secret.Data[encryption.KeyID]
+	if !ok {
+		return fmt.Errorf("key %q not found in Secret %s", encryption.KeyID, secret.Name)
+	}
+	if _, err := crypt.ParseKey(data); err != nil {
+		return fmt.Errorf("key %q in Secret %s: %v", encryption.KeyID, secret.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,497 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	extensionSourcesMountPath = "/sources"
+
+	// labelSync names what a wp-cli sync Job reconciles
+	labelSync = "wordpress.example.com/sync"
+
+	// extensionResyncInterval is how long the result of a sync is trusted
+	// before extensions are synced again, undoing changes made in wp-admin
+	extensionResyncInterval = time.Hour
+
+	extensionPlugin = "plugin"
+	extensionTheme  = "theme"
+)
+
+// extensionsScript brings every plugin or theme, as EXTENSION_TYPE says, of
+// EXTENSIONS, a JSON list of desiredExtension, to its declared state or
+// removes it, and activates ACTIVE_THEME. It reports a syncResult through the termination
+// message. An extension that cannot be synced is reported with its error
+// rather than failing the others.
+const extensionsScript = `<?php
+$type = getenv( 'EXTENSION_TYPE' );
+
+function installed() {
+	global $type;
+	$extensions = array();
+	$list       = WP_CLI::runcommand( $type . ' list --format=json --fields=name,status,version', array( 'return' => true, 'parse' => 'json' ) );
+	foreach ( $list as $extension ) {
+		$extensions[ $extension['name'] ] = $extension;
+	}
+	return $extensions;
+}
+
+function run( $command ) {
+	$result = WP_CLI::runcommand( $command, array( 'return' => 'all', 'exit_error' => false ) );
+	return 0 === $result->return_code ? '' : substr( trim( $result->stderr ), 0, 200 );
+}
+
+function is_active( $extension ) {
+	return in_array( $extension['status'], array( 'active', 'active-network' ), true );
+}
+
+// Copies the files of a directory into place, swapping the whole directory
+// so that the site never sees a partial copy.
+function copy_extension( $source, $slug ) {
+	global $type;
+	$target  = WP_CONTENT_DIR . '/' . $type . 's/' . $slug;
+	$staging = $target . '.sync';
+	$command = 'rm -rf ' . escapeshellarg( $staging ) . ' && mkdir -p ' . escapeshellarg( $staging );
+	foreach ( glob( $source . '/*' ) as $file ) {
+		$command .= ' && cp -RL ' . escapeshellarg( $file ) . ' ' . escapeshellarg( $staging );
+	}
+	$command .= ' && rm -rf ' . escapeshellarg( $target ) . ' && mv ' . escapeshellarg( $staging ) . ' ' . escapeshellarg( $target );
+	exec( $command . ' 2>&1', $output, $code );
+	return 0 === $code ? '' : substr( implode( "\n", $output ), 0, 200 );
+}
+
+$result = array(
+	'items'  => array(),
+	'active' => '',
+	'error'  => '',
+);
+foreach ( json_decode( getenv( 'EXTENSIONS' ), true ) as $extension ) {
+	$slug      = $extension['slug'];
+	$installed = installed();
+	$current   = isset( $installed[ $slug ] ) ? $installed[ $slug ] : null;
+	$error     = '';
+
+	if ( ! empty( $extension['remove'] ) ) {
+		if ( $current ) {
+			$error = run( ( 'plugin' === $type ? 'plugin uninstall --deactivate ' : 'theme delete ' ) . escapeshellarg( $slug ) );
+		}
+		$installed = installed();
+		$current   = isset( $installed[ $slug ] ) ? $installed[ $slug ] : null;
+		if ( '' === $error && $current ) {
+			$error = 'the ' . $type . ' is still installed';
+		}
+		$result['items'][] = array(
+			'slug'    => $slug,
+			'version' => $current ? $current['version'] : '',
+			'active'  => $current && is_active( $current ),
+			'error'   => $error,
+		);
+		continue;
+	}
+
+	$outdated = ! $current || ( '' !== $extension['version'] && $current['version'] !== $extension['version'] );
+	if ( $extension['copy'] ) {
+		$error = copy_extension( $extension['source'], $slug );
+	} elseif ( $outdated ) {
+		$command = $type . ' install ' . escapeshellarg( $extension['source'] ) . ' --force';
+		if ( '' !== $extension['version'] && $extension['source'] === $slug ) {
+			$command .= ' --version=' . escapeshellarg( $extension['version'] );
+		}
+		$error = run( $command );
+	}
+	if ( $extension['copy'] || $outdated ) {
+		$installed = installed();
+		$current   = isset( $installed[ $slug ] ) ? $installed[ $slug ] : null;
+		if ( '' === $error && ! $current ) {
+			$error = 'the source does not hold a ' . $type . ' named ' . $slug;
+		} elseif ( '' === $error && '' !== $extension['version'] && $current['version'] !== $extension['version'] ) {
+			$error = 'the source holds version ' . $current['version'];
+		}
+	}
+
+	if ( 'plugin' === $type && '' === $error && $current && $extension['active'] !== is_active( $current ) ) {
+		$error     = run( 'plugin ' . ( $extension['active'] ? 'activate ' : 'deactivate ' ) . escapeshellarg( $slug ) );
+		$installed = installed();
+		$current   = $installed[ $slug ];
+	}
+
+	$result['items'][] = array(
+		'slug'    => $slug,
+		'version' => $current ? $current['version'] : '',
+		'active'  => $current && is_active( $current ),
+		'error'   => $error,
+	);
+}
+
+if ( 'theme' === $type ) {
+	$active    = getenv( 'ACTIVE_THEME' );
+	$installed = installed();
+	if ( '' !== $active && ! ( isset( $installed[ $active ] ) && is_active( $installed[ $active ] ) ) ) {
+		$result['error'] = run( 'theme activate ' . escapeshellarg( $active ) );
+		$installed       = installed();
+	}
+	foreach ( $installed as $name => $theme ) {
+		if ( is_active( $theme ) ) {
+			$result['active'] = $name;
+		}
+	}
+}
+file_put_contents( '/dev/termination-log', json_encode( $result ) );
+`
+
+// desiredExtension is a plugin or theme of the spec as passed to
+// extensionsScript. Source is the slug, URL or archive path wp-cli installs
+// the extension from, or the directory copied into place when Copy is set.
+// Remove uninstalls the extension instead.
+type desiredExtension struct {
+	Slug    string `json:"slug"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	Copy    bool   `json:"copy"`
+	Active  bool   `json:"active"`
+	Remove  bool   `json:"remove,omitempty"`
+}
+
+// extensionSync is what a sync Job of one extension type is given
+type extensionSync struct {
+	Extensions  []desiredExtension `json:"extensions"`
+	ActiveTheme string             `json:"activeTheme,omitempty"`
+
+	// claims and configMaps are mounted under extensionSourcesMountPath
+	claims     []string
+	configMaps []string
+}
+
+// syncResult is the termination message of a sync Job
+type syncResult struct {
+	Items []struct {
+		Slug    string `json:"slug"`
+		Version string `json:"version"`
+		Active  bool   `json:"active"`
+		Error   string `json:"error"`
+	} `json:"items"`
+	Active string `json:"active"`
+	Error  string `json:"error"`
+}
+
+// reconcileExtensions runs a wp-cli Job syncing the plugins or themes of the
+// spec whenever they change, and again every extensionResyncInterval, and
+// reports the outcome in the status and the PluginsSynced or ThemesSynced
+// condition.
+func reconcileExtensions(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, kind string) error {
+	condition := syncCondition(kind)
+	sync, err := desiredSync(wordpress, kind)
+	if err != nil {
+		return setCondition(r, ctx, wordpress, condition, metav1.ConditionFalse, wordpressv1.ReasonExtensionsInvalid, err.Error())
+	}
+	name := ""
+	if len(sync.Extensions) > 0 || sync.ActiveTheme != "" {
+		name = syncJobName(wordpress, kind, sync)
+	}
+
+	jobs := &batchv1.JobList{}
+	err = r.List(ctx, jobs, client.InNamespace(wordpress.Namespace),
+		client.MatchingLabels{wordpressv1.LabelInstance: wordpress.Name, labelSync: kind + "s"})
+	if err != nil {
+		return err
+	}
+
+	var job *batchv1.Job
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == name {
+			job = &jobs.Items[i]
+			continue
+		}
+		// Syncs of an outdated spec are superseded.
+		err = r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	if name == "" {
+		if !recordSyncResult(wordpress, kind, nil) && meta.FindStatusCondition(wordpress.Status.Conditions, condition) == nil {
+			return nil
+		}
+		meta.RemoveStatusCondition(&wordpress.Status.Conditions, condition)
+		return r.Status().Update(ctx, wordpress)
+	}
+
+	if job == nil {
+		job = newSyncJob(wordpress, kind, kind+"s", name, sync)
+		if err := controllerutil.SetControllerReference(wordpress, job, r.Scheme); err != nil {
+			return err
+		}
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create sync Job", "job.name", job.Name)
+			return err
+		}
+		log.Info("Returned custom sync Job object", "job.name", job.Name)
+		return setCondition(r, ctx, wordpress, condition, metav1.ConditionFalse, wordpressv1.ReasonExtensionsSyncing,
+			fmt.Sprintf("Waiting for Job %s", job.Name))
+	}
+
+	finished := jobFinishedAt(job)
+	if finished == nil {
+		return nil
+	}
+	if time.Since(finished.Time) > extensionResyncInterval {
+		log.Info("Resyncing "+kind+"s", "job.name", job.Name)
+		return client.IgnoreNotFound(r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+	}
+
+	if jobFailed(job) {
+		return setCondition(r, ctx, wordpress, condition, metav1.ConditionFalse, wordpressv1.ReasonExtensionsSyncFailed,
+			fmt.Sprintf("Job %s failed", job.Name))
+	}
+	return updateSyncStatus(r, ctx, log, wordpress, kind, job)
+}
+
+// updateSyncStatus records the result of a successful sync and purges the
+// page cache when it changed.
+func updateSyncStatus(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, kind string, job *batchv1.Job) error {
+	result, err := readSyncResult(r.Client, ctx, job)
+	if err != nil {
+		return err
+	}
+
+	if recordSyncResult(wordpress, kind, result) {
+		err = r.Status().Update(ctx, wordpress)
+		if err != nil {
+			return err
+		}
+		purgePageCache(r.Client, ctx, log, wordpress)
+	}
+
+	condition := syncCondition(kind)
+	for _, item := range result.Items {
+		if item.Error != "" {
+			return setCondition(r, ctx, wordpress, condition, metav1.ConditionFalse, wordpressv1.ReasonExtensionsSyncFailed,
+				fmt.Sprintf("%s %s: %s", strings.Title(kind), item.Slug, item.Error))
+		}
+	}
+	if result.Error != "" {
+		return setCondition(r, ctx, wordpress, condition, metav1.ConditionFalse, wordpressv1.ReasonExtensionsSyncFailed,
+			fmt.Sprintf("Activating theme %s: %s", wordpress.Spec.ActiveTheme, result.Error))
+	}
+	if active := wordpress.Status.ActiveTheme; kind == extensionTheme && active != nil && !active.Matches {
+		return setCondition(r, ctx, wordpress, condition, metav1.ConditionFalse, wordpressv1.ReasonActiveThemeMismatch,
+			fmt.Sprintf("Theme %s is active instead of %s", active.Slug, wordpress.Spec.ActiveTheme))
+	}
+	return setCondition(r, ctx, wordpress, condition, metav1.ConditionTrue, wordpressv1.ReasonExtensionsSynced,
+		fmt.Sprintf("%d %ss synced", len(result.Items), kind))
+}
+
+// readSyncResult returns the result reported by a successful sync Job.
+func readSyncResult(c client.Client, ctx context.Context, job *batchv1.Job) (*syncResult, error) {
+	message, err := jobTerminationMessage(c, ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	result := &syncResult{}
+	if err := json.Unmarshal([]byte(message), result); err != nil {
+		return nil, fmt.Errorf("invalid result of job %s: %v", job.Name, err)
+	}
+	return result, nil
+}
+
+// recordSyncResult sets the status of the extensions of kind from the
+// result of a sync, or clears it when result is nil. It returns true when
+// the status changed.
+func recordSyncResult(wordpress *wordpressv1.Wordpress, kind string, result *syncResult) bool {
+	status := wordpress.Status.DeepCopy()
+	switch kind {
+	case extensionPlugin:
+		status.Plugins = nil
+		if result != nil {
+			status.Plugins = []wordpressv1.PluginStatus{}
+			for _, item := range result.Items {
+				status.Plugins = append(status.Plugins, wordpressv1.PluginStatus{
+					Slug:    item.Slug,
+					Version: item.Version,
+					Active:  item.Active,
+					Error:   item.Error,
+				})
+			}
+		}
+	case extensionTheme:
+		status.Themes = nil
+		status.ActiveTheme = nil
+		if result != nil {
+			status.Themes = []wordpressv1.ThemeStatus{}
+			for _, item := range result.Items {
+				status.Themes = append(status.Themes, wordpressv1.ThemeStatus{
+					Slug:    item.Slug,
+					Version: item.Version,
+					Error:   item.Error,
+				})
+			}
+			status.ActiveTheme = &wordpressv1.ActiveThemeStatus{
+				Slug:    result.Active,
+				Matches: wordpress.Spec.ActiveTheme == "" || wordpress.Spec.ActiveTheme == result.Active,
+			}
+		}
+	}
+
+	if equality.Semantic.DeepEqual(&wordpress.Status, status) {
+		return false
+	}
+	wordpress.Status = *status
+	return true
+}
+
+func syncCondition(kind string) string {
+	if kind == extensionTheme {
+		return wordpressv1.ConditionThemesSynced
+	}
+	return wordpressv1.ConditionPluginsSynced
+}
+
+// desiredSync resolves the plugins or themes of the spec into what the sync
+// Job is given.
+func desiredSync(wordpress *wordpressv1.Wordpress, kind string) (*extensionSync, error) {
+	sync := &extensionSync{Extensions: []desiredExtension{}}
+	switch kind {
+	case extensionPlugin:
+		for _, plugin := range wordpress.Spec.Plugins {
+			err := sync.addPlugin(plugin)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case extensionTheme:
+		for _, theme := range wordpress.Spec.Themes {
+			err := sync.add(kind, theme.Slug, theme.Version, theme.Source.URL, theme.Source.PersistentVolumeClaim, theme.Source.ConfigMap, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sync.ActiveTheme = wordpress.Spec.ActiveTheme
+	}
+	return sync, nil
+}
+
+// addPlugin adds a declared plugin to the sync.
+func (sync *extensionSync) addPlugin(plugin wordpressv1.PluginSpec) error {
+	return sync.add(extensionPlugin, plugin.Slug, plugin.Version, plugin.Source.URL, plugin.Source.PersistentVolumeClaim, nil,
+		plugin.Active == nil || *plugin.Active)
+}
+
+// add resolves the source of an extension, mounting the claim or ConfigMap it
+// is read from, and adds it to the sync.
+func (sync *extensionSync) add(kind, slug, version, url string, pvc *wordpressv1.PVCFileSource, configMap *v1.LocalObjectReference, active bool) error {
+	set := 0
+	for _, isSet := range []bool{url != "", pvc != nil, configMap != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("%s %s: at most one source may be set", kind, slug)
+	}
+
+	extension := desiredExtension{Slug: slug, Version: version, Source: slug, Active: active}
+	switch {
+	case url != "":
+		extension.Source = url
+	case pvc != nil:
+		extension.Source = path.Join(extensionSourcesMountPath, "claims", pvc.ClaimName, pvc.Path)
+		// Plugins on claims are always archives; a theme may also be a
+		// directory, such as a child theme.
+		extension.Copy = kind == extensionTheme && !strings.HasSuffix(pvc.Path, ".zip")
+		if !containsString(sync.claims, pvc.ClaimName) {
+			sync.claims = append(sync.claims, pvc.ClaimName)
+		}
+	case configMap != nil:
+		extension.Source = path.Join(extensionSourcesMountPath, "configmaps", configMap.Name)
+		extension.Copy = true
+		if !containsString(sync.configMaps, configMap.Name) {
+			sync.configMaps = append(sync.configMaps, configMap.Name)
+		}
+	}
+	sync.Extensions = append(sync.Extensions, extension)
+	return nil
+}
+
+// syncJobName names the sync Job after what it is given, so that a change to
+// the spec starts a new sync.
+func syncJobName(wordpress *wordpressv1.Wordpress, kind string, sync *extensionSync) string {
+	data, _ := json.Marshal(sync)
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-%ss-%x", wordpress.Name, kind, sum[:5])
+}
+
+// jobFinishedAt returns when a Job succeeded or failed, or nil while it runs.
+func jobFinishedAt(job *batchv1.Job) *metav1.Time {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == v1.ConditionTrue {
+			return &condition.LastTransitionTime
+		}
+	}
+	return nil
+}
+
+// newSyncJob returns the Job running extensionsScript with wp-cli against
+// the running instance. synced labels the Job with what it syncs.
+func newSyncJob(wordpress *wordpressv1.Wordpress, kind, synced, name string, sync *extensionSync) *batchv1.Job {
+	data, _ := json.Marshal(sync.Extensions)
+	job := newWPCLIJob(wordpress, name, "sync", wpCLIEvalFile("sync", extensionsScript), []v1.EnvVar{
+		{Name: "EXTENSION_TYPE", Value: kind},
+		{Name: "EXTENSIONS", Value: string(data)},
+		{Name: "ACTIVE_THEME", Value: sync.ActiveTheme},
+		{Name: "WP_CLI_CACHE_DIR", Value: "/tmp/wp-cli-cache"},
+	})
+	setWPCLIJobLabel(job, labelSync, synced)
+
+	pod := &job.Spec.Template.Spec
+	for i, claim := range sync.claims {
+		volume := fmt.Sprintf("claim-%d", i)
+		pod.Volumes = append(pod.Volumes, v1.Volume{
+			Name: volume,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claim,
+					ReadOnly:  true,
+				},
+			},
+		})
+		pod.Containers[0].VolumeMounts = append(pod.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      volume,
+			MountPath: path.Join(extensionSourcesMountPath, "claims", claim),
+			ReadOnly:  true,
+		})
+	}
+	for i, configMap := range sync.configMaps {
+		volume := fmt.Sprintf("configmap-%d", i)
+		pod.Volumes = append(pod.Volumes, v1.Volume{
+			Name: volume,
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: configMap},
+				},
+			},
+		})
+		pod.Containers[0].VolumeMounts = append(pod.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      volume,
+			MountPath: path.Join(extensionSourcesMountPath, "configmaps", configMap),
+			ReadOnly:  true,
+		})
+	}
+	return job
+}
@@ -0,0 +1,376 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"path"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	importMountPath = "/import"
+
+	defaultImportDatabase    = "database.sql.gz"
+	defaultImportContent     = "wp-content.tar.gz"
+	defaultImportTablePrefix = "wp_"
+)
+
+// importFetchScript downloads the files of an HTTP source into ARTIFACT_DIR
+const importFetchScript = `set -eu
+for file in "$DATABASE_FILE" "$CONTENT_FILE"; do
+  curl -fsSL -o "$ARTIFACT_DIR/$file" "${IMPORT_URL%/}/$file"
+done
+`
+
+// importScript loads the dump into the wordpress database and replaces
+// wp-content with the archive, which may hold wp-content itself or its
+// contents. Either file may be gzipped. The site URL of the import and the
+// one the instance is served at are left in the work directory for the
+// search-replace container.
+const importScript = `set -Eeuo pipefail
+fail() {
+  printf '%s' "$1" > /dev/termination-log
+  echo "$1" >&2
+  exit 1
+}
+trap 'fail "import failed at line $LINENO"' ERR
+cd "$ARTIFACT_DIR"
+
+for file in "$DATABASE_FILE" "$CONTENT_FILE"; do
+  [ -f "$file" ] || fail "$file not found in the import source"
+done
+
+if gzip -t "$DATABASE_FILE" 2>/dev/null; then
+  gunzip -c "$DATABASE_FILE"
+else
+  cat "$DATABASE_FILE"
+fi | mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" wordpress
+
+imported=$(mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" -N -B \
+  -e "SELECT option_value FROM wordpress.${TABLE_PREFIX}options WHERE option_name = 'siteurl'" 2>/dev/null || true)
+[ -n "$imported" ] || fail "no siteurl in ${TABLE_PREFIX}options; the dump is not of a WordPress site with table prefix ${TABLE_PREFIX}"
+
+entries=$(tar -tf "$CONTENT_FILE")
+rm -rf /var/www/html/wp-content
+if grep -qvE '^((\./)?wp-content(/.*)?|\./?)$' <<< "$entries"; then
+  mkdir /var/www/html/wp-content
+  tar -C /var/www/html/wp-content -xf "$CONTENT_FILE"
+else
+  tar -C /var/www/html -xf "$CONTENT_FILE"
+fi
+chown -R 33:33 /var/www/html/wp-content
+
+printf '%s' "$imported" > "$WORK_DIR/old-url"
+printf '%s' "$SITE_URL" > "$WORK_DIR/new-url"
+`
+
+// reconcileImport populates an instance with spec.bootstrap.import before its
+// frontend is created: a Job installs WordPress on wp-pv-claim, imports the
+// dump and the archive and rewrites the site URL. It returns true once the
+// instance has been imported, or when there is nothing to import.
+func reconcileImport(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (bool, string, error) {
+	if wordpress.Spec.Bootstrap == nil || wordpress.Spec.Bootstrap.Import == nil {
+		return true, "", nil
+	}
+	imported := meta.FindStatusCondition(wordpress.Status.Conditions, wordpressv1.ConditionImported)
+	if imported != nil && imported.Status == metav1.ConditionTrue {
+		return true, "", nil
+	}
+	if imported != nil && imported.Reason == wordpressv1.ReasonImportSkipped {
+		return true, "", nil
+	}
+
+	err := validateImportSource(wordpress)
+	if err != nil {
+		return false, "", err
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: importJobName(wordpress), Namespace: wordpress.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	if errors.IsNotFound(err) && imported != nil && imported.Reason == wordpressv1.ReasonImportFailed {
+		// The failed Job was deleted to retry the import.
+		log.Info("Retrying the import")
+		meta.RemoveStatusCondition(&wordpress.Status.Conditions, wordpressv1.ConditionImported)
+		err = r.Status().Update(ctx, wordpress)
+		if err != nil {
+			return false, "", err
+		}
+		return false, "Importing the site", nil
+	}
+	if errors.IsNotFound(err) {
+		// Importing over a site that is already being served would discard
+		// it, so bootstrapping only applies to new instances.
+		if !objectNotFound(r, ctx, childName(wordpress, "wordpress"), &appsv1.Deployment{}, *wordpress) {
+			log.Info("Skipping import into an existing instance")
+			return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionImported, metav1.ConditionFalse, wordpressv1.ReasonImportSkipped,
+				"spec.bootstrap only applies to new instances")
+		}
+
+		_, err = createPVC(r, ctx, log, req, wordpress, "wp")
+		if err != nil {
+			return false, "", err
+		}
+
+		job = newImportJob(wordpress)
+		if err := controllerutil.SetControllerReference(wordpress, job, r.Scheme); err != nil {
+			return false, "", err
+		}
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create import Job", "job.name", job.Name)
+			return false, "", err
+		}
+		log.Info("Returned custom import Job object", "job.name", job.Name)
+		return false, "Importing the site", nil
+	}
+
+	if jobFailed(job) {
+		message, err := jobFailureMessage(r.Client, ctx, job)
+		if err != nil {
+			return false, "", err
+		}
+		if message == "" {
+			message = fmt.Sprintf("Job %s failed", job.Name)
+		}
+		// A failed import is not retried automatically since it may have
+		// partly populated the instance, which the next import overwrites.
+		message = fmt.Sprintf("%s; fix the source and delete Job %s to retry", message, job.Name)
+		log.Info("Import Job failed", "job.name", job.Name)
+		return false, message, setCondition(r, ctx, wordpress, wordpressv1.ConditionImported, metav1.ConditionFalse, wordpressv1.ReasonImportFailed, message)
+	}
+	if job.Status.Succeeded == 0 {
+		return false, "Importing the site", nil
+	}
+
+	log.Info("Imported Wordpress", "siteURL", wordpress.Spec.Bootstrap.Import.SiteURL)
+	return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionImported, metav1.ConditionTrue, wordpressv1.ReasonImported,
+		fmt.Sprintf("Imported the site to %s", wordpress.Spec.Bootstrap.Import.SiteURL))
+}
+
+// validateImportSource checks that exactly one source is set and that the
+// instance is not also cloned, which would overwrite the import.
+func validateImportSource(wordpress *wordpressv1.Wordpress) error {
+	if wordpress.Spec.CloneFrom != nil {
+		return fmt.Errorf("spec.bootstrap.import and spec.cloneFrom are mutually exclusive")
+	}
+
+	source := wordpress.Spec.Bootstrap.Import.Source
+	set := 0
+	for _, isSet := range []bool{source.PersistentVolumeClaim != nil, source.ConfigMap != nil, source.HTTP != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of persistentVolumeClaim, configMap and http must be set in spec.bootstrap.import.source")
+	}
+	return nil
+}
+
+func importJobName(wordpress *wordpressv1.Wordpress) string {
+	return wordpress.Name + "-import"
+}
+
+// newImportJob returns the Job importing the site. WordPress is installed on
+// wp-pv-claim first, the way the frontend would on its first start, so that
+// wp-cli can load the site to rewrite its URL.
+func newImportJob(wordpress *wordpressv1.Wordpress) *batchv1.Job {
+	spec := wordpress.Spec.Bootstrap.Import
+	source := spec.Source
+	backoffLimit := int32(0)
+	runAsUser := wwwDataUID
+
+	database := spec.Database
+	if database == "" {
+		database = defaultImportDatabase
+	}
+	content := spec.Content
+	if content == "" {
+		content = defaultImportContent
+	}
+	tablePrefix := spec.TablePrefix
+	if tablePrefix == "" {
+		tablePrefix = defaultImportTablePrefix
+	}
+
+	artifactDir := importMountPath
+	importVolume := v1.Volume{Name: "import"}
+	switch {
+	case source.PersistentVolumeClaim != nil:
+		artifactDir = path.Join(importMountPath, source.PersistentVolumeClaim.Path)
+		importVolume.VolumeSource = v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: source.PersistentVolumeClaim.ClaimName,
+				ReadOnly:  true,
+			},
+		}
+	case source.ConfigMap != nil:
+		importVolume.VolumeSource = v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: *source.ConfigMap},
+		}
+	default:
+		importVolume.VolumeSource = v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		}
+	}
+
+	fileEnv := []v1.EnvVar{
+		{Name: "ARTIFACT_DIR", Value: artifactDir},
+		{Name: "DATABASE_FILE", Value: database},
+		{Name: "CONTENT_FILE", Value: content},
+	}
+
+	initContainers := []v1.Container{}
+	if source.HTTP != nil {
+		initContainers = append(initContainers, v1.Container{
+			Image:   wordpressImage,
+			Name:    "fetch",
+			Command: []string{"sh", "-c", importFetchScript},
+			Env:     append(fileEnv, v1.EnvVar{Name: "IMPORT_URL", Value: source.HTTP.URL}),
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      "import",
+					MountPath: importMountPath,
+				},
+			},
+		})
+	}
+
+	initContainers = append(initContainers,
+		v1.Container{
+			// The entrypoint copies WordPress into the volume and writes
+			// wp-config.php before running apache2, which only prints its
+			// version.
+			Image:   wordpressImage,
+			Name:    "install",
+			Command: []string{"docker-entrypoint.sh", "apache2", "-v"},
+			Env: []v1.EnvVar{
+				{Name: "WORDPRESS_DB_HOST", Value: mysqlHost(wordpress)},
+				{
+					Name: "WORDPRESS_DB_PASSWORD",
+					ValueFrom: &v1.EnvVarSource{
+						SecretKeyRef: &v1.SecretKeySelector{
+							LocalObjectReference: v1.LocalObjectReference{
+								Name: childName(wordpress, "mysql-pass"),
+							},
+							Key: "password",
+						},
+					},
+				},
+				{Name: "WORDPRESS_TABLE_PREFIX", Value: tablePrefix},
+			},
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      "wordpress-persistent-storage",
+					MountPath: "/var/www/html",
+				},
+			},
+		},
+		v1.Container{
+			Image:   mysqlImage,
+			Name:    "import",
+			Command: []string{"bash", "-c", importScript},
+			Env: append(fileEnv,
+				v1.EnvVar{Name: "WORK_DIR", Value: workMountPath},
+				v1.EnvVar{Name: "TABLE_PREFIX", Value: tablePrefix},
+				v1.EnvVar{Name: "SITE_URL", Value: spec.SiteURL},
+				mysqlHostEnv(wordpress),
+				mysqlRootPasswordEnv(wordpress),
+			),
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      "import",
+					MountPath: importMountPath,
+					ReadOnly:  source.HTTP == nil,
+				},
+				{
+					Name:      "wordpress-persistent-storage",
+					MountPath: "/var/www/html",
+				},
+				{
+					Name:      "work",
+					MountPath: workMountPath,
+				},
+			},
+		},
+	)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      importJobName(wordpress),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app":                     "wordpress",
+				wordpressv1.LabelInstance: wordpress.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                     "wordpress",
+						wordpressv1.LabelInstance: wordpress.Name,
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyNever,
+					InitContainers: initContainers,
+					Containers: []v1.Container{
+						{
+							Image:   wpCLIImage,
+							Name:    "search-replace",
+							Command: []string{"sh", "-c", searchReplaceScript},
+							Env: []v1.EnvVar{
+								{Name: "WORK_DIR", Value: workMountPath},
+							},
+							SecurityContext: &v1.SecurityContext{
+								RunAsUser: &runAsUser,
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "wordpress-persistent-storage",
+									MountPath: "/var/www/html",
+								},
+								{
+									Name:      "work",
+									MountPath: workMountPath,
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "wordpress-persistent-storage",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+									ClaimName: childName(wordpress, "wp-pv-claim"),
+								},
+							},
+						},
+						{
+							Name: "work",
+							VolumeSource: v1.VolumeSource{
+								EmptyDir: &v1.EmptyDirVolumeSource{},
+							},
+						},
+						importVolume,
+					},
+				},
+			},
+		},
+	}
+}
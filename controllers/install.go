@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// installScript runs wp core install unless WordPress is already installed,
+// and installs and activates the language of LOCALE. It reports whether it
+// installed the site through the termination message.
+const installScript = wpCLIPrelude + `
+if wp core is-installed 2>/dev/null; then
+  printf existing > /dev/termination-log
+  exit 0
+fi
+
+printf '%s\n' "$ADMIN_PASSWORD" | wp core install --url="$SITE_URL" --title="$SITE_TITLE" \
+  --admin_user="$ADMIN_USER" --admin_email="$ADMIN_EMAIL" --locale="$LOCALE" \
+  --skip-email --prompt=admin_password || fail "wp core install failed"
+if [ "$LOCALE" != "en_US" ]; then
+  wp language core install "$LOCALE" --activate || fail "installing the $LOCALE language failed"
+fi
+printf installed > /dev/termination-log
+`
+
+// reconcileInstall installs WordPress on an instance with spec.site once its
+// frontend is up: a Job runs wp core install, which is skipped when the site
+// is already installed. It returns true once the site is installed, or when
+// there is nothing to install.
+func reconcileInstall(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, string, error) {
+	site := wordpress.Spec.Site
+	if site == nil || meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionInstalled) {
+		return true, "", nil
+	}
+
+	err := validateAdminPassword(r.Client, ctx, wordpress)
+	if err != nil {
+		return false, "", err
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: installJobName(wordpress), Namespace: wordpress.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	if errors.IsNotFound(err) {
+		job = newInstallJob(wordpress)
+		if err := controllerutil.SetControllerReference(wordpress, job, r.Scheme); err != nil {
+			return false, "", err
+		}
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create install Job", "job.name", job.Name)
+			return false, "", err
+		}
+		log.Info("Returned custom install Job object", "job.name", job.Name)
+		return false, "Installing WordPress", nil
+	}
+
+	if jobFailed(job) {
+		message, err := jobFailureMessage(r.Client, ctx, job)
+		if err != nil {
+			return false, "", err
+		}
+		if message == "" {
+			message = fmt.Sprintf("Job %s failed", job.Name)
+		}
+		// The Job is named after spec.site, so fixing the spec retries the
+		// install, as does deleting the Job.
+		message = fmt.Sprintf("%s; fix spec.site or delete Job %s to retry", message, job.Name)
+		log.Info("Install Job failed", "job.name", job.Name)
+		return false, message, setCondition(r, ctx, wordpress, wordpressv1.ConditionInstalled, metav1.ConditionFalse, wordpressv1.ReasonInstallFailed, message)
+	}
+	if job.Status.Succeeded == 0 {
+		return false, "Installing WordPress", nil
+	}
+
+	result, err := jobTerminationMessage(r.Client, ctx, job)
+	if err != nil {
+		return false, "", err
+	}
+	if result == "existing" {
+		log.Info("Wordpress is already installed", "job.name", job.Name)
+		return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionInstalled, metav1.ConditionTrue, wordpressv1.ReasonAlreadyInstalled,
+			"WordPress was already installed")
+	}
+	log.Info("Installed Wordpress", "url", site.URL)
+	return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionInstalled, metav1.ConditionTrue, wordpressv1.ReasonInstalled,
+		fmt.Sprintf("Installed WordPress at %s", site.URL))
+}
+
+// validateAdminPassword checks that the Secret of spec.site holds a password.
+func validateAdminPassword(c client.Client, ctx context.Context, wordpress *wordpressv1.Wordpress) error {
+	name := wordpress.Spec.Site.AdminPasswordSecretRef.Name
+	secret := &v1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: wordpress.Namespace}, secret)
+	if err != nil {
+		return fmt.Errorf("admin password Secret %s: %v", name, err)
+	}
+	if len(secret.Data["password"]) == 0 {
+		return fmt.Errorf("admin password Secret %s has no password key", name)
+	}
+	return nil
+}
+
+// installJobName names the install Job after spec.site, so that a failed
+// install is retried once the spec changes.
+func installJobName(wordpress *wordpressv1.Wordpress) string {
+	data, _ := json.Marshal(wordpress.Spec.Site)
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-install-%x", wordpress.Name, sum[:5])
+}
+
+// newInstallJob returns the Job running installScript with wp-cli against the
+// running instance.
+func newInstallJob(wordpress *wordpressv1.Wordpress) *batchv1.Job {
+	site := wordpress.Spec.Site
+	locale := site.Locale
+	if locale == "" {
+		locale = "en_US"
+	}
+
+	return newWPCLIJob(wordpress, installJobName(wordpress), "install", installScript, []v1.EnvVar{
+		{Name: "SITE_URL", Value: site.URL},
+		{Name: "SITE_TITLE", Value: site.Title},
+		{Name: "ADMIN_USER", Value: site.AdminUser},
+		{Name: "ADMIN_EMAIL", Value: site.AdminEmail},
+		{
+			Name: "ADMIN_PASSWORD",
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: site.AdminPasswordSecretRef,
+					Key:                  "password",
+				},
+			},
+		},
+		{Name: "LOCALE", Value: locale},
+	})
+}
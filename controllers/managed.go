@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// Phases of managed objects. The phases of WordpressPlugin, WordpressUser and
+// WordpressSite share these values.
+const (
+	phasePending  = "Pending"
+	phaseSyncing  = "Syncing"
+	phaseSynced   = "Synced"
+	phaseFailed   = "Failed"
+	phaseConflict = "Conflict"
+	phaseRemoving = "Removing"
+)
+
+// managedObject adapts a WordpressPlugin, WordpressUser or WordpressSite to
+// the lifecycle they share: each keeps something on an instance in its
+// declared state with a wp-cli sync Job, superseded when the spec changes and
+// rerun every extensionResyncInterval, and holds a finalizer until a Job has
+// removed it from the instance.
+type managedObject interface {
+	// object returns the adapted object, which the client reads and writes
+	object() client.Object
+	// kind is the kind of the object, e.g. WordpressPlugin
+	kind() string
+	// what names what the object manages in messages, e.g. plugin
+	what() string
+	finalizer() string
+	phase() string
+	jobName() string
+	setPhase(phase, message string)
+	setJobName(name string)
+	// status returns the status of the object, compared with that of a
+	// fresh copy before it is updated
+	status() interface{}
+	// fresh returns an empty object of the same kind to read into
+	fresh() managedObject
+}
+
+// addManagedFinalizer adds the finalizer of the object. It returns true when
+// the object was updated.
+func addManagedFinalizer(c client.Client, ctx context.Context, obj managedObject) (bool, error) {
+	if controllerutil.ContainsFinalizer(obj.object(), obj.finalizer()) {
+		return false, nil
+	}
+	controllerutil.AddFinalizer(obj.object(), obj.finalizer())
+	return true, c.Update(ctx, obj.object())
+}
+
+// managedOwner returns the name of an older object in list claiming the same
+// thing as obj, which claims reports, if any. Objects being deleted keep
+// their claim until their Job has removed what they manage.
+func managedOwner(c client.Client, ctx context.Context, obj managedObject, list client.ObjectList, claims func(other runtime.Object) bool) (string, error) {
+	err := c.List(ctx, list, client.InNamespace(obj.object().GetNamespace()))
+	if err != nil {
+		return "", err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range items {
+		other := item.(client.Object)
+		if other.GetName() == obj.object().GetName() || !claims(item) {
+			continue
+		}
+		if claimedBefore(other, obj.object()) {
+			return other.GetName(), nil
+		}
+	}
+	return "", nil
+}
+
+// runManagedJob runs the sync Job name of the object, created by newJob,
+// superseding the Job of an outdated spec. It returns the Job once it has
+// finished, along with the result requeueing the object for its next resync,
+// and nil while it runs or has just been started.
+func runManagedJob(c client.Client, scheme *runtime.Scheme, ctx context.Context, log logr.Logger, obj managedObject, name string, newJob func() *batchv1.Job) (*batchv1.Job, ctrl.Result, error) {
+	namespace := obj.object().GetNamespace()
+	if old := obj.jobName(); old != "" && old != name {
+		err := c.Delete(ctx, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: old, Namespace: namespace}},
+			client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return nil, ctrl.Result{}, err
+		}
+	}
+
+	job := &batchv1.Job{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, ctrl.Result{}, err
+	}
+	if errors.IsNotFound(err) {
+		return nil, ctrl.Result{}, createManagedJob(c, scheme, ctx, log, obj, newJob(), phaseSyncing)
+	}
+
+	finished := jobFinishedAt(job)
+	if finished == nil {
+		return nil, ctrl.Result{}, nil
+	}
+	if time.Since(finished.Time) > extensionResyncInterval {
+		log.Info("Resyncing "+obj.what(), "job.name", job.Name)
+		return nil, ctrl.Result{Requeue: true}, client.IgnoreNotFound(c.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+	}
+	return job, ctrl.Result{RequeueAfter: extensionResyncInterval - time.Since(finished.Time)}, nil
+}
+
+// removeManaged runs the Job removing what a deleted object manages from the
+// instance, created by newJob, and releases the finalizer once it has
+// succeeded; check returns the error the Job reported in its result, if any.
+// What was never synced by the object, or whose instance is gone, is left
+// alone. The page cache is purged once removed, as it may still serve pages
+// showing it. wordpress and pending are as returned by referencedWordpress.
+func removeManaged(c client.Client, scheme *runtime.Scheme, ctx context.Context, log logr.Logger, obj managedObject, wordpress *wordpressv1.Wordpress, pending string, newJob func(name string) *batchv1.Job, check func(job *batchv1.Job) (string, error)) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(obj.object(), obj.finalizer()) {
+		return ctrl.Result{}, nil
+	}
+
+	release := obj.jobName() == "" || obj.phase() == phaseConflict ||
+		obj.object().GetAnnotations()[wordpressv1.AnnotationForceDelete] == "true"
+	if wordpress == nil || !wordpress.DeletionTimestamp.IsZero() {
+		release = true
+	}
+	if release {
+		controllerutil.RemoveFinalizer(obj.object(), obj.finalizer())
+		return ctrl.Result{}, c.Update(ctx, obj.object())
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(c, ctx, obj, phaseRemoving, pending)
+	}
+
+	name := obj.object().GetName() + "-remove"
+	job := &batchv1.Job{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: obj.object().GetNamespace()}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, createManagedJob(c, scheme, ctx, log, obj, newJob(name), phaseRemoving)
+	}
+
+	if jobFinishedAt(job) == nil {
+		return ctrl.Result{}, nil
+	}
+	message := ""
+	if jobFailed(job) {
+		message, err = managedJobFailure(c, ctx, job)
+	} else if check != nil {
+		message, err = check(job)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if message != "" {
+		return ctrl.Result{}, setManagedPhase(c, ctx, obj, phaseRemoving,
+			fmt.Sprintf("%s; delete Job %s to retry, or annotate the %s with %s=true to delete it without removing the %s",
+				message, job.Name, obj.kind(), wordpressv1.AnnotationForceDelete, obj.what()))
+	}
+
+	log.Info("Removed "+obj.what(), "wordpress.name", wordpress.Name)
+	purgePageCache(c, ctx, log, wordpress)
+	controllerutil.RemoveFinalizer(obj.object(), obj.finalizer())
+	return ctrl.Result{}, c.Update(ctx, obj.object())
+}
+
+// createManagedJob creates a Job owned by the object and moves the object to
+// phase.
+func createManagedJob(c client.Client, scheme *runtime.Scheme, ctx context.Context, log logr.Logger, obj managedObject, job *batchv1.Job, phase string) error {
+	if err := controllerutil.SetControllerReference(obj.object(), job, scheme); err != nil {
+		return err
+	}
+	err := c.Create(ctx, job)
+	if err != nil {
+		log.Error(err, "Failed to create "+obj.what()+" Job", "job.name", job.Name)
+		return err
+	}
+	log.Info("Returned custom "+obj.what()+" Job object", "job.name", job.Name)
+	obj.setJobName(job.Name)
+	return setManagedPhase(c, ctx, obj, phase, "")
+}
+
+// managedJobFailure returns why a Job of a managed object failed.
+func managedJobFailure(c client.Client, ctx context.Context, job *batchv1.Job) (string, error) {
+	message, err := jobFailureMessage(c, ctx, job)
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		message = fmt.Sprintf("Job %s failed", job.Name)
+	}
+	return message, nil
+}
+
+// setManagedPhase records the phase and message along with any other status
+// fields set by the caller, skipping the status update when nothing changed.
+func setManagedPhase(c client.Client, ctx context.Context, obj managedObject, phase, message string) error {
+	current := obj.fresh()
+	err := c.Get(ctx, types.NamespacedName{Name: obj.object().GetName(), Namespace: obj.object().GetNamespace()}, current.object())
+	if err != nil {
+		return err
+	}
+	obj.setPhase(phase, message)
+	if equality.Semantic.DeepEqual(current.status(), obj.status()) {
+		return nil
+	}
+	return c.Status().Update(ctx, obj.object())
+}
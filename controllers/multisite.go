@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// multisiteScript converts the installed site into a network with wp core
+// multisite-convert, which adds the Multisite constants to wp-config.php,
+// and writes the rewrite rules of the network's mode to the WordPress block
+// of .htaccess, keeping the rules around it. A network that is already
+// installed is left as is unless it is in the other mode or on another
+// domain.
+const multisiteScript = wpCLIPrelude + `
+wp core is-installed 2>/dev/null || fail "WordPress is not installed; set spec.site or install it in the browser"
+
+if wp core is-installed --network 2>/dev/null; then
+  subdomains=$(wp eval 'echo is_subdomain_install() ? "true" : "false";')
+  [ "$subdomains" = "$SUBDOMAINS" ] || fail "the network is already installed in the other mode, which cannot be changed"
+  domain=$(wp eval 'echo get_network()->domain;')
+  [ "$domain" = "$DOMAIN" ] || fail "the network is installed on $domain, not on spec.multisite.domain $DOMAIN, which cannot be changed"
+else
+  host=$(wp option get siteurl | sed -e 's|^[a-z]*://||' -e 's|[:/].*$||')
+  [ "$host" = "$DOMAIN" ] || fail "the site URL is on $host, not on spec.multisite.domain $DOMAIN"
+  if [ "$SUBDOMAINS" = true ]; then
+    wp core multisite-convert --subdomains --base=/ || fail "wp core multisite-convert failed"
+  else
+    wp core multisite-convert --base=/ || fail "wp core multisite-convert failed"
+  fi
+fi
+wp eval 'require_once ABSPATH . "wp-admin/includes/misc.php";
+  insert_with_markers( ABSPATH . ".htaccess", "WordPress", explode( "\n", getenv( "HTACCESS" ) ) ) || exit( 1 );' ||
+  fail "writing the rewrite rules to .htaccess failed"
+`
+
+// Rewrite rules of Multisite networks, as documented for WordPress 3.5 and
+// later. insert_with_markers wraps them in the # BEGIN WordPress and
+// # END WordPress markers.
+const (
+	subdomainHtaccess = `RewriteEngine On
+RewriteBase /
+RewriteRule ^index\.php$ - [L]
+
+# add a trailing slash to /wp-admin
+RewriteRule ^wp-admin$ wp-admin/ [R=301,L]
+
+RewriteCond %{REQUEST_FILENAME} -f [OR]
+RewriteCond %{REQUEST_FILENAME} -d
+RewriteRule ^ - [L]
+RewriteRule ^(wp-(content|admin|includes).*) $1 [L]
+RewriteRule ^(.*\.php)$ $1 [L]
+RewriteRule . index.php [L]`
+	subdirectoryHtaccess = `RewriteEngine On
+RewriteBase /
+RewriteRule ^index\.php$ - [L]
+
+# add a trailing slash to /wp-admin
+RewriteRule ^([_0-9a-zA-Z-]+/)?wp-admin$ $1wp-admin/ [R=301,L]
+
+RewriteCond %{REQUEST_FILENAME} -f [OR]
+RewriteCond %{REQUEST_FILENAME} -d
+RewriteRule ^ - [L]
+RewriteRule ^([_0-9a-zA-Z-]+/)?(wp-(content|admin|includes).*) $2 [L]
+RewriteRule ^([_0-9a-zA-Z-]+/)?(.*\.php)$ $2 [L]
+RewriteRule . index.php [L]`
+)
+
+// reconcileMultisite converts an installed instance with spec.multisite into
+// a network with a Job. It returns true once the network is enabled, or when
+// there is nothing to convert. A change to the mode or domain of an enabled
+// network runs the Job again, which fails unless the network already matches
+// the spec.
+func reconcileMultisite(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) (bool, string, error) {
+	multisite := wordpress.Spec.Multisite
+	if multisite == nil {
+		return true, "", nil
+	}
+	enabled := meta.FindStatusCondition(wordpress.Status.Conditions, wordpressv1.ConditionMultisite)
+	if enabled != nil && enabled.Status == metav1.ConditionTrue && enabled.Message == multisiteEnabledMessage(multisite) {
+		return true, "", nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: multisiteJobName(wordpress), Namespace: wordpress.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	if errors.IsNotFound(err) {
+		job = newMultisiteJob(wordpress)
+		if err := controllerutil.SetControllerReference(wordpress, job, r.Scheme); err != nil {
+			return false, "", err
+		}
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create multisite Job", "job.name", job.Name)
+			return false, "", err
+		}
+		log.Info("Returned custom multisite Job object", "job.name", job.Name)
+		return false, "Enabling Multisite", nil
+	}
+
+	if jobFailed(job) {
+		message, err := jobFailureMessage(r.Client, ctx, job)
+		if err != nil {
+			return false, "", err
+		}
+		if message == "" {
+			message = fmt.Sprintf("Job %s failed", job.Name)
+		}
+		message = fmt.Sprintf("%s; fix spec.multisite or delete Job %s to retry", message, job.Name)
+		log.Info("Multisite Job failed", "job.name", job.Name)
+		return false, message, setCondition(r, ctx, wordpress, wordpressv1.ConditionMultisite, metav1.ConditionFalse, wordpressv1.ReasonMultisiteFailed, message)
+	}
+	if job.Status.Succeeded == 0 {
+		return false, "Enabling Multisite", nil
+	}
+
+	log.Info("Enabled Multisite", "mode", multisite.Mode, "domain", multisite.Domain)
+	return true, "", setCondition(r, ctx, wordpress, wordpressv1.ConditionMultisite, metav1.ConditionTrue, wordpressv1.ReasonMultisiteEnabled,
+		multisiteEnabledMessage(multisite))
+}
+
+// multisiteEnabledMessage is the message of the Multisite condition once the
+// network is enabled, against which later changes to the spec are detected.
+func multisiteEnabledMessage(multisite *wordpressv1.MultisiteSpec) string {
+	return fmt.Sprintf("Enabled a %s network on %s", multisite.Mode, multisite.Domain)
+}
+
+// multisiteJobName names the conversion Job after the mode and domain, so that
+// a failed conversion is retried once they change.
+func multisiteJobName(wordpress *wordpressv1.Wordpress) string {
+	data, _ := json.Marshal([]string{string(wordpress.Spec.Multisite.Mode), wordpress.Spec.Multisite.Domain})
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-multisite-%x", wordpress.Name, sum[:5])
+}
+
+// newMultisiteJob returns the Job running multisiteScript with wp-cli against
+// the running instance.
+func newMultisiteJob(wordpress *wordpressv1.Wordpress) *batchv1.Job {
+	multisite := wordpress.Spec.Multisite
+	subdomains := multisite.Mode == wordpressv1.MultisiteModeSubdomain
+	htaccess := subdirectoryHtaccess
+	if subdomains {
+		htaccess = subdomainHtaccess
+	}
+
+	return newWPCLIJob(wordpress, multisiteJobName(wordpress), "multisite", multisiteScript, []v1.EnvVar{
+		{Name: "SUBDOMAINS", Value: fmt.Sprint(subdomains)},
+		{Name: "DOMAIN", Value: multisite.Domain},
+		{Name: "HTACCESS", Value: htaccess},
+	})
+}
+
+// reconcileMultisiteIngress keeps the Ingress of a network with
+// spec.multisite.ingress routing its hosts to the frontend, and deletes it
+// once the Ingress is no longer wanted.
+func reconcileMultisiteIngress(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	found := &networkingv1.Ingress{}
+	err := r.Get(ctx, types.NamespacedName{Name: childName(wordpress, "wordpress"), Namespace: wordpress.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if wordpress.Spec.Multisite == nil || wordpress.Spec.Multisite.Ingress == nil {
+		if !exists || !metav1.IsControlledBy(found, wordpress) {
+			return nil
+		}
+		log.Info("Deleting Ingress", "ingress.name", found.Name)
+		return client.IgnoreNotFound(r.Delete(ctx, found))
+	}
+
+	hosts, err := multisiteHosts(r, ctx, wordpress)
+	if err != nil {
+		return err
+	}
+	desired := newMultisiteIngress(wordpress, hosts)
+	if !exists {
+		if err := controllerutil.SetControllerReference(wordpress, desired, r.Scheme); err != nil {
+			return err
+		}
+		err = r.Create(ctx, desired)
+		if err != nil {
+			log.Error(err, "Failed to create Ingress", "ingress.name", desired.Name)
+			return err
+		}
+		log.Info("Returned custom Ingress object", "ingress.name", desired.Name)
+		return nil
+	}
+
+	// The class may be defaulted by the cluster when none is declared.
+	if desired.Spec.IngressClassName == nil {
+		desired.Spec.IngressClassName = found.Spec.IngressClassName
+	}
+	if equality.Semantic.DeepEqual(found.Spec, desired.Spec) && equality.Semantic.DeepEqual(found.Annotations, desired.Annotations) {
+		return nil
+	}
+	found.Spec = desired.Spec
+	found.Annotations = desired.Annotations
+	err = r.Update(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to update Ingress", "ingress.name", found.Name)
+		return err
+	}
+	log.Info("Updated Ingress object", "ingress.name", found.Name)
+	return nil
+}
+
+// multisiteHosts returns the network domain, the wildcard of its subdomains
+// in subdomain mode, and the domains mapped by synced WordpressSites of the
+// instance.
+func multisiteHosts(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress) ([]string, error) {
+	multisite := wordpress.Spec.Multisite
+	hosts := []string{multisite.Domain}
+	if multisite.Mode == wordpressv1.MultisiteModeSubdomain {
+		hosts = append(hosts, "*."+multisite.Domain)
+	}
+
+	list := &wordpressv1.WordpressSiteList{}
+	err := r.List(ctx, list, client.InNamespace(wordpress.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	mapped := []string{}
+	for _, site := range list.Items {
+		if site.Spec.WordpressRef != wordpress.Name || site.Spec.Domain == "" ||
+			site.Status.Phase != wordpressv1.SitePhaseSynced || containsString(hosts, site.Spec.Domain) ||
+			containsString(mapped, site.Spec.Domain) {
+			continue
+		}
+		mapped = append(mapped, site.Spec.Domain)
+	}
+	sort.Strings(mapped)
+	return append(hosts, mapped...), nil
+}
+
+// newMultisiteIngress returns the Ingress routing every host to the Wordpress
+// Service.
+func newMultisiteIngress(wordpress *wordpressv1.Wordpress, hosts []string) *networkingv1.Ingress {
+	spec := wordpress.Spec.Multisite.Ingress
+	pathType := networkingv1.PathTypePrefix
+
+	rules := []networkingv1.IngressRule{}
+	for _, host := range hosts {
+		rules = append(rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: childName(wordpress, "wordpress"),
+									Port: networkingv1.ServiceBackendPort{Number: 80},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        childName(wordpress, "wordpress"),
+			Namespace:   wordpress.Namespace,
+			Annotations: spec.Annotations,
+			Labels: map[string]string{
+				"app":                     "wordpress",
+				wordpressv1.LabelInstance: wordpress.Name,
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: spec.IngressClassName,
+			Rules:            rules,
+		},
+	}
+	if spec.TLSSecretName != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      hosts,
+				SecretName: spec.TLSSecretName,
+			},
+		}
+	}
+	return ingress
+}
@@ -6,19 +6,34 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	wordpressv1 "wordpress-operator/api/v1"
 )
 
+const mysqlImage = "mysql:5.6"
+
 func createMySQL(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	if pointInTimeEnabled(wordpress) {
+		target, _ := binlogTarget(wordpress)
+		if err := validateBackupTarget(target); err != nil {
+			log.Error(err, "Invalid binlog target")
+			return ctrl.Result{}, err
+		}
+		if encryption := wordpress.Spec.Backup.Encryption; encryption != nil {
+			if err := validateEncryption(r.Client, ctx, wordpress.Namespace, encryption, r.OperatorImage); err != nil {
+				log.Error(err, "Invalid binlog encryption")
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
 	res, err := createMySQLService(r, ctx, log, req, wordpress)
 	if err != nil {
 		return res, err
 	}
 
-	res, err = createPVC(r, ctx, log, req, wordpress, "mysql")
+	res, err = createMySQLConfigMap(r, ctx, log, req, wordpress)
 	if err != nil {
 		return res, err
 	}
@@ -32,8 +47,12 @@ func createMySQL(r *WordpressReconciler, ctx context.Context, log logr.Logger, r
 
 }
 
+func createMySQLStorage(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	return createPVC(r, ctx, log, req, wordpress, "mysql")
+}
+
 func createMySQLService(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
-	if objectNotFound(r, ctx, "wordpress-mysql", &v1.Service{}, *wordpress) {
+	if objectNotFound(r, ctx, mysqlHost(wordpress), &v1.Service{}, *wordpress) {
 		service := newMySQLService(wordpress)
 		if err := controllerutil.SetControllerReference(wordpress, service, r.Scheme); err != nil {
 			return ctrl.Result{}, err
@@ -53,7 +72,7 @@ func createMySQLService(r *WordpressReconciler, ctx context.Context, log logr.Lo
 func newMySQLService(wordpress *wordpressv1.Wordpress) *v1.Service {
 	return &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "wordpress-mysql",
+			Name:      mysqlHost(wordpress),
 			Namespace: wordpress.Namespace,
 			Labels: map[string]string{
 				"app": "wordpress",
@@ -65,18 +84,15 @@ func newMySQLService(wordpress *wordpressv1.Wordpress) *v1.Service {
 					Port: 3306,
 				},
 			},
-			Selector: map[string]string{
-				"app":  "wordpress",
-				"tier": "mysql",
-			},
+			Selector:  tierLabels(wordpress, "mysql"),
 			ClusterIP: "None",
 		},
 	}
 }
 
 func createMySQLDeployment(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
-	if objectNotFound(r, ctx, "wordpress-mysql", &appsv1.Deployment{}, *wordpress) {
-		deployment := newMySQLDeployment(wordpress)
+	if objectNotFound(r, ctx, childName(wordpress, "wordpress-mysql"), &appsv1.Deployment{}, *wordpress) {
+		deployment := newMySQLDeployment(wordpress, r.OperatorImage)
 
 		if err := controllerutil.SetControllerReference(wordpress, deployment, r.Scheme); err != nil {
 			return ctrl.Result{}, err
@@ -90,14 +106,14 @@ func createMySQLDeployment(r *WordpressReconciler, ctx context.Context, log logr
 		log.Info("Returned custom MySQL Deployment object ", "name", req.NamespacedName.Name)
 		return ctrl.Result{Requeue: true}, nil
 	}
-	return ctrl.Result{}, nil
+	return updateDeploymentTemplate(r, ctx, log, wordpress, newMySQLDeployment(wordpress, r.OperatorImage))
 }
 
-func newMySQLDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
+func newMySQLDeployment(wordpress *wordpressv1.Wordpress, operatorImage string) *appsv1.Deployment {
 
-	return &appsv1.Deployment{
+	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "wordpress-mysql",
+			Name:      childName(wordpress, "wordpress-mysql"),
 			Namespace: wordpress.Namespace,
 			Labels: map[string]string{
 				"app": "wordpress",
@@ -105,25 +121,22 @@ func newMySQLDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
 		},
 		Spec: appsv1.DeploymentSpec{
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app":  "wordpress",
-					"tier": "mysql",
-				},
+				MatchLabels: tierLabels(wordpress, "mysql"),
 			},
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RecreateDeploymentStrategyType,
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":  "wordpress",
-						"tier": "mysql",
+					Labels: tierLabels(wordpress, "mysql"),
+					Annotations: map[string]string{
+						mysqlConfigChecksumAnnotation: mysqlConfigChecksum(wordpress),
 					},
 				},
 				Spec: v1.PodSpec{
 					Containers: []v1.Container{
 						{
-							Image: "mysql:5.6",
+							Image: mysqlImage,
 							Name:  "mysql",
 							Env: []v1.EnvVar{
 								{
@@ -131,20 +144,16 @@ func newMySQLDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
 									ValueFrom: &v1.EnvVarSource{
 										SecretKeyRef: &v1.SecretKeySelector{
 											LocalObjectReference: v1.LocalObjectReference{
-												Name: "mysql-pass",
+												Name: childName(wordpress, "mysql-pass"),
 											},
 											Key: "password",
 										},
 									},
 								},
 							},
-							LivenessProbe: &v1.Probe{
-								Handler: v1.Handler{
-									TCPSocket: &v1.TCPSocketAction{
-										Port: intstr.IntOrString{IntVal: 3306},
-									},
-								},
-							},
+							ReadinessProbe: mysqlReadinessProbe(wordpress),
+							LivenessProbe:  mysqlLivenessProbe(wordpress),
+							StartupProbe:   mysqlStartupProbe(wordpress),
 							Ports: []v1.ContainerPort{
 								{
 									Name:          "mysql",
@@ -156,6 +165,13 @@ func newMySQLDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
 									Name:      "mysql-persistent-storage",
 									MountPath: "/var/lib/mysql",
 								},
+								{
+									// Mounted as a single file so the defaults
+									// shipped in the image's conf.d are kept.
+									Name:      "mysql-config",
+									MountPath: "/etc/mysql/conf.d/" + mysqlConfigFile,
+									SubPath:   mysqlConfigFile,
+								},
 							},
 						},
 					},
@@ -164,7 +180,17 @@ func newMySQLDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
 							Name: "mysql-persistent-storage",
 							VolumeSource: v1.VolumeSource{
 								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-									ClaimName: "mysql-pv-claim",
+									ClaimName: childName(wordpress, "mysql-pv-claim"),
+								},
+							},
+						},
+						{
+							Name: "mysql-config",
+							VolumeSource: v1.VolumeSource{
+								ConfigMap: &v1.ConfigMapVolumeSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: childName(wordpress, mysqlConfigMapName),
+									},
 								},
 							},
 						},
@@ -173,4 +199,16 @@ func newMySQLDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
 			},
 		},
 	}
+
+	if pointInTimeEnabled(wordpress) {
+		containers, volumes := newBinlogContainers(wordpress)
+		spec := &deployment.Spec.Template.Spec
+		spec.Containers = append(spec.Containers, containers...)
+		spec.Volumes = append(spec.Volumes, volumes...)
+		if encryption := wordpress.Spec.Backup.Encryption; encryption != nil {
+			addCrypt(spec, operatorImage, []v1.LocalObjectReference{encryption.SecretRef}, encryption.KeyID, "binlog-ship")
+		}
+	}
+
+	return setPodTemplateHash(deployment)
 }
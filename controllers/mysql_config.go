@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strings"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	mysqlConfigMapName = "wordpress-mysql-config"
+	mysqlConfigFile    = "wordpress-operator.cnf"
+
+	// mysqlConfigChecksumAnnotation is set on the MySQL pod template so that a
+	// change to the rendered my.cnf rolls the database pod.
+	mysqlConfigChecksumAnnotation = "wordpress.example.com/mysql-config-checksum"
+)
+
+// mysqlInitOnlyConfigKeys are server options that are baked into the data
+// directory when MySQL initialises it. Changing them afterwards either has no
+// effect or leaves the server unable to start, so they are rejected once the
+// config has been rendered.
+var mysqlInitOnlyConfigKeys = []string{
+	"datadir",
+	"innodb_data_file_path",
+	"innodb_data_home_dir",
+	"innodb_log_group_home_dir",
+	"innodb_page_size",
+	"innodb_undo_directory",
+	"innodb_undo_tablespaces",
+	"lower_case_table_names",
+}
+
+func createMySQLConfigMap(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	configMap, err := newMySQLConfigMap(wordpress)
+	if err != nil {
+		log.Error(err, "Invalid MySQL config", "configmap.name", childName(wordpress, mysqlConfigMapName))
+		return ctrl.Result{}, err
+	}
+
+	found := &v1.ConfigMap{}
+	if objectNotFound(r, ctx, childName(wordpress, mysqlConfigMapName), found, *wordpress) {
+		if err := controllerutil.SetControllerReference(wordpress, configMap, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		err := r.Create(ctx, configMap)
+		if err != nil {
+			log.Error(err, "Failed to create MySQL ConfigMap", "configmap.name", configMap.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("Returned custom MySQL ConfigMap object", "name", req.NamespacedName.Name)
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if found.Data[mysqlConfigFile] == configMap.Data[mysqlConfigFile] {
+		return ctrl.Result{}, nil
+	}
+
+	if err := validateMySQLConfigChange(parseMySQLConfig(found.Data[mysqlConfigFile]), mysqlServerConfig(wordpress)); err != nil {
+		log.Error(err, "Refusing to update MySQL ConfigMap", "configmap.name", found.Name)
+		return ctrl.Result{}, err
+	}
+
+	found.Data = configMap.Data
+	err = r.Update(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to update MySQL ConfigMap", "configmap.name", found.Name)
+		return ctrl.Result{}, err
+	}
+	log.Info("Updated MySQL ConfigMap object", "name", req.NamespacedName.Name)
+	return ctrl.Result{Requeue: true}, nil
+}
+
+func newMySQLConfigMap(wordpress *wordpressv1.Wordpress) (*v1.ConfigMap, error) {
+	cnf, err := renderMySQLConfig(mysqlServerConfig(wordpress))
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, mysqlConfigMapName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app":  "wordpress",
+				"tier": "mysql",
+			},
+		},
+		Data: map[string]string{
+			mysqlConfigFile: cnf,
+		},
+	}, nil
+}
+
+// mysqlServerConfig returns spec.database.config with the options the
+// operator needs on top. Binary logging for point-in-time recovery overrides
+// the user's log-bin and binlog_format, which the shipper depends on, and
+// fills in server-id and expire_logs_days unless set.
+func mysqlServerConfig(wordpress *wordpressv1.Wordpress) map[string]string {
+	if !pointInTimeEnabled(wordpress) {
+		return wordpress.Spec.Database.Config
+	}
+
+	config := map[string]string{}
+	for key, value := range wordpress.Spec.Database.Config {
+		config[key] = value
+	}
+	for key, value := range binlogConfig(wordpress) {
+		switch key {
+		case "log-bin", "binlog_format":
+			delete(config, key)
+			delete(config, strings.ReplaceAll(key, "_", "-"))
+			delete(config, strings.ReplaceAll(key, "-", "_"))
+		default:
+			if lookupMySQLOption(config, key) != "" || lookupMySQLOption(config, strings.ReplaceAll(key, "-", "_")) != "" {
+				continue
+			}
+		}
+		config[key] = value
+	}
+	return config
+}
+
+// renderMySQLConfig renders the options as the [mysqld] section of a my.cnf
+// file. Values MySQL would otherwise misread are quoted by quoteMySQLValue.
+// Keys are sorted so the output, and therefore its checksum, is stable.
+func renderMySQLConfig(config map[string]string) (string, error) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		if key == "" || strings.ContainsAny(key, "=[]#\n\r") {
+			return "", fmt.Errorf("invalid MySQL option name %q", key)
+		}
+		if strings.ContainsAny(config[key], "\n\r") {
+			return "", fmt.Errorf("invalid value for MySQL option %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("[mysqld]\n")
+	for _, key := range keys {
+		if config[key] == "" {
+			fmt.Fprintf(&b, "%s\n", key)
+			continue
+		}
+		fmt.Fprintf(&b, "%s = %s\n", key, quoteMySQLValue(config[key]))
+	}
+	return b.String(), nil
+}
+
+// quoteMySQLValue double-quotes a value that holds a comment character, a
+// quote or a backslash, or that starts or ends with whitespace, escaping
+// quotes and backslashes. Other values are rendered as they are.
+func quoteMySQLValue(value string) string {
+	if !strings.ContainsAny(value, "#;\"'\\") && strings.TrimSpace(value) == value {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// unquoteMySQLValue reverses quoteMySQLValue.
+func unquoteMySQLValue(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var b strings.Builder
+	escaped := false
+	for _, c := range value[1 : len(value)-1] {
+		if c == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// parseMySQLConfig reverses renderMySQLConfig.
+func parseMySQLConfig(cnf string) map[string]string {
+	config := map[string]string{}
+	for _, line := range strings.Split(cnf, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 1 {
+			config[parts[0]] = ""
+			continue
+		}
+		config[strings.TrimSpace(parts[0])] = unquoteMySQLValue(strings.TrimSpace(parts[1]))
+	}
+	return config
+}
+
+// validateMySQLConfigChange returns an error if any init-only option differs
+// between the applied and the desired config. MySQL treats '-' and '_' in
+// option names alike, so both spellings are compared.
+func validateMySQLConfigChange(applied, desired map[string]string) error {
+	for _, key := range mysqlInitOnlyConfigKeys {
+		if lookupMySQLOption(applied, key) != lookupMySQLOption(desired, key) {
+			return fmt.Errorf("MySQL option %q cannot be changed after the database has been initialised", key)
+		}
+	}
+	return nil
+}
+
+func lookupMySQLOption(config map[string]string, key string) string {
+	if value, ok := config[key]; ok {
+		return value
+	}
+	return config[strings.ReplaceAll(key, "_", "-")]
+}
+
+// mysqlConfigChecksum returns the checksum of the rendered config for the
+// pod template annotation. Invalid config is reported by
+// createMySQLConfigMap, which runs first.
+func mysqlConfigChecksum(wordpress *wordpressv1.Wordpress) string {
+	cnf, _ := renderMySQLConfig(mysqlServerConfig(wordpress))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(cnf)))
+}
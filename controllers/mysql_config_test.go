@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderMySQLConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			config: nil,
+			want:   "[mysqld]\n",
+		},
+		{
+			name: "sorted keys and bare flags",
+			config: map[string]string{
+				"max_connections":      "200",
+				"skip-name-resolve":    "",
+				"character-set-server": "utf8mb4",
+			},
+			want: "[mysqld]\ncharacter-set-server = utf8mb4\nmax_connections = 200\nskip-name-resolve\n",
+		},
+		{
+			name:   "comment character is quoted",
+			config: map[string]string{"init_connect": "SET NAMES utf8mb4 # comment"},
+			want:   "[mysqld]\ninit_connect = \"SET NAMES utf8mb4 # comment\"\n",
+		},
+		{
+			name:   "quotes and backslashes are escaped",
+			config: map[string]string{"sql_mode": `"TRADITIONAL"`, "secure_file_priv": `C:\tmp`},
+			want:   "[mysqld]\nsecure_file_priv = \"C:\\\\tmp\"\nsql_mode = \"\\\"TRADITIONAL\\\"\"\n",
+		},
+		{
+			name:   "surrounding whitespace is quoted",
+			config: map[string]string{"ft_stopword_file": " stopwords.txt"},
+			want:   "[mysqld]\nft_stopword_file = \" stopwords.txt\"\n",
+		},
+		{
+			name:    "empty option name",
+			config:  map[string]string{"": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "option name with section bracket",
+			config:  map[string]string{"[client]": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "option name with equals sign",
+			config:  map[string]string{"a=b": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "value with newline",
+			config:  map[string]string{"max_connections": "200\n[client]"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMySQLConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderMySQLConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderMySQLConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMySQLConfigRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+	}{
+		{
+			name:   "plain values",
+			config: map[string]string{"max_connections": "200", "innodb_buffer_pool_size": "256M"},
+		},
+		{
+			name:   "bare flag",
+			config: map[string]string{"skip-name-resolve": ""},
+		},
+		{
+			name:   "value with equals sign",
+			config: map[string]string{"optimizer_switch": "index_merge=off,mrr=on"},
+		},
+		{
+			name: "quoted values",
+			config: map[string]string{
+				"init_connect":     "SET NAMES utf8mb4 # comment",
+				"sql_mode":         `"TRADITIONAL"`,
+				"secure_file_priv": `C:\tmp\`,
+				"ft_stopword_file": " stopwords.txt ",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cnf, err := renderMySQLConfig(tt.config)
+			if err != nil {
+				t.Fatalf("renderMySQLConfig() error = %v", err)
+			}
+			if got := parseMySQLConfig(cnf); !reflect.DeepEqual(got, tt.config) {
+				t.Errorf("parseMySQLConfig(%q) = %q, want %q", cnf, got, tt.config)
+			}
+		})
+	}
+}
+
+func TestValidateMySQLConfigChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied map[string]string
+		desired map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "runtime option changed",
+			applied: map[string]string{"max_connections": "100"},
+			desired: map[string]string{"max_connections": "200"},
+		},
+		{
+			name:    "init-only option unchanged",
+			applied: map[string]string{"innodb_page_size": "16k", "max_connections": "100"},
+			desired: map[string]string{"innodb_page_size": "16k", "max_connections": "200"},
+		},
+		{
+			name:    "init-only option changed",
+			applied: map[string]string{"innodb_page_size": "16k"},
+			desired: map[string]string{"innodb_page_size": "32k"},
+			wantErr: true,
+		},
+		{
+			name:    "init-only option added",
+			applied: map[string]string{},
+			desired: map[string]string{"lower_case_table_names": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "init-only option removed",
+			applied: map[string]string{"datadir": "/data"},
+			desired: map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "dash and underscore spellings are alike",
+			applied: map[string]string{"lower-case-table-names": "1"},
+			desired: map[string]string{"lower_case_table_names": "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMySQLConfigChange(tt.applied, tt.desired)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMySQLConfigChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// scopedNames reports whether the children of the instance are named after
+// it, see wordpressv1.AnnotationScopedNames.
+func scopedNames(wordpress *wordpressv1.Wordpress) bool {
+	return wordpress.Annotations[wordpressv1.AnnotationScopedNames] == "true"
+}
+
+// childName returns the name of the child of the instance whose fixed name is
+// name, e.g. wordpress-mysql. Instances with scoped names prefix it with
+// their own name.
+func childName(wordpress *wordpressv1.Wordpress, name string) string {
+	if scopedNames(wordpress) {
+		return wordpress.Name + "-" + name
+	}
+	return name
+}
+
+// tierLabels returns the labels of the pods of a tier of the instance, which
+// its Services and Deployments select. The pods of instances with scoped
+// names carry the instance label too, so that those of other instances in
+// the namespace are not selected.
+func tierLabels(wordpress *wordpressv1.Wordpress, tier string) map[string]string {
+	labels := map[string]string{
+		"app":  "wordpress",
+		"tier": tier,
+	}
+	if scopedNames(wordpress) {
+		labels[wordpressv1.LabelInstance] = wordpress.Name
+	}
+	return labels
+}
+
+// mysqlHost is the name of the Service of the database of the instance.
+func mysqlHost(wordpress *wordpressv1.Wordpress) string {
+	return childName(wordpress, "wordpress-mysql")
+}
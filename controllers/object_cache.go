@@ -0,0 +1,483 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strconv"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	redisImage      = "redis:6.0-alpine"
+	redisName       = "wordpress-redis"
+	redisSecretName = "redis-pass"
+	redisPort       = 6379
+
+	// redisCachePluginVersion is the Redis Object Cache release providing the
+	// drop-in. It is pinned so that the drop-in only changes with the operator.
+	redisCachePluginVersion = "1.6.1"
+
+	// objectCacheStatsInterval is how often the statistics of the object
+	// cache are read from Redis
+	objectCacheStatsInterval = time.Minute
+)
+
+// objectCacheScript points wp-config.php at Redis, installs and activates the
+// Redis Object Cache plugin, enables its drop-in and flushes the cache, which
+// may hold entries from before the cache was last disabled. With DISABLE set
+// it removes the drop-in, deactivates the plugin and removes the constants
+// instead. The drop-in is removed first so that wp-cli does not need Redis.
+const objectCacheScript = wpCLIPrelude + `
+wp core is-installed 2>/dev/null || fail "WordPress is not installed"
+network=""
+if wp core is-installed --network 2>/dev/null; then
+  network=--network
+fi
+
+if [ "$DISABLE" = true ]; then
+  dropin=/var/www/html/wp-content/object-cache.php
+  if [ -f "$dropin" ] && grep -q "Redis Object Cache" "$dropin"; then
+    rm -f "$dropin" || fail "removing the object cache drop-in failed"
+  fi
+  if wp plugin is-active redis-cache $network 2>/dev/null; then
+    wp plugin deactivate redis-cache $network || fail "deactivating redis-cache failed"
+  fi
+  for name in WP_REDIS_HOST WP_REDIS_PORT WP_REDIS_PASSWORD; do
+    if wp config has "$name" --type=constant; then
+      wp config delete "$name" --type=constant || fail "removing $name from wp-config.php failed"
+    fi
+  done
+  exit 0
+fi
+
+wp config set WP_REDIS_HOST "$REDIS_HOST" --type=constant || fail "setting WP_REDIS_HOST failed"
+wp config set WP_REDIS_PORT "$REDIS_PORT" --type=constant --raw || fail "setting WP_REDIS_PORT failed"
+wp config set WP_REDIS_PASSWORD "$REDIS_PASSWORD" --type=constant || fail "setting WP_REDIS_PASSWORD failed"
+wp plugin install redis-cache --version="$PLUGIN_VERSION" --force || fail "installing redis-cache $PLUGIN_VERSION failed"
+wp plugin activate redis-cache $network || fail "activating redis-cache failed"
+wp redis enable || fail "enabling the object cache drop-in failed"
+wp cache flush || fail "flushing the object cache failed"
+`
+
+func objectCacheEnabled(wordpress *wordpressv1.Wordpress) bool {
+	return wordpress.Spec.ObjectCache != nil && wordpress.Spec.ObjectCache.Enabled
+}
+
+// reconcileObjectCache deploys Redis for an instance with the object cache
+// enabled and runs a Job enabling the drop-in once Redis is ready. Once the
+// cache is disabled, a Job removes the drop-in before Redis is deleted. The
+// ObjectCache condition tracks both.
+func reconcileObjectCache(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	condition := meta.FindStatusCondition(wordpress.Status.Conditions, wordpressv1.ConditionObjectCache)
+
+	if !objectCacheEnabled(wordpress) {
+		if condition == nil {
+			return nil
+		}
+		if condition.Reason != wordpressv1.ReasonObjectCacheDisabled {
+			done, message, err := runObjectCacheJob(r, ctx, log, wordpress, false)
+			if err != nil {
+				return err
+			}
+			if message != "" {
+				return setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionFalse, wordpressv1.ReasonObjectCacheDisableFailed, message)
+			}
+			if !done {
+				return setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionFalse, wordpressv1.ReasonObjectCacheDisabling,
+					"Removing the object cache drop-in")
+			}
+			log.Info("Disabled the object cache")
+			err = setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionFalse, wordpressv1.ReasonObjectCacheDisabled,
+				"The object cache is disabled")
+			if err != nil {
+				return err
+			}
+		}
+		if err := deleteRedis(r, ctx, log, wordpress); err != nil {
+			return err
+		}
+		if wordpress.Status.ObjectCache == nil {
+			return nil
+		}
+		wordpress.Status.ObjectCache = nil
+		return r.Status().Update(ctx, wordpress)
+	}
+
+	if err := createRedis(r, ctx, log, wordpress); err != nil {
+		return err
+	}
+	if condition != nil && condition.Status == metav1.ConditionTrue {
+		return updateObjectCacheStats(r, ctx, log, wordpress)
+	}
+
+	ready, err := deploymentReady(r.Client, ctx, childName(wordpress, redisName), wordpress)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		return setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionFalse, wordpressv1.ReasonObjectCacheDeploying,
+			"Waiting for the wordpress-redis deployment to become ready")
+	}
+
+	done, message, err := runObjectCacheJob(r, ctx, log, wordpress, true)
+	if err != nil {
+		return err
+	}
+	if message != "" {
+		return setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionFalse, wordpressv1.ReasonObjectCacheEnableFailed, message)
+	}
+	if !done {
+		return setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionFalse, wordpressv1.ReasonObjectCacheEnabling,
+			"Enabling the object cache drop-in")
+	}
+	log.Info("Enabled the object cache")
+	return setCondition(r, ctx, wordpress, wordpressv1.ConditionObjectCache, metav1.ConditionTrue, wordpressv1.ReasonObjectCacheEnabled,
+		"The object cache is enabled")
+}
+
+// createRedis creates the password Secret, the volume, the Service and the
+// Deployment of Redis, and rolls the Deployment when spec.objectCache
+// changes.
+func createRedis(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	if objectNotFound(r, ctx, childName(wordpress, redisSecretName), &v1.Secret{}, *wordpress) {
+		password, err := generatePassword()
+		if err != nil {
+			return err
+		}
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      childName(wordpress, redisSecretName),
+				Namespace: wordpress.Namespace,
+			},
+			Type: "Opaque",
+			Data: map[string][]byte{
+				"password": []byte(password),
+			},
+		}
+		if err := createOwned(r, ctx, log, wordpress, secret); err != nil {
+			return err
+		}
+	}
+
+	if wordpress.Spec.ObjectCache.Persistence != nil && objectNotFound(r, ctx, childName(wordpress, "redis-pv-claim"), &v1.PersistentVolumeClaim{}, *wordpress) {
+		if err := createOwned(r, ctx, log, wordpress, newRedisPVC(wordpress)); err != nil {
+			return err
+		}
+	}
+
+	if objectNotFound(r, ctx, childName(wordpress, redisName), &v1.Service{}, *wordpress) {
+		if err := createOwned(r, ctx, log, wordpress, newRedisService(wordpress)); err != nil {
+			return err
+		}
+	}
+
+	if objectNotFound(r, ctx, childName(wordpress, redisName), &appsv1.Deployment{}, *wordpress) {
+		return createOwned(r, ctx, log, wordpress, newRedisDeployment(wordpress))
+	}
+	_, err := updateDeploymentTemplate(r, ctx, log, wordpress, newRedisDeployment(wordpress))
+	return err
+}
+
+// createOwned creates an object controlled by the instance.
+func createOwned(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, obj client.Object) error {
+	if err := controllerutil.SetControllerReference(wordpress, obj, r.Scheme); err != nil {
+		return err
+	}
+	kind := fmt.Sprintf("%T", obj)
+	err := r.Create(ctx, obj)
+	if err != nil {
+		log.Error(err, "Failed to create object", "kind", kind, "name", obj.GetName())
+		return err
+	}
+	log.Info("Returned custom object", "kind", kind, "name", obj.GetName())
+	return nil
+}
+
+// deleteRedis deletes the Redis objects created by createRedis.
+func deleteRedis(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, redisName), &appsv1.Deployment{}); err != nil {
+		return err
+	}
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, redisName), &v1.Service{}); err != nil {
+		return err
+	}
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, "redis-pv-claim"), &v1.PersistentVolumeClaim{}); err != nil {
+		return err
+	}
+	return deleteOwned(r, ctx, log, wordpress, childName(wordpress, redisSecretName), &v1.Secret{})
+}
+
+// deleteOwned deletes the named object if it is controlled by the instance.
+func deleteOwned(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, name string, obj client.Object) error {
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: wordpress.Namespace}, obj)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, wordpress) {
+		return nil
+	}
+	log.Info("Deleting object", "kind", fmt.Sprintf("%T", obj), "name", name)
+	return client.IgnoreNotFound(r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
+
+// runObjectCacheJob runs the Job enabling or disabling the drop-in, replacing
+// the Job of the opposite direction. It returns true once the Job succeeded,
+// or a message when it failed.
+func runObjectCacheJob(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, enable bool) (bool, string, error) {
+	name, other := objectCacheJobName(wordpress, enable), objectCacheJobName(wordpress, !enable)
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: wordpress.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, "", err
+	}
+	if errors.IsNotFound(err) {
+		err = r.Delete(ctx, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: other, Namespace: wordpress.Namespace}},
+			client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return false, "", err
+		}
+		return false, "", createOwned(r, ctx, log, wordpress, newObjectCacheJob(wordpress, name, enable))
+	}
+
+	if jobFailed(job) {
+		message, err := jobFailureMessage(r.Client, ctx, job)
+		if err != nil {
+			return false, "", err
+		}
+		if message == "" {
+			message = fmt.Sprintf("Job %s failed", job.Name)
+		}
+		return false, fmt.Sprintf("%s; delete Job %s to retry", message, job.Name), nil
+	}
+	return job.Status.Succeeded > 0, "", nil
+}
+
+func objectCacheJobName(wordpress *wordpressv1.Wordpress, enable bool) string {
+	if enable {
+		return wordpress.Name + "-object-cache-enable"
+	}
+	return wordpress.Name + "-object-cache-disable"
+}
+
+// updateObjectCacheStats reads the keyspace hits and misses from Redis every
+// objectCacheStatsInterval. Redis is only reachable when the operator runs in
+// the cluster; failures are reported in the status rather than retried.
+func updateObjectCacheStats(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	status := &wordpressv1.ObjectCacheStatus{}
+	if wordpress.Status.ObjectCache != nil {
+		status = wordpress.Status.ObjectCache.DeepCopy()
+	}
+	if status.LastUpdateTime != nil && time.Since(status.LastUpdateTime.Time) < objectCacheStatsInterval {
+		return nil
+	}
+
+	secret := &v1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: childName(wordpress, redisSecretName), Namespace: wordpress.Namespace}, secret)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	status.LastUpdateTime = &now
+	status.Error = ""
+	addr := fmt.Sprintf("%s.%s.svc:%d", childName(wordpress, redisName), wordpress.Namespace, redisPort)
+	info, err := redisInfo(ctx, addr, string(secret.Data["password"]), "stats")
+	if err != nil {
+		log.Info("Failed to read object cache statistics", "error", err.Error())
+		status.Error = err.Error()
+	} else {
+		status.Hits, _ = strconv.ParseInt(info["keyspace_hits"], 10, 64)
+		status.Misses, _ = strconv.ParseInt(info["keyspace_misses"], 10, 64)
+		status.HitRatio = ""
+		if lookups := status.Hits + status.Misses; lookups > 0 {
+			status.HitRatio = strconv.FormatFloat(float64(status.Hits)/float64(lookups), 'f', 2, 64)
+		}
+	}
+
+	wordpress.Status.ObjectCache = status
+	return r.Status().Update(ctx, wordpress)
+}
+
+func newRedisPVC(wordpress *wordpressv1.Wordpress) *v1.PersistentVolumeClaim {
+	persistence := wordpress.Spec.ObjectCache.Persistence
+	size := resource.MustParse("1Gi")
+	if persistence.Size != nil {
+		size = *persistence.Size
+	}
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, "redis-pv-claim"),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{
+				v1.ReadWriteOnce,
+			},
+			StorageClassName: persistence.StorageClassName,
+			Resources: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+}
+
+func newRedisService(wordpress *wordpressv1.Wordpress) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, redisName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Port: redisPort,
+				},
+			},
+			Selector: tierLabels(wordpress, "redis"),
+		},
+	}
+}
+
+// newRedisDeployment returns the Redis Deployment, which evicts the least
+// recently used keys once the memory limit is nearly reached and only writes
+// to disk with persistence.
+func newRedisDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
+	spec := wordpress.Spec.ObjectCache
+
+	args := []string{"--requirepass", "$(REDIS_PASSWORD)", "--maxmemory-policy", "allkeys-lru"}
+	if limit, ok := spec.Resources.Limits[v1.ResourceMemory]; ok {
+		args = append(args, "--maxmemory", strconv.FormatInt(limit.Value()*9/10, 10))
+	}
+	volumes := []v1.Volume{}
+	mounts := []v1.VolumeMount{}
+	if spec.Persistence != nil {
+		args = append(args, "--appendonly", "yes")
+		volumes = append(volumes, v1.Volume{
+			Name: "redis-persistent-storage",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: childName(wordpress, "redis-pv-claim"),
+				},
+			},
+		})
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      "redis-persistent-storage",
+			MountPath: "/data",
+		})
+	} else {
+		args = append(args, "--save", "")
+	}
+
+	probe := &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(redisPort),
+			},
+		},
+		PeriodSeconds: 10,
+	}
+
+	return setPodTemplateHash(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, redisName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: tierLabels(wordpress, "redis"),
+			},
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RecreateDeploymentStrategyType,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tierLabels(wordpress, "redis"),
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image: redisImage,
+							Name:  "redis",
+							Args:  args,
+							Env: []v1.EnvVar{
+								{
+									Name: "REDIS_PASSWORD",
+									ValueFrom: &v1.EnvVarSource{
+										SecretKeyRef: &v1.SecretKeySelector{
+											LocalObjectReference: v1.LocalObjectReference{
+												Name: childName(wordpress, redisSecretName),
+											},
+											Key: "password",
+										},
+									},
+								},
+							},
+							Ports: []v1.ContainerPort{
+								{
+									Name:          "redis",
+									ContainerPort: redisPort,
+								},
+							},
+							Resources:      spec.Resources,
+							ReadinessProbe: probe,
+							LivenessProbe:  probe,
+							VolumeMounts:   mounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	})
+}
+
+// newObjectCacheJob returns the Job running objectCacheScript with wp-cli
+// against the running instance.
+func newObjectCacheJob(wordpress *wordpressv1.Wordpress, name string, enable bool) *batchv1.Job {
+	env := []v1.EnvVar{
+		{Name: "DISABLE", Value: fmt.Sprint(!enable)},
+	}
+	if enable {
+		env = append(env,
+			v1.EnvVar{Name: "REDIS_HOST", Value: childName(wordpress, redisName)},
+			v1.EnvVar{Name: "REDIS_PORT", Value: fmt.Sprint(redisPort)},
+			v1.EnvVar{
+				Name: "REDIS_PASSWORD",
+				ValueFrom: &v1.EnvVarSource{
+					SecretKeyRef: &v1.SecretKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: childName(wordpress, redisSecretName)},
+						Key:                  "password",
+					},
+				},
+			},
+			v1.EnvVar{Name: "PLUGIN_VERSION", Value: redisCachePluginVersion},
+		)
+	}
+
+	return newWPCLIJob(wordpress, name, "object-cache", objectCacheScript, env)
+}
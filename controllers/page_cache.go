@@ -0,0 +1,472 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	varnishImage = "varnish:6.0"
+	varnishName  = "wordpress-varnish"
+
+	// varnishBackendName is the Service of the frontend pods that Varnish
+	// fetches from while the wordpress Service is routed through Varnish.
+	varnishBackendName = "wordpress-backend"
+
+	// varnishPurgePort is the port accepting PURGE and BAN requests. It is
+	// only exposed by the in-cluster wordpress-varnish Service, never by the
+	// wordpress Service, and only accepts requests carrying the token of the
+	// varnishPurgeSecretName Secret in the X-Purge-Token header.
+	varnishPurgePort = 6091
+
+	varnishPurgeSecretName = "varnish-purge"
+	varnishPurgeTokenDir   = "/etc/varnish-purge"
+
+	// varnishVCLChecksumAnnotation is set on the Varnish pod template so that
+	// a change to the rendered VCL rolls the Varnish pod.
+	varnishVCLChecksumAnnotation = "wordpress.example.com/vcl-checksum"
+
+	// pageCachePurgeTimeout bounds a purge request to Varnish
+	pageCachePurgeTimeout = 5 * time.Second
+)
+
+// varnishVCL is the VCL of the page cache. Requests carrying the cookies of
+// logged-in users, commenters or password-protected posts, and requests of
+// wp-admin, the login page, WP-Cron and previews are passed to WordPress;
+// everything else is cached for the TTL without cookies. The purge listener
+// accepts PURGE of a single URL and BAN of the whole cache from clients
+// presenting the purge token.
+const varnishVCL = `vcl 4.1;
+
+import std;
+
+backend default {
+    .host = "%s";
+    .port = "80";
+}
+
+sub vcl_recv {
+    if (local.socket == "purge") {
+        if (!req.http.X-Purge-Token || req.http.X-Purge-Token != std.fileread("%s/token")) {
+            return (synth(403, "Forbidden"));
+        }
+        if (req.method == "PURGE") {
+            return (purge);
+        }
+        if (req.method == "BAN") {
+            ban("obj.status != 0");
+            return (synth(200, "Banned"));
+        }
+        return (synth(405, "Method Not Allowed"));
+    }
+    if (req.method == "PURGE" || req.method == "BAN") {
+        return (synth(405, "Method Not Allowed"));
+    }
+    if (req.method != "GET" && req.method != "HEAD") {
+        return (pass);
+    }
+    if (req.url ~ "/wp-(admin/|login\.php|cron\.php)" || req.url ~ "[?&]preview=true") {
+        return (pass);
+    }
+    if (req.http.Cookie ~ "(wordpress_logged_in_|wordpress_sec_|wp-postpass_|comment_author_)") {
+        return (pass);
+    }
+    unset req.http.Cookie;
+    return (hash);
+}
+
+sub vcl_hash {
+    hash_data(req.http.X-Forwarded-Proto);
+}
+
+sub vcl_backend_response {
+    if (beresp.http.Set-Cookie || beresp.http.Cache-Control ~ "(private|no-cache|no-store)" || beresp.status >= 500) {
+        set beresp.uncacheable = true;
+        set beresp.ttl = 120s;
+        return (deliver);
+    }
+    set beresp.ttl = %ds;
+    set beresp.grace = 1h;
+}
+
+sub vcl_deliver {
+    if (obj.hits > 0) {
+        set resp.http.X-Cache = "HIT";
+    } else {
+        set resp.http.X-Cache = "MISS";
+    }
+}
+`
+
+func pageCacheEnabled(wordpress *wordpressv1.Wordpress) bool {
+	return wordpress.Spec.PageCache != nil && wordpress.Spec.PageCache.Enabled
+}
+
+// reconcilePageCache deploys Varnish for an instance with the page cache
+// enabled and routes the wordpress Service, and so the Ingress, through it
+// once Varnish is ready. Once the cache is disabled, the Service is routed
+// back to the frontend before Varnish is deleted. The PageCache condition
+// tracks both.
+func reconcilePageCache(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	condition := meta.FindStatusCondition(wordpress.Status.Conditions, wordpressv1.ConditionPageCache)
+
+	if !pageCacheEnabled(wordpress) {
+		if condition == nil {
+			return nil
+		}
+		if err := routeWordpressService(r, ctx, log, wordpress, "frontend"); err != nil {
+			return err
+		}
+		if err := deleteVarnish(r, ctx, log, wordpress); err != nil {
+			return err
+		}
+		return setCondition(r, ctx, wordpress, wordpressv1.ConditionPageCache, metav1.ConditionFalse, wordpressv1.ReasonPageCacheDisabled,
+			"The page cache is disabled")
+	}
+
+	if err := createVarnish(r, ctx, log, wordpress); err != nil {
+		return err
+	}
+	ready, err := deploymentReady(r.Client, ctx, childName(wordpress, varnishName), wordpress)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		if condition != nil && condition.Status == metav1.ConditionTrue {
+			// A rollout of Varnish keeps serving from the ready pods
+			return nil
+		}
+		return setCondition(r, ctx, wordpress, wordpressv1.ConditionPageCache, metav1.ConditionFalse, wordpressv1.ReasonPageCacheDeploying,
+			"Waiting for the wordpress-varnish deployment to become ready")
+	}
+
+	if err := routeWordpressService(r, ctx, log, wordpress, "varnish"); err != nil {
+		return err
+	}
+	return setCondition(r, ctx, wordpress, wordpressv1.ConditionPageCache, metav1.ConditionTrue, wordpressv1.ReasonPageCacheEnabled,
+		"The wordpress Service is routed through Varnish")
+}
+
+// createVarnish creates the purge token Secret, the VCL ConfigMap, the
+// backend and Varnish Services and the Varnish Deployment, and keeps the VCL
+// and the Deployment in line with spec.pageCache. The backend Service must
+// exist before Varnish starts, as the VCL fails to load when its backend does
+// not resolve.
+func createVarnish(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	if objectNotFound(r, ctx, childName(wordpress, varnishPurgeSecretName), &v1.Secret{}, *wordpress) {
+		token, err := generatePassword()
+		if err != nil {
+			return err
+		}
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      childName(wordpress, varnishPurgeSecretName),
+				Namespace: wordpress.Namespace,
+			},
+			Type: "Opaque",
+			Data: map[string][]byte{
+				"token": []byte(token),
+			},
+		}
+		if err := createOwned(r, ctx, log, wordpress, secret); err != nil {
+			return err
+		}
+	}
+
+	configMap := newVarnishConfigMap(wordpress)
+	found := &v1.ConfigMap{}
+	if objectNotFound(r, ctx, childName(wordpress, varnishName), found, *wordpress) {
+		if err := createOwned(r, ctx, log, wordpress, configMap); err != nil {
+			return err
+		}
+	} else if !equality.Semantic.DeepEqual(found.Data, configMap.Data) {
+		found.Data = configMap.Data
+		err := r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update Varnish ConfigMap", "configmap.name", found.Name)
+			return err
+		}
+		log.Info("Updated Varnish ConfigMap object", "configmap.name", found.Name)
+	}
+
+	if objectNotFound(r, ctx, childName(wordpress, varnishBackendName), &v1.Service{}, *wordpress) {
+		if err := createOwned(r, ctx, log, wordpress, newVarnishBackendService(wordpress)); err != nil {
+			return err
+		}
+	}
+
+	if objectNotFound(r, ctx, childName(wordpress, varnishName), &v1.Service{}, *wordpress) {
+		if err := createOwned(r, ctx, log, wordpress, newVarnishService(wordpress)); err != nil {
+			return err
+		}
+	}
+
+	if objectNotFound(r, ctx, childName(wordpress, varnishName), &appsv1.Deployment{}, *wordpress) {
+		return createOwned(r, ctx, log, wordpress, newVarnishDeployment(wordpress))
+	}
+	_, err := updateDeploymentTemplate(r, ctx, log, wordpress, newVarnishDeployment(wordpress))
+	return err
+}
+
+// deleteVarnish deletes the Varnish objects created by createVarnish.
+func deleteVarnish(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) error {
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, varnishName), &appsv1.Deployment{}); err != nil {
+		return err
+	}
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, varnishName), &v1.Service{}); err != nil {
+		return err
+	}
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, varnishBackendName), &v1.Service{}); err != nil {
+		return err
+	}
+	if err := deleteOwned(r, ctx, log, wordpress, childName(wordpress, varnishName), &v1.ConfigMap{}); err != nil {
+		return err
+	}
+	return deleteOwned(r, ctx, log, wordpress, childName(wordpress, varnishPurgeSecretName), &v1.Secret{})
+}
+
+// routeWordpressService points the wordpress Service at the pods of tier.
+func routeWordpressService(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, tier string) error {
+	service := &v1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: childName(wordpress, "wordpress"), Namespace: wordpress.Namespace}, service)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if service.Spec.Selector["tier"] == tier {
+		return nil
+	}
+
+	service.Spec.Selector = tierLabels(wordpress, tier)
+	err = r.Update(ctx, service)
+	if err != nil {
+		log.Error(err, "Failed to route Wordpress Service", "service.name", service.Name, "tier", tier)
+		return err
+	}
+	log.Info("Routed Wordpress Service", "service.name", service.Name, "tier", tier)
+	return nil
+}
+
+// purgePageCache bans every cached page of an instance with the page cache
+// enabled, after operations that change the content of the site. Varnish is
+// only reachable when the operator runs in the cluster; failures are logged
+// and the stale pages expire with the TTL.
+func purgePageCache(c client.Client, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress) {
+	if !pageCacheEnabled(wordpress) {
+		return
+	}
+
+	secret := &v1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: childName(wordpress, varnishPurgeSecretName), Namespace: wordpress.Namespace}, secret)
+	if err != nil {
+		log.Info("Failed to purge the page cache", "error", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pageCachePurgeTimeout)
+	defer cancel()
+	url := fmt.Sprintf("http://%s.%s.svc:%d/", childName(wordpress, varnishName), wordpress.Namespace, varnishPurgePort)
+	req, err := http.NewRequestWithContext(ctx, "BAN", url, nil)
+	if err != nil {
+		log.Info("Failed to purge the page cache", "error", err.Error())
+		return
+	}
+	req.Header.Set("X-Purge-Token", string(secret.Data["token"]))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Info("Failed to purge the page cache", "error", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Info("Failed to purge the page cache", "status", resp.Status)
+		return
+	}
+	log.Info("Purged the page cache", "wordpress.name", wordpress.Name)
+}
+
+// renderVarnishVCL returns the VCL for spec.pageCache.
+func renderVarnishVCL(wordpress *wordpressv1.Wordpress) string {
+	ttl := 2 * time.Minute
+	if spec := wordpress.Spec.PageCache; spec != nil && spec.TTL != nil {
+		ttl = spec.TTL.Duration
+	}
+	return fmt.Sprintf(varnishVCL, childName(wordpress, varnishBackendName), varnishPurgeTokenDir, int64(ttl/time.Second))
+}
+
+func newVarnishConfigMap(wordpress *wordpressv1.Wordpress) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, varnishName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Data: map[string]string{
+			"default.vcl": renderVarnishVCL(wordpress),
+		},
+	}
+}
+
+// newVarnishBackendService returns the Service of the frontend pods, which
+// the wordpress Service stops selecting while it is routed through Varnish.
+func newVarnishBackendService(wordpress *wordpressv1.Wordpress) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, varnishBackendName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Port: 80,
+				},
+			},
+			Selector: tierLabels(wordpress, "frontend"),
+		},
+	}
+}
+
+func newVarnishService(wordpress *wordpressv1.Wordpress) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, varnishName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Name: "http",
+					Port: 80,
+				},
+				{
+					Name: "purge",
+					Port: varnishPurgePort,
+				},
+			},
+			Selector: tierLabels(wordpress, "varnish"),
+		},
+	}
+}
+
+// newVarnishDeployment returns the Varnish Deployment, which keeps the cache
+// in memory and serves the wordpress Service on port 80 once routed.
+func newVarnishDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
+	spec := wordpress.Spec.PageCache
+	size := resource.MustParse("256Mi")
+	if spec.Size != nil {
+		size = *spec.Size
+	}
+
+	probe := &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(80),
+			},
+		},
+		PeriodSeconds: 10,
+	}
+
+	return setPodTemplateHash(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, varnishName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app": "wordpress",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: tierLabels(wordpress, "varnish"),
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tierLabels(wordpress, "varnish"),
+					Annotations: map[string]string{
+						varnishVCLChecksumAnnotation: fmt.Sprintf("%x", sha256.Sum256([]byte(renderVarnishVCL(wordpress)))),
+					},
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image: varnishImage,
+							Name:  "varnish",
+							Command: []string{
+								"varnishd", "-F",
+								"-f", "/etc/varnish/default.vcl",
+								"-a", "http=:80,HTTP",
+								"-a", fmt.Sprintf("purge=:%d,HTTP", varnishPurgePort),
+								"-s", fmt.Sprintf("malloc,%d", size.Value()),
+							},
+							Ports: []v1.ContainerPort{
+								{
+									Name:          "http",
+									ContainerPort: 80,
+								},
+								{
+									Name:          "purge",
+									ContainerPort: varnishPurgePort,
+								},
+							},
+							Resources:      spec.Resources,
+							ReadinessProbe: probe,
+							LivenessProbe:  probe,
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "varnish-config",
+									MountPath: "/etc/varnish",
+									ReadOnly:  true,
+								},
+								{
+									Name:      "varnish-purge",
+									MountPath: varnishPurgeTokenDir,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "varnish-config",
+							VolumeSource: v1.VolumeSource{
+								ConfigMap: &v1.ConfigMapVolumeSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: childName(wordpress, varnishName),
+									},
+								},
+							},
+						},
+						{
+							Name: "varnish-purge",
+							VolumeSource: v1.VolumeSource{
+								Secret: &v1.SecretVolumeSource{
+									SecretName: childName(wordpress, varnishPurgeSecretName),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
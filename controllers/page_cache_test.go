@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+func TestRenderVarnishVCL(t *testing.T) {
+	tests := []struct {
+		name string
+		spec *wordpressv1.PageCacheSpec
+		ttl  string
+	}{
+		{name: "default TTL", spec: &wordpressv1.PageCacheSpec{Enabled: true}, ttl: "set beresp.ttl = 120s;"},
+		{name: "custom TTL", spec: &wordpressv1.PageCacheSpec{Enabled: true, TTL: &metav1.Duration{Duration: time.Hour}}, ttl: "set beresp.ttl = 3600s;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wordpress := &wordpressv1.Wordpress{}
+			wordpress.Spec.PageCache = tt.spec
+			vcl := renderVarnishVCL(wordpress)
+
+			for _, want := range []string{
+				tt.ttl,
+				`.host = "wordpress-backend";`,
+				`req.http.X-Purge-Token != std.fileread("/etc/varnish-purge/token")`,
+			} {
+				if !strings.Contains(vcl, want) {
+					t.Errorf("renderVarnishVCL() does not contain %q:\n%s", want, vcl)
+				}
+			}
+			if strings.Contains(vcl, "%!") {
+				t.Errorf("renderVarnishVCL() has a formatting error:\n%s", vcl)
+			}
+
+			// The token check comes before any purge is served.
+			if strings.Index(vcl, "X-Purge-Token") > strings.Index(vcl, "return (purge)") {
+				t.Errorf("renderVarnishVCL() serves purges before checking the token:\n%s", vcl)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strings"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	phpConfigMapName = "wordpress-php-config"
+
+	// phpConfigFile is named to sort after the ini files of the image, such
+	// as opcache-recommended.ini, so that its directives take precedence.
+	phpConfigFile = "zz-wordpress-operator.ini"
+
+	// phpConfigChecksumAnnotation is set on the frontend pod template so that
+	// a change to the rendered php.ini rolls the frontend pods.
+	phpConfigChecksumAnnotation = "wordpress.example.com/php-config-checksum"
+)
+
+// phpConfigDirectives are the php.ini directives spec.php may set. Other
+// directives are rejected, as some of them, such as extension or
+// disable_functions, could leave the frontend unable to start.
+var phpConfigDirectives = []string{
+	"allow_url_fopen",
+	"date.timezone",
+	"default_socket_timeout",
+	"display_errors",
+	"display_startup_errors",
+	"error_reporting",
+	"expose_php",
+	"log_errors",
+	"max_execution_time",
+	"max_file_uploads",
+	"max_input_time",
+	"max_input_vars",
+	"memory_limit",
+	"opcache.enable",
+	"opcache.enable_cli",
+	"opcache.interned_strings_buffer",
+	"opcache.max_accelerated_files",
+	"opcache.memory_consumption",
+	"opcache.revalidate_freq",
+	"opcache.save_comments",
+	"opcache.validate_timestamps",
+	"output_buffering",
+	"post_max_size",
+	"realpath_cache_size",
+	"realpath_cache_ttl",
+	"session.cookie_httponly",
+	"session.cookie_secure",
+	"session.gc_maxlifetime",
+	"upload_max_filesize",
+}
+
+// phpBareValue matches values that are rendered without quotes, so that
+// constants such as On and E_ALL & ~E_NOTICE keep their meaning.
+var phpBareValue = regexp.MustCompile(`^[A-Za-z0-9_.:/+~&| -]*$`)
+
+func createPHPConfigMap(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	configMap, err := newPHPConfigMap(wordpress)
+	if err != nil {
+		log.Error(err, "Invalid PHP config", "configmap.name", childName(wordpress, phpConfigMapName))
+		return ctrl.Result{}, err
+	}
+
+	found := &v1.ConfigMap{}
+	if objectNotFound(r, ctx, childName(wordpress, phpConfigMapName), found, *wordpress) {
+		if err := controllerutil.SetControllerReference(wordpress, configMap, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		err := r.Create(ctx, configMap)
+		if err != nil {
+			log.Error(err, "Failed to create PHP ConfigMap", "configmap.name", configMap.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("Returned custom PHP ConfigMap object", "name", req.NamespacedName.Name)
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if found.Data[phpConfigFile] == configMap.Data[phpConfigFile] {
+		return ctrl.Result{}, nil
+	}
+
+	found.Data = configMap.Data
+	err = r.Update(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to update PHP ConfigMap", "configmap.name", found.Name)
+		return ctrl.Result{}, err
+	}
+	log.Info("Updated PHP ConfigMap object", "name", req.NamespacedName.Name)
+	return ctrl.Result{Requeue: true}, nil
+}
+
+func newPHPConfigMap(wordpress *wordpressv1.Wordpress) (*v1.ConfigMap, error) {
+	ini, err := renderPHPConfig(wordpress.Spec.PHP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(wordpress, phpConfigMapName),
+			Namespace: wordpress.Namespace,
+			Labels: map[string]string{
+				"app":  "wordpress",
+				"tier": "frontend",
+			},
+		},
+		Data: map[string]string{
+			phpConfigFile: ini,
+		},
+	}, nil
+}
+
+// renderPHPConfig renders the directives as a php.ini file, rejecting
+// directives outside phpConfigDirectives. Values that are not plain words,
+// numbers or constant expressions are quoted. Keys are sorted so the output,
+// and therefore its checksum, is stable.
+func renderPHPConfig(config map[string]string) (string, error) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		if !containsString(phpConfigDirectives, key) {
+			return "", fmt.Errorf("unsupported PHP directive %q, supported are %s", key, strings.Join(phpConfigDirectives, ", "))
+		}
+		if strings.ContainsAny(config[key], "\"\n\r") {
+			return "", fmt.Errorf("invalid value for PHP directive %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("; Managed by the wordpress operator from spec.php\n")
+	for _, key := range keys {
+		value := config[key]
+		if value == "" || !phpBareValue.MatchString(value) {
+			value = `"` + value + `"`
+		}
+		fmt.Fprintf(&b, "%s = %s\n", key, value)
+	}
+	return b.String(), nil
+}
+
+// phpConfigChecksum returns the checksum of the rendered config for the pod
+// template annotation. Invalid config is reported by createPHPConfigMap,
+// which runs first.
+func phpConfigChecksum(wordpress *wordpressv1.Wordpress) string {
+	ini, _ := renderPHPConfig(wordpress.Spec.PHP)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(ini)))
+}
+
+// addPHPConfig mounts the rendered php.ini into the conf.d directory of the
+// WordPress container of the frontend pod template.
+func addPHPConfig(template *v1.PodTemplateSpec, wordpress *wordpressv1.Wordpress) {
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[phpConfigChecksumAnnotation] = phpConfigChecksum(wordpress)
+
+	template.Spec.Volumes = append(template.Spec.Volumes, v1.Volume{
+		Name: "php-config",
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{
+					Name: childName(wordpress, phpConfigMapName),
+				},
+			},
+		},
+	})
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name != "wordpress" {
+			continue
+		}
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+			Name:      "php-config",
+			MountPath: "/usr/local/etc/php/conf.d/" + phpConfigFile,
+			SubPath:   phpConfigFile,
+			ReadOnly:  true,
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"testing"
+
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+func TestRenderPHPConfig(t *testing.T) {
+	const header = "; Managed by the wordpress operator from spec.php\n"
+
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			config: nil,
+			want:   header,
+		},
+		{
+			name: "sorted directives",
+			config: map[string]string{
+				"upload_max_filesize": "64M",
+				"memory_limit":        "256M",
+				"opcache.enable":      "1",
+			},
+			want: header + "memory_limit = 256M\nopcache.enable = 1\nupload_max_filesize = 64M\n",
+		},
+		{
+			name: "constants stay bare",
+			config: map[string]string{
+				"error_reporting": "E_ALL & ~E_NOTICE",
+				"display_errors":  "Off",
+				"date.timezone":   "Europe/Berlin",
+			},
+			want: header + "date.timezone = Europe/Berlin\ndisplay_errors = Off\nerror_reporting = E_ALL & ~E_NOTICE\n",
+		},
+		{
+			name:   "comment character is quoted",
+			config: map[string]string{"date.timezone": "UTC; comment"},
+			want:   header + "date.timezone = \"UTC; comment\"\n",
+		},
+		{
+			name:   "other characters are quoted",
+			config: map[string]string{"output_buffering": "{4096}", "date.timezone": "$TZ"},
+			want:   header + "date.timezone = \"$TZ\"\noutput_buffering = \"{4096}\"\n",
+		},
+		{
+			name:   "empty value is quoted",
+			config: map[string]string{"date.timezone": ""},
+			want:   header + "date.timezone = \"\"\n",
+		},
+		{
+			name:    "unsupported directive",
+			config:  map[string]string{"memory_limit": "256M", "disable_functions": "exec"},
+			wantErr: true,
+		},
+		{
+			name:    "extension directive",
+			config:  map[string]string{"extension": "redis.so"},
+			wantErr: true,
+		},
+		{
+			name:    "value with quote",
+			config:  map[string]string{"date.timezone": `UTC" auto_prepend_file="/tmp/x`},
+			wantErr: true,
+		},
+		{
+			name:    "value with newline",
+			config:  map[string]string{"memory_limit": "256M\nauto_prepend_file = /tmp/x"},
+			wantErr: true,
+		},
+		{
+			name:    "value with carriage return",
+			config:  map[string]string{"memory_limit": "256M\rauto_prepend_file = /tmp/x"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPHPConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderPHPConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderPHPConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPHPConfigChecksumIsStable(t *testing.T) {
+	directives := []string{"memory_limit", "max_execution_time", "post_max_size", "upload_max_filesize", "opcache.enable"}
+
+	// Maps filled in a different order iterate in a different order, which
+	// must not change the checksum.
+	a, b := &wordpressv1.Wordpress{}, &wordpressv1.Wordpress{}
+	a.Spec.PHP, b.Spec.PHP = map[string]string{}, map[string]string{}
+	for i := range directives {
+		a.Spec.PHP[directives[i]] = "1"
+		b.Spec.PHP[directives[len(directives)-1-i]] = "1"
+	}
+
+	want := phpConfigChecksum(a)
+	for i := 0; i < 20; i++ {
+		if got := phpConfigChecksum(b); got != want {
+			t.Fatalf("phpConfigChecksum() = %s, want %s", got, want)
+		}
+	}
+
+	b.Spec.PHP["memory_limit"] = "2"
+	if phpConfigChecksum(b) == want {
+		t.Error("phpConfigChecksum() did not change with the config")
+	}
+}
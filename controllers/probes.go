@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const defaultWordpressProbePath = "/wp-login.php"
+
+// mysqlPingCommand succeeds once mysqld accepts connections. mysqladmin ping
+// exits 0 even when the credentials are rejected, so a password change does
+// not mark the database unready.
+var mysqlPingCommand = []string{"sh", "-c", `mysqladmin ping -h 127.0.0.1 -uroot -p"$MYSQL_ROOT_PASSWORD" --silent`}
+
+func mysqlReadinessProbe(wordpress *wordpressv1.Wordpress) *v1.Probe {
+	return newProbe(v1.Handler{
+		Exec: &v1.ExecAction{Command: mysqlPingCommand},
+	}, v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}, wordpress.Spec.Database.Probes.Readiness)
+}
+
+func mysqlLivenessProbe(wordpress *wordpressv1.Wordpress) *v1.Probe {
+	return newProbe(v1.Handler{
+		TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(3306)},
+	}, v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 6,
+	}, wordpress.Spec.Database.Probes.Liveness)
+}
+
+// mysqlStartupProbe allows up to ten minutes for the first start, during
+// which the image initialises the data directory and restarts mysqld.
+func mysqlStartupProbe(wordpress *wordpressv1.Wordpress) *v1.Probe {
+	return newProbe(v1.Handler{
+		Exec: &v1.ExecAction{Command: mysqlPingCommand},
+	}, v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 60,
+	}, wordpress.Spec.Database.Probes.Startup)
+}
+
+func wordpressReadinessProbe(wordpress *wordpressv1.Wordpress) *v1.Probe {
+	return newProbe(wordpressHTTPGet(wordpress), v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}, wordpress.Spec.Frontend.Probes.Readiness)
+}
+
+// wordpressLivenessProbe only checks that Apache accepts connections so that
+// a database outage, which makes every PHP page fail, does not restart the
+// frontend.
+func wordpressLivenessProbe(wordpress *wordpressv1.Wordpress) *v1.Probe {
+	return newProbe(v1.Handler{
+		TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(80)},
+	}, v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 6,
+	}, wordpress.Spec.Frontend.Probes.Liveness)
+}
+
+// wordpressStartupProbe allows up to five minutes for the first start, during
+// which the image copies WordPress onto the empty volume.
+func wordpressStartupProbe(wordpress *wordpressv1.Wordpress) *v1.Probe {
+	return newProbe(wordpressHTTPGet(wordpress), v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 30,
+	}, wordpress.Spec.Frontend.Probes.Startup)
+}
+
+func wordpressHTTPGet(wordpress *wordpressv1.Wordpress) v1.Handler {
+	path := wordpress.Spec.Frontend.ProbePath
+	if path == "" {
+		path = defaultWordpressProbePath
+	}
+	return v1.Handler{
+		HTTPGet: &v1.HTTPGetAction{
+			Path: path,
+			Port: intstr.FromInt(80),
+		},
+	}
+}
+
+// newProbe returns a probe running handler with the default timings
+// overridden by the non-zero fields of tuning.
+func newProbe(handler v1.Handler, defaults v1.Probe, tuning *wordpressv1.ProbeSpec) *v1.Probe {
+	probe := defaults
+	probe.Handler = handler
+	if tuning == nil {
+		return &probe
+	}
+	if tuning.InitialDelaySeconds != 0 {
+		probe.InitialDelaySeconds = tuning.InitialDelaySeconds
+	}
+	if tuning.PeriodSeconds != 0 {
+		probe.PeriodSeconds = tuning.PeriodSeconds
+	}
+	if tuning.TimeoutSeconds != 0 {
+		probe.TimeoutSeconds = tuning.TimeoutSeconds
+	}
+	if tuning.FailureThreshold != 0 {
+		probe.FailureThreshold = tuning.FailureThreshold
+	}
+	return &probe
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+func TestNewProbe(t *testing.T) {
+	handler := v1.Handler{
+		TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(80)},
+	}
+	defaults := v1.Probe{
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+
+	tests := []struct {
+		name   string
+		tuning *wordpressv1.ProbeSpec
+		want   v1.Probe
+	}{
+		{
+			name:   "no tuning keeps the defaults",
+			tuning: nil,
+			want: v1.Probe{
+				Handler:          handler,
+				PeriodSeconds:    10,
+				TimeoutSeconds:   5,
+				FailureThreshold: 3,
+			},
+		},
+		{
+			name:   "zero values keep the defaults",
+			tuning: &wordpressv1.ProbeSpec{},
+			want: v1.Probe{
+				Handler:          handler,
+				PeriodSeconds:    10,
+				TimeoutSeconds:   5,
+				FailureThreshold: 3,
+			},
+		},
+		{
+			name:   "non-zero values override the defaults",
+			tuning: &wordpressv1.ProbeSpec{PeriodSeconds: 5, FailureThreshold: 10},
+			want: v1.Probe{
+				Handler:          handler,
+				PeriodSeconds:    5,
+				TimeoutSeconds:   5,
+				FailureThreshold: 10,
+			},
+		},
+		{
+			name: "every field is tunable",
+			tuning: &wordpressv1.ProbeSpec{
+				InitialDelaySeconds: 30,
+				PeriodSeconds:       20,
+				TimeoutSeconds:      2,
+				FailureThreshold:    1,
+			},
+			want: v1.Probe{
+				Handler:             handler,
+				InitialDelaySeconds: 30,
+				PeriodSeconds:       20,
+				TimeoutSeconds:      2,
+				FailureThreshold:    1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newProbe(handler, defaults, tt.tuning)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("newProbe() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	if defaults.PeriodSeconds != 10 || defaults.FailureThreshold != 3 {
+		t.Errorf("newProbe() modified the defaults: %+v", defaults)
+	}
+}
+
+func TestWordpressHTTPGetPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		probePath string
+		want      string
+	}{
+		{name: "default", probePath: "", want: defaultWordpressProbePath},
+		{name: "custom", probePath: "/healthz", want: "/healthz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wordpress := &wordpressv1.Wordpress{}
+			wordpress.Spec.Frontend.ProbePath = tt.probePath
+			if got := wordpressHTTPGet(wordpress).HTTPGet.Path; got != tt.want {
+				t.Errorf("wordpressHTTPGet() path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -13,8 +13,16 @@ import (
 
 func createPVC(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress, kind string) (ctrl.Result, error) {
 
-	if objectNotFound(r, ctx, kind+"-pv-claim", &v1.PersistentVolumeClaim{}, *wordpress) {
+	if objectNotFound(r, ctx, childName(wordpress, kind+"-pv-claim"), &v1.PersistentVolumeClaim{}, *wordpress) {
 		pvc := newPVC(wordpress, kind)
+		if cloningVolumes(wordpress) {
+			claim, err := cloneSourceClaim(r, ctx, wordpress, kind)
+			if err != nil {
+				log.Error(err, "Failed to get the claim of the clone source "+kind)
+				return ctrl.Result{}, err
+			}
+			pvc = newPVCFromClaim(wordpress, kind, claim)
+		}
 
 		if err := controllerutil.SetControllerReference(wordpress, pvc, r.Scheme); err != nil {
 			return ctrl.Result{}, err
@@ -34,7 +42,7 @@ func createPVC(r *WordpressReconciler, ctx context.Context, log logr.Logger, req
 func newPVC(wordpress *wordpressv1.Wordpress, kind string) *v1.PersistentVolumeClaim {
 	return &v1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      kind + "-pv-claim",
+			Name:      childName(wordpress, kind+"-pv-claim"),
 			Namespace: wordpress.Namespace,
 			Labels: map[string]string{
 				"app": "wordpress",
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds the whole exchange with Redis
+const redisTimeout = 5 * time.Second
+
+// redisInfo authenticates against the Redis server at addr and returns the
+// fields of an INFO section. Only the handful of RESP replies the two
+// commands produce are understood.
+func redisInfo(ctx context.Context, addr, password, section string) (map[string]string, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+	return readRedisInfo(conn, password, section)
+}
+
+// readRedisInfo runs the AUTH and INFO commands of redisInfo over conn.
+func readRedisInfo(conn io.ReadWriter, password, section string) (map[string]string, error) {
+	reader := bufio.NewReader(conn)
+	if _, err := redisCommand(conn, reader, "AUTH", password); err != nil {
+		return nil, err
+	}
+	reply, err := redisCommand(conn, reader, "INFO", section)
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(reply, "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			info[line[:i]] = line[i+1:]
+		}
+	}
+	return info, nil
+}
+
+// redisCommand sends a command and reads its simple string or bulk string
+// reply.
+func redisCommand(w io.Writer, reader *bufio.Reader, args ...string) (string, error) {
+	request := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		request += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(w, request); err != nil {
+		return "", err
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply to %s", args[0])
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("%s: %s", args[0], line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return "", fmt.Errorf("invalid reply to %s: %q", args[0], line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return "", err
+		}
+		return string(data[:size]), nil
+	}
+	return "", fmt.Errorf("unexpected reply to %s: %q", args[0], line)
+}
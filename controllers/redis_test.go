@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the replies, in order, on one end of a pipe, reading the
+// request each of them answers first. It returns the client end and a
+// channel receiving the requests once the server is done.
+func fakeRedis(t *testing.T, requests, replies []string) (net.Conn, <-chan []string) {
+	client, server := net.Pipe()
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []string, 1)
+	go func() {
+		defer server.Close()
+		got := []string{}
+		for i, reply := range replies {
+			request := make([]byte, len(requests[i]))
+			if _, err := io.ReadFull(server, request); err != nil {
+				break
+			}
+			got = append(got, string(request))
+			if _, err := io.WriteString(server, reply); err != nil {
+				break
+			}
+		}
+		received <- got
+	}()
+	return client, received
+}
+
+func TestRedisCommand(t *testing.T) {
+	const echo = "*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n"
+
+	tests := []struct {
+		name    string
+		reply   string
+		want    string
+		wantErr string
+	}{
+		{name: "simple string", reply: "+OK\r\n", want: "OK"},
+		{name: "error", reply: "-ERR invalid password\r\n", wantErr: "ECHO: ERR invalid password"},
+		{name: "bulk string", reply: "$5\r\nhello\r\n", want: "hello"},
+		{name: "bulk string with line breaks", reply: "$12\r\n# Stats\r\na:1\r\n\r\n", want: "# Stats\r\na:1"},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", want: ""},
+		{name: "null bulk string", reply: "$-1\r\n", wantErr: `invalid reply to ECHO: "$-1"`},
+		{name: "truncated bulk string", reply: "$10\r\nhel", wantErr: "unexpected EOF"},
+		{name: "truncated line", reply: "+O", wantErr: "EOF"},
+		{name: "empty line", reply: "\r\n", wantErr: "empty reply to ECHO"},
+		{name: "unsupported reply", reply: ":1\r\n", wantErr: `unexpected reply to ECHO: ":1"`},
+		{name: "no reply", reply: "", wantErr: "EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, received := fakeRedis(t, []string{echo}, []string{tt.reply})
+			defer conn.Close()
+
+			got, err := redisCommand(conn, bufio.NewReader(conn), "ECHO", "hello")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("redisCommand() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("redisCommand() error = %v", err)
+			} else if got != tt.want {
+				t.Errorf("redisCommand() = %q, want %q", got, tt.want)
+			}
+
+			if requests := <-received; !reflect.DeepEqual(requests, []string{echo}) {
+				t.Errorf("server received %q, want %q", requests, echo)
+			}
+		})
+	}
+}
+
+func TestReadRedisInfo(t *testing.T) {
+	auth := "*2\r\n$4\r\nAUTH\r\n$6\r\nsecret\r\n"
+	info := "*2\r\n$4\r\nINFO\r\n$5\r\nstats\r\n"
+	stats := "# Stats\r\nkeyspace_hits:93\r\nkeyspace_misses:7\r\nexpired_keys:0\r\n"
+
+	tests := []struct {
+		name    string
+		replies []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "stats",
+			replies: []string{"+OK\r\n", fmt.Sprintf("$%d\r\n%s\r\n", len(stats), stats)},
+			want:    map[string]string{"keyspace_hits": "93", "keyspace_misses": "7", "expired_keys": "0"},
+		},
+		{
+			name:    "wrong password",
+			replies: []string{"-WRONGPASS invalid username-password pair\r\n"},
+			wantErr: true,
+		},
+		{
+			name:    "truncated info",
+			replies: []string{"+OK\r\n", fmt.Sprintf("$%d\r\n%s", len(stats), stats[:10])},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _ := fakeRedis(t, []string{auth, info}, tt.replies)
+			defer conn.Close()
+
+			got, err := readRedisInfo(conn, "secret", "stats")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRedisInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readRedisInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
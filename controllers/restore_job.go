@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const (
+	restoreMountPath = "/restore"
+	workMountPath    = "/work"
+
+	wpCLIImage = "wordpress:cli-2.4.0"
+
+	// wwwDataUID is the user Apache runs as in the wordpress image
+	wwwDataUID = int64(33)
+)
+
+// fetchScript downloads the artifacts of an S3 source into ARTIFACT_DIR
+const fetchScript = `set -euo pipefail
+if [ -n "${S3_FORCE_PATH_STYLE:-}" ]; then
+  aws configure set default.s3.addressing_style path
+fi
+aws s3 cp --recursive --endpoint-url "$S3_ENDPOINT" "$S3_URL" "$ARTIFACT_DIR"
+`
+
+// restoreScript verifies the artifacts, imports the database dump and
+// replaces wp-content. With STOP_DATETIME set the database is rolled forward
+// by replaying the binlogs of the wordpress database from the coordinates
+// recorded in the dump, after checking that the shipped binlogs reach that
+// far. Encrypted artifacts are decrypted on the fly, and encrypted binlogs
+// into the work directory. The site URL before and after the import is left
+// in the work directory for the search-replace container.
+const restoreScript = `set -euo pipefail
+` + cryptFunctions + `
+cd "$ARTIFACT_DIR"
+if [ -n "${EXPECTED_CHECKSUM:-}" ]; then
+  echo "$EXPECTED_CHECKSUM  SHA256SUMS" | sha256sum -c -
+fi
+sha256sum -c SHA256SUMS
+
+siteurl() {
+  mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" -N -B \
+    -e "SELECT option_value FROM wordpress.wp_options WHERE option_name = 'siteurl'" 2>/dev/null || true
+}
+
+current=$(siteurl)
+unseal database.sql.gz | gunzip | mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD"
+
+if [ -n "${STOP_DATETIME:-}" ]; then
+  header=$(unseal database.sql.gz | gunzip -c | head -n 50 || true)
+  uuid=$(sed -n 's/^-- server_uuid: //p' <<< "$header")
+  file=$(sed -n "s/.*MASTER_LOG_FILE='\([^']*\)'.*/\1/p" <<< "$header")
+  position=$(sed -n 's/.*MASTER_LOG_POS=\([0-9]*\).*/\1/p' <<< "$header")
+  if [ -z "$uuid" ] || [ -z "$file" ] || [ -z "$position" ]; then
+    echo "the backup was taken without binary logging and cannot be rolled forward" >&2
+    exit 1
+  fi
+  if [ ! -d "$BINLOG_DIR/$uuid" ]; then
+    echo "no binlogs shipped for server $uuid" >&2
+    exit 1
+  fi
+
+  cd "$BINLOG_DIR/$uuid"
+  logs=()
+  for log in $(ls | grep -E '^[^.]+\.[0-9]+$' | sort); do
+    [[ "$log" < "$file" ]] || logs+=("$log")
+  done
+  if [ "${logs[0]:-}" != "$file" ]; then
+    echo "binlog $file has not been shipped" >&2
+    exit 1
+  fi
+
+  if [ -n "${CRYPT:-}" ]; then
+    mkdir -p "$WORK_DIR/binlogs"
+    for log in "${logs[@]}"; do
+      if [ "$(head -c 8 "$log")" = WPCRYPT1 ]; then
+        "$CRYPT" crypt decrypt --keys "$CRYPT_KEYS" < "$log" > "$WORK_DIR/binlogs/$log"
+      else
+        cp "$log" "$WORK_DIR/binlogs/$log"
+      fi
+    done
+    cd "$WORK_DIR/binlogs"
+  fi
+
+  last=$(mysqlbinlog "${logs[-1]}" | grep -oE '^#[0-9]{6} +[0-9]{1,2}:[0-9]{2}:[0-9]{2}' | tail -n 1)
+  last=$(date -u -d "20${last:1:2}-${last:3:2}-${last:5:2} ${last:7}" +%s)
+  if [ "$last" -lt "$(date -u -d "$STOP_DATETIME" +%s)" ]; then
+    echo "the shipped binlogs end at $(date -u -d "@$last" '+%F %T'), before $STOP_DATETIME" >&2
+    exit 1
+  fi
+
+  mysqlbinlog --database=wordpress --start-position="$position" --stop-datetime="$STOP_DATETIME" "${logs[@]}" |
+    mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD"
+  cd "$ARTIFACT_DIR"
+fi
+restored=$(siteurl)
+
+rm -rf /var/www/html/wp-content
+unseal wp-content.tar.gz | tar -C /var/www/html -xz
+chown -R 33:33 /var/www/html/wp-content
+
+printf '%s' "$restored" > "$WORK_DIR/old-url"
+printf '%s' "${SITE_URL:-$current}" > "$WORK_DIR/new-url"
+`
+
+// searchReplaceScript rewrites the restored site URL, including inside
+// serialized options, when it differs from the one the site is served at.
+const searchReplaceScript = `set -eu
+old=$(cat "$WORK_DIR/old-url")
+new=$(cat "$WORK_DIR/new-url")
+if [ -n "$old" ] && [ -n "$new" ] && [ "$old" != "$new" ]; then
+  wp search-replace "$old" "$new" --all-tables --skip-columns=guid
+fi
+`
+
+func restoreJobName(restore *wordpressv1.WordpressRestore) string {
+	return restore.Name + "-restore"
+}
+
+// newRestoreJob returns the Job restoring the artifacts of source into the
+// instance. It runs while the frontend is scaled down, so wp-pv-claim is free
+// to be mounted read-write.
+func newRestoreJob(restore *wordpressv1.WordpressRestore, wordpress *wordpressv1.Wordpress, source *restoreSource, operatorImage string) *batchv1.Job {
+	target := source.target
+	dir := source.dir
+	backoffLimit := int32(0)
+	runAsUser := wwwDataUID
+
+	initContainers := []v1.Container{}
+	volumes := []v1.Volume{
+		{
+			Name: "wordpress-persistent-storage",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: childName(wordpress, "wp-pv-claim"),
+				},
+			},
+		},
+		{
+			Name: "work",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name:         "restore",
+			VolumeSource: restoreSourceVolume(target),
+		},
+	}
+
+	artifactDir := path.Join(restoreMountPath, dir)
+	if target.S3 != nil {
+		artifactDir = restoreMountPath
+		initContainers = append(initContainers, v1.Container{
+			Image:   awsCLIImage,
+			Name:    "fetch",
+			Command: []string{"bash", "-c", fetchScript},
+			Env:     append(s3Env(target.S3, targetLocation(target, dir)), v1.EnvVar{Name: "ARTIFACT_DIR", Value: artifactDir}),
+			EnvFrom: s3EnvFrom(target.S3),
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      "restore",
+					MountPath: restoreMountPath,
+				},
+			},
+		})
+	}
+
+	restoreContainer := v1.Container{
+		Image:   mysqlImage,
+		Name:    "restore",
+		Command: []string{"bash", "-c", restoreScript},
+		Env: []v1.EnvVar{
+			{Name: "ARTIFACT_DIR", Value: artifactDir},
+			{Name: "WORK_DIR", Value: workMountPath},
+			{Name: "EXPECTED_CHECKSUM", Value: source.checksum},
+			{Name: "SITE_URL", Value: restore.Spec.SiteURL},
+			mysqlHostEnv(wordpress),
+			mysqlRootPasswordEnv(wordpress),
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "restore",
+				MountPath: restoreMountPath,
+				ReadOnly:  target.S3 == nil,
+			},
+			{
+				Name:      "wordpress-persistent-storage",
+				MountPath: "/var/www/html",
+			},
+			{
+				Name:      "work",
+				MountPath: workMountPath,
+			},
+		},
+	}
+
+	if binlogs := source.binlogs; binlogs != nil {
+		binlogDir := path.Join(binlogMountPath, source.binlogDir)
+		switch {
+		case binlogs.S3 != nil:
+			binlogDir = binlogMountPath
+			initContainers = append(initContainers, v1.Container{
+				Image:   awsCLIImage,
+				Name:    "fetch-binlogs",
+				Command: []string{"bash", "-c", fetchScript},
+				Env:     append(s3Env(binlogs.S3, targetLocation(*binlogs, source.binlogDir)), v1.EnvVar{Name: "ARTIFACT_DIR", Value: binlogDir}),
+				EnvFrom: s3EnvFrom(binlogs.S3),
+				VolumeMounts: []v1.VolumeMount{
+					{
+						Name:      "binlogs",
+						MountPath: binlogMountPath,
+					},
+				},
+			})
+			volumes = append(volumes, v1.Volume{
+				Name: "binlogs",
+				VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{},
+				},
+			})
+			restoreContainer.VolumeMounts = append(restoreContainer.VolumeMounts, v1.VolumeMount{
+				Name:      "binlogs",
+				MountPath: binlogMountPath,
+			})
+		case target.PersistentVolumeClaim != nil && target.PersistentVolumeClaim.ClaimName == binlogs.PersistentVolumeClaim.ClaimName:
+			binlogDir = path.Join(restoreMountPath, source.binlogDir)
+		default:
+			volumes = append(volumes, v1.Volume{
+				Name: "binlogs",
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+						ClaimName: binlogs.PersistentVolumeClaim.ClaimName,
+						ReadOnly:  true,
+					},
+				},
+			})
+			restoreContainer.VolumeMounts = append(restoreContainer.VolumeMounts, v1.VolumeMount{
+				Name:      "binlogs",
+				MountPath: binlogMountPath,
+				ReadOnly:  true,
+			})
+		}
+
+		restoreContainer.Env = append(restoreContainer.Env,
+			v1.EnvVar{Name: "BINLOG_DIR", Value: binlogDir},
+			v1.EnvVar{Name: "STOP_DATETIME", Value: restore.Spec.PointInTime.UTC().Format("2006-01-02 15:04:05")},
+			v1.EnvVar{Name: "TZ", Value: "UTC"},
+		)
+	}
+	if source.encrypted {
+		restoreContainer.Env = append(restoreContainer.Env, v1.EnvVar{Name: "ARTIFACTS_ENCRYPTED", Value: "true"})
+	}
+	initContainers = append(initContainers, restoreContainer)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restoreJobName(restore),
+			Namespace: restore.Namespace,
+			Labels: map[string]string{
+				"app":                           "wordpress",
+				"wordpress.example.com/restore": restore.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                           "wordpress",
+						"wordpress.example.com/restore": restore.Name,
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyNever,
+					InitContainers: initContainers,
+					Containers: []v1.Container{
+						{
+							Image:   wpCLIImage,
+							Name:    "search-replace",
+							Command: []string{"sh", "-c", searchReplaceScript},
+							Env: []v1.EnvVar{
+								{Name: "WORK_DIR", Value: workMountPath},
+							},
+							SecurityContext: &v1.SecurityContext{
+								RunAsUser: &runAsUser,
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "wordpress-persistent-storage",
+									MountPath: "/var/www/html",
+								},
+								{
+									Name:      "work",
+									MountPath: workMountPath,
+								},
+							},
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+
+	if len(source.keys) > 0 {
+		addCrypt(&job.Spec.Template.Spec, operatorImage, source.keys, "", "restore")
+	}
+	return job
+}
+
+// restoreSourceVolume mounts a PVC source directly and stages S3 downloads
+// in an emptyDir.
+func restoreSourceVolume(target wordpressv1.BackupTarget) v1.VolumeSource {
+	if target.S3 != nil {
+		return v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		}
+	}
+	return v1.VolumeSource{
+		PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+			ClaimName: target.PersistentVolumeClaim.ClaimName,
+			ReadOnly:  true,
+		},
+	}
+}
+
+// mysqlHostEnv points the mysql client of a Job at the database of the
+// instance.
+func mysqlHostEnv(wordpress *wordpressv1.Wordpress) v1.EnvVar {
+	return v1.EnvVar{Name: "MYSQL_HOST", Value: mysqlHost(wordpress)}
+}
+
+func mysqlRootPasswordEnv(wordpress *wordpressv1.Wordpress) v1.EnvVar {
+	return v1.EnvVar{
+		Name: "MYSQL_ROOT_PASSWORD",
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{
+					Name: childName(wordpress, "mysql-pass"),
+				},
+				Key: "password",
+			},
+		},
+	}
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"net/url"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// Keys of the S3 credentials Secret. They match the environment variables of
+// the AWS CLI so the Secret can be loaded into the upload container as is.
+const (
+	s3AccessKeyIDKey     = "AWS_ACCESS_KEY_ID"
+	s3SecretAccessKeyKey = "AWS_SECRET_ACCESS_KEY"
+)
+
+const defaultS3Region = "us-east-1"
+
+// s3Client is the subset of the S3 API the operator needs to list and prune
+// backup artifacts. Requests are signed with AWS Signature Version 4, which
+// MinIO and the other S3-compatible stores accept as well.
+type s3Client struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	pathStyle       bool
+	accessKeyID     string
+	secretAccessKey string
+	http            *http.Client
+}
+
+// newS3Client returns a client for the target, reading the credentials from
+// the referenced Secret in namespace.
+func newS3Client(c client.Client, ctx context.Context, namespace string, target *wordpressv1.S3BackupTarget) (*s3Client, error) {
+	secret := &v1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: target.CredentialsSecretRef.Name, Namespace: namespace}, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := url.Parse(s3Endpoint(target))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %v", target.Endpoint, err)
+	}
+
+	return &s3Client{
+		endpoint:        endpoint,
+		region:          s3Region(target),
+		bucket:          target.Bucket,
+		pathStyle:       target.ForcePathStyle,
+		accessKeyID:     string(secret.Data[s3AccessKeyIDKey]),
+		secretAccessKey: string(secret.Data[s3SecretAccessKeyKey]),
+		http:            &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func s3Endpoint(target *wordpressv1.S3BackupTarget) string {
+	if target.Endpoint != "" {
+		return target.Endpoint
+	}
+	return fmt.Sprintf("https://s3.%s.amazonaws.com", s3Region(target))
+}
+
+func s3Region(target *wordpressv1.S3BackupTarget) string {
+	if target.Region != "" {
+		return target.Region
+	}
+	return defaultS3Region
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns the keys of all objects under prefix.
+func (s *s3Client) list(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, err := s.do(ctx, http.MethodGet, "", query)
+		if err != nil {
+			return nil, err
+		}
+		result := listBucketResult{}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// deletePrefix deletes all objects under prefix.
+func (s *s3Client) deletePrefix(ctx context.Context, prefix string) error {
+	keys, err := s.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := s.do(ctx, http.MethodDelete, key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3Client) do(ctx context.Context, method, key string, query url.Values) ([]byte, error) {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3EscapeQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds an AWS Signature Version 4 Authorization header to a request
+// without a body.
+func (s *s3Client) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + emptyPayloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape percent-encodes everything but the RFC 3986 unreserved characters,
+// as required for canonical requests.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i := range segments {
+		segments[i] = s3Escape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3EscapeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			params = append(params, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(params, "&")
+}
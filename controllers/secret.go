@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"github.com/go-logr/logr"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,9 +13,18 @@ import (
 )
 
 func createSecret(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
-	if objectNotFound(r, ctx, "mysql-pass", &v1.Secret{}, *wordpress) {
+	if objectNotFound(r, ctx, childName(wordpress, "mysql-pass"), &v1.Secret{}, *wordpress) {
 
 		secret := newSecret(wordpress)
+		if cloningVolumes(wordpress) {
+			// The cloned database carries the root password of the source.
+			source, err := cloneSourceSecret(r, ctx, wordpress)
+			if err != nil {
+				log.Error(err, "Failed to get the Secret of the clone source")
+				return ctrl.Result{}, err
+			}
+			secret.Data = source.Data
+		}
 
 		if err := controllerutil.SetControllerReference(wordpress, secret, r.Scheme); err != nil {
 			return ctrl.Result{}, err
@@ -32,7 +43,7 @@ func createSecret(r *WordpressReconciler, ctx context.Context, log logr.Logger,
 func newSecret(wordpress *wordpressv1.Wordpress) *v1.Secret {
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "mysql-pass",
+			Name:      childName(wordpress, "mysql-pass"),
 			Namespace: wordpress.Namespace,
 		},
 		Type: "Opaque",
@@ -41,3 +52,12 @@ func newSecret(wordpress *wordpressv1.Wordpress) *v1.Secret {
 		},
 	}
 }
+
+// generatePassword returns a random password of 32 URL-safe characters.
+func generatePassword() (string, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
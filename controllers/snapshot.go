@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// volumeSnapshotGVK is the CSI snapshot API. The operator does not depend on
+// the external-snapshotter client and handles snapshots as unstructured
+// objects.
+var volumeSnapshotGVK = schema.GroupVersionKind{
+	Group:   "snapshot.storage.k8s.io",
+	Version: "v1",
+	Kind:    "VolumeSnapshot",
+}
+
+// lockTimeoutSeconds bounds how long the lock helper holds the global read
+// lock, in case the operator goes away before releasing it
+const lockTimeoutSeconds = 120
+
+// lockScript holds FLUSH TABLES WITH READ LOCK on a session that stays open
+// until the pod is deleted or the timeout expires. /tmp/locked makes the pod
+// ready once the lock is held.
+const lockScript = `set -euo pipefail
+mysql -h "$MYSQL_HOST" -uroot -p"$MYSQL_ROOT_PASSWORD" <<SQL
+FLUSH TABLES WITH READ LOCK;
+system touch /tmp/locked
+SELECT SLEEP($LOCK_TIMEOUT_SECONDS);
+SQL
+`
+
+func lockJobName(backup *wordpressv1.WordpressBackup) string {
+	return backup.Name + "-lock"
+}
+
+// newLockJob returns the Job quiescing MySQL while the snapshots of a backup
+// are taken.
+func newLockJob(backup *wordpressv1.WordpressBackup, wordpress *wordpressv1.Wordpress) *batchv1.Job {
+	backoffLimit := int32(0)
+	activeDeadline := int64(lockTimeoutSeconds + 60)
+	gracePeriod := int64(1)
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      lockJobName(backup),
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				"app":                          "wordpress",
+				"wordpress.example.com/backup": backup.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadline,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                          "wordpress",
+						"wordpress.example.com/backup": backup.Name,
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					// The lock is released when the session closes, so the
+					// pod is killed rather than left to wind down.
+					TerminationGracePeriodSeconds: &gracePeriod,
+					Containers: []v1.Container{
+						{
+							Image:   mysqlImage,
+							Name:    "lock",
+							Command: []string{"bash", "-c", lockScript},
+							Env: []v1.EnvVar{
+								{Name: "LOCK_TIMEOUT_SECONDS", Value: fmt.Sprint(lockTimeoutSeconds)},
+								mysqlHostEnv(wordpress),
+								mysqlRootPasswordEnv(wordpress),
+							},
+							ReadinessProbe: &v1.Probe{
+								Handler: v1.Handler{
+									Exec: &v1.ExecAction{Command: []string{"test", "-f", "/tmp/locked"}},
+								},
+								PeriodSeconds: 1,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// jobPodReady reports whether a pod of job is ready.
+func jobPodReady(c client.Client, ctx context.Context, job *batchv1.Job) (bool, error) {
+	pods := &v1.PodList{}
+	err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods.Items {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// newVolumeSnapshot returns a snapshot of the claim of a Wordpress instance.
+func newVolumeSnapshot(backup *wordpressv1.WordpressBackup, name, claimName string) *unstructured.Unstructured {
+	return newClaimSnapshot(backup.Namespace, name, claimName, backup.Spec.VolumeSnapshotClassName, map[string]string{
+		"app":                          "wordpress",
+		"wordpress.example.com/backup": backup.Name,
+	})
+}
+
+// newClaimSnapshot returns a snapshot of a claim with the class, or the
+// default class when empty.
+func newClaimSnapshot(namespace, name, claimName, className string, labels map[string]string) *unstructured.Unstructured {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	snapshot.SetName(name)
+	snapshot.SetNamespace(namespace)
+	snapshot.SetLabels(labels)
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": claimName,
+		},
+	}
+	if className != "" {
+		spec["volumeSnapshotClassName"] = className
+	}
+	snapshot.Object["spec"] = spec
+	return snapshot
+}
+
+// snapshotState is the part of the status of a VolumeSnapshot the operator
+// acts on
+type snapshotState struct {
+	// taken is set once the point-in-time image has been cut, after which
+	// the source volume may change again
+	taken bool
+	ready bool
+	// restoreSize is the minimum size of a claim provisioned from the
+	// snapshot
+	restoreSize *resource.Quantity
+	err         string
+}
+
+func getSnapshotState(c client.Client, ctx context.Context, namespace, name string) (*snapshotState, error) {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &snapshotState{}
+	creationTime, _, _ := unstructured.NestedString(snapshot.Object, "status", "creationTime")
+	state.taken = creationTime != ""
+	state.ready, _, _ = unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	state.err, _, _ = unstructured.NestedString(snapshot.Object, "status", "error", "message")
+	if size, found, _ := unstructured.NestedString(snapshot.Object, "status", "restoreSize"); found {
+		if quantity, err := resource.ParseQuantity(size); err == nil {
+			state.restoreSize = &quantity
+		}
+	}
+	return state, nil
+}
+
+func deleteVolumeSnapshot(c client.Client, ctx context.Context, namespace, name string) error {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	snapshot.SetName(name)
+	snapshot.SetNamespace(namespace)
+	return client.IgnoreNotFound(c.Delete(ctx, snapshot))
+}
+
+func volumeSnapshotNames(backup *wordpressv1.WordpressBackup) *wordpressv1.BackupSnapshots {
+	return &wordpressv1.BackupSnapshots{
+		Database: backup.Name + "-mysql",
+		Content:  backup.Name + "-wp",
+	}
+}
+
+// newPVCFromSnapshot returns the claim of kind provisioned from a snapshot,
+// grown to the restore size of the snapshot when that is larger.
+func newPVCFromSnapshot(wordpress *wordpressv1.Wordpress, kind string, snapshot string, restoreSize *resource.Quantity) *v1.PersistentVolumeClaim {
+	pvc := newPVC(wordpress, kind)
+	apiGroup := volumeSnapshotGVK.Group
+	pvc.Spec.DataSource = &v1.TypedLocalObjectReference{
+		APIGroup: &apiGroup,
+		Kind:     volumeSnapshotGVK.Kind,
+		Name:     snapshot,
+	}
+	if restoreSize != nil && restoreSize.Cmp(pvc.Spec.Resources.Requests[v1.ResourceStorage]) > 0 {
+		pvc.Spec.Resources.Requests[v1.ResourceStorage] = *restoreSize
+	}
+	return pvc
+}
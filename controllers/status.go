@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// readinessPollInterval is how often a stage waiting on a rollout is rechecked
+const readinessPollInterval = 10 * time.Second
+
+// setReadyCondition records the Ready condition, skipping the status update
+// when nothing changed.
+func setReadyCondition(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress, status metav1.ConditionStatus, reason, message string) error {
+	return setCondition(r, ctx, wordpress, wordpressv1.ConditionReady, status, reason, message)
+}
+
+// setCondition records a condition, skipping the status update when nothing
+// changed.
+func setCondition(r *WordpressReconciler, ctx context.Context, wordpress *wordpressv1.Wordpress, conditionType string, status metav1.ConditionStatus, reason, message string) error {
+	current := meta.FindStatusCondition(wordpress.Status.Conditions, conditionType)
+	if current != nil && current.Status == status && current.Reason == reason &&
+		current.Message == message && current.ObservedGeneration == wordpress.Generation {
+		return nil
+	}
+
+	meta.SetStatusCondition(&wordpress.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: wordpress.Generation,
+	})
+	return r.Status().Update(ctx, wordpress)
+}
+
+// setNotReady records that the stage named by reason failed with err and
+// returns err so the request is retried.
+func setNotReady(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, reason string, err error) error {
+	if statusErr := setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse, reason, err.Error()); statusErr != nil {
+		log.Error(statusErr, "Failed to update Wordpress status")
+	}
+	return err
+}
@@ -2,8 +2,16 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	wordpressv1 "wordpress-operator/api/v1"
 )
@@ -17,3 +25,102 @@ func objectNotFound(r *WordpressReconciler, ctx context.Context, key string, obj
 	err := r.Get(ctx, toFind, obj)
 	return err != nil && errors.IsNotFound(err)
 }
+
+// podTemplateHashAnnotation records the hash of the pod template the
+// operator rendered, so that changes to the spec can be detected without
+// comparing against the defaults filled in by the API server.
+const podTemplateHashAnnotation = "wordpress.example.com/pod-template-hash"
+
+// setPodTemplateHash annotates the pod template of deployment with its hash.
+func setPodTemplateHash(deployment *appsv1.Deployment) *appsv1.Deployment {
+	delete(deployment.Spec.Template.Annotations, podTemplateHashAnnotation)
+	data, _ := json.Marshal(deployment.Spec.Template)
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[podTemplateHashAnnotation] = fmt.Sprintf("%x", sha256.Sum256(data))
+	return deployment
+}
+
+// updateDeploymentTemplate replaces the pod template of the named Deployment
+// with the desired one when their hashes differ, which triggers a rollout.
+func updateDeploymentTemplate(r *WordpressReconciler, ctx context.Context, log logr.Logger, wordpress *wordpressv1.Wordpress, desired *appsv1.Deployment) (ctrl.Result, error) {
+	found := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: wordpress.Namespace}, found)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if found.Spec.Template.Annotations[podTemplateHashAnnotation] == desired.Spec.Template.Annotations[podTemplateHashAnnotation] {
+		return ctrl.Result{}, nil
+	}
+
+	found.Spec.Template = desired.Spec.Template
+	err = r.Update(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to update Deployment", "deployment.name", found.Name)
+		return ctrl.Result{}, err
+	}
+	log.Info("Updated Deployment object", "deployment.name", found.Name)
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// deploymentReady reports whether the named Deployment has rolled out and all
+// of its replicas are ready.
+func deploymentReady(c client.Client, ctx context.Context, name string, wordpress *wordpressv1.Wordpress) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: wordpress.Namespace}, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.ReadyReplicas >= replicas, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// referencedWordpress returns the named instance. A non-empty pending message
+// means the instance cannot be worked on yet.
+func referencedWordpress(c client.Client, ctx context.Context, namespace, name string) (*wordpressv1.Wordpress, string, error) {
+	wordpress := &wordpressv1.Wordpress{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, wordpress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Wordpress %s not found", name), nil
+		}
+		return nil, "", err
+	}
+	if !wordpress.DeletionTimestamp.IsZero() {
+		return wordpress, fmt.Sprintf("Wordpress %s is being deleted", wordpress.Name), nil
+	}
+	if !meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionReady) {
+		return wordpress, fmt.Sprintf("Waiting for Wordpress %s to become ready", wordpress.Name), nil
+	}
+	return wordpress, "", nil
+}
+
+// claimedBefore reports whether a was created before b, breaking ties by
+// name, so that the oldest of several resources claiming the same thing wins.
+func claimedBefore(a, b metav1.Object) bool {
+	at, bt := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+	if at.Equal(&bt) {
+		return a.GetName() < b.GetName()
+	}
+	return at.Before(&bt)
+}
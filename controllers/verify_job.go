@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+const scratchMySQLPassword = "verify"
+
+// verifyScript checks the artifacts against the recorded checksum, imports
+// the dump into a MySQL server started inside the container and runs
+// integrity checks on the result. The outcome, or the check that failed, is
+// reported through the termination message.
+const verifyScript = `set -Eeuo pipefail
+` + cryptFunctions + `
+fail() {
+  printf '%s' "$1" > /dev/termination-log
+  echo "$1" >&2
+  exit 1
+}
+trap 'fail "verification failed at line $LINENO"' ERR
+cd "$ARTIFACT_DIR"
+
+if [ -n "${EXPECTED_CHECKSUM:-}" ]; then
+  echo "$EXPECTED_CHECKSUM  SHA256SUMS" | sha256sum -c - || fail "SHA256SUMS does not match the recorded checksum"
+fi
+sha256sum -c SHA256SUMS || fail "an artifact does not match SHA256SUMS"
+unseal wp-content.tar.gz | tar -tz > /dev/null || fail "wp-content.tar.gz cannot be decrypted or is not a valid archive"
+
+MYSQL_ROOT_PASSWORD=` + scratchMySQLPassword + ` docker-entrypoint.sh mysqld > /tmp/mysqld.log 2>&1 &
+mysql_scratch() {
+  mysql -h 127.0.0.1 -uroot -p` + scratchMySQLPassword + ` "$@"
+}
+for i in $(seq 150); do
+  mysqladmin -h 127.0.0.1 -uroot -p` + scratchMySQLPassword + ` ping --silent 2>/dev/null && break
+  sleep 2
+done
+mysqladmin -h 127.0.0.1 -uroot -p` + scratchMySQLPassword + ` ping --silent 2>/dev/null || fail "the scratch MySQL server did not start"
+
+unseal database.sql.gz | gunzip | mysql_scratch || fail "the database dump could not be decrypted or imported"
+
+tables=$(mysql_scratch -N -B -e "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = 'wordpress'")
+[ "$tables" -gt 0 ] || fail "the wordpress database has no tables"
+mysqlcheck -h 127.0.0.1 -uroot -p` + scratchMySQLPassword + ` --databases wordpress | awk '$NF != "OK" { print; bad = 1 } END { exit bad }' ||
+  fail "mysqlcheck reported damaged tables"
+
+options=$(mysql_scratch -N -B -e "SELECT table_name FROM information_schema.tables
+  WHERE table_schema = 'wordpress' AND table_name LIKE '%\_options' ORDER BY table_name LIMIT 1")
+[ -n "$options" ] || fail "the wordpress database has no options table"
+# The site URL is hex-encoded so that any character it holds keeps the
+# termination message valid JSON; MySQL 5.6 has no JSON functions.
+siteurl=$(mysql_scratch -N -B -e "SELECT HEX(option_value) FROM wordpress.$options WHERE option_name = 'siteurl'")
+[ -n "$siteurl" ] || fail "siteurl is missing from $options"
+
+mysqladmin -h 127.0.0.1 -uroot -p` + scratchMySQLPassword + ` shutdown || true
+printf '{"tables":%d,"siteURLHex":"%s"}' "$tables" "$siteurl" > /dev/termination-log
+`
+
+// verifyResult is written by verifyScript to the termination message
+type verifyResult struct {
+	Tables     int    `json:"tables"`
+	SiteURLHex string `json:"siteURLHex"`
+
+	// SiteURL is decoded from SiteURLHex by parseVerifyResult
+	SiteURL string `json:"-"`
+}
+
+func verifyJobName(backup *wordpressv1.WordpressBackup) string {
+	return backup.Name + "-verify"
+}
+
+// verificationRequested reports whether the backup is to be verified.
+// Snapshot backups are never verified.
+func verificationRequested(backup *wordpressv1.WordpressBackup) bool {
+	return backup.Spec.Verify && backup.Spec.Method != wordpressv1.BackupMethodSnapshot
+}
+
+// newVerifyJob returns the Job test-restoring the artifacts of a completed
+// backup into a throwaway MySQL server. wordpress is nil once the instance
+// has been deleted.
+func newVerifyJob(backup *wordpressv1.WordpressBackup, wordpress *wordpressv1.Wordpress, operatorImage string) *batchv1.Job {
+	backoffLimit := int32(0)
+	activeDeadlineSeconds := int64(6 * 60 * 60)
+	target := backup.Spec.Target
+
+	initContainers := []v1.Container{}
+	artifactDir := path.Join(restoreMountPath, backupDir(backup))
+	if target.S3 != nil {
+		artifactDir = restoreMountPath
+		initContainers = append(initContainers, v1.Container{
+			Image:   awsCLIImage,
+			Name:    "fetch",
+			Command: []string{"bash", "-c", fetchScript},
+			Env:     append(s3Env(target.S3, backupLocation(backup)), v1.EnvVar{Name: "ARTIFACT_DIR", Value: artifactDir}),
+			EnvFrom: s3EnvFrom(target.S3),
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      "restore",
+					MountPath: restoreMountPath,
+				},
+			},
+		})
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      verifyJobName(backup),
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				"app":                          "wordpress",
+				"wordpress.example.com/backup": backup.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":                          "wordpress",
+						"wordpress.example.com/backup": backup.Name,
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy:  v1.RestartPolicyNever,
+					InitContainers: initContainers,
+					Containers: []v1.Container{
+						{
+							Image:   mysqlImage,
+							Name:    "verify",
+							Command: []string{"bash", "-c", verifyScript},
+							Env: []v1.EnvVar{
+								{Name: "ARTIFACT_DIR", Value: artifactDir},
+								{Name: "EXPECTED_CHECKSUM", Value: backup.Status.Checksum},
+							},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "restore",
+									MountPath: restoreMountPath,
+									ReadOnly:  target.S3 == nil,
+								},
+								{
+									Name:      "scratch",
+									MountPath: "/var/lib/mysql",
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name:         "restore",
+							VolumeSource: restoreSourceVolume(target),
+						},
+						{
+							Name: "scratch",
+							VolumeSource: v1.VolumeSource{
+								EmptyDir: &v1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// A claim target is ReadWriteOnce and is kept next to the frontend by the
+	// backup Jobs. Without the instance there is no frontend to follow.
+	if target.PersistentVolumeClaim != nil && wordpress != nil {
+		job.Spec.Template.Spec.Affinity = frontendNodeAffinity(wordpress)
+	}
+
+	if backup.Status.EncryptionKeyID != "" && backup.Spec.Encryption != nil {
+		addCrypt(&job.Spec.Template.Spec, operatorImage, []v1.LocalObjectReference{backup.Spec.Encryption.SecretRef}, "", "verify")
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, v1.EnvVar{Name: "ARTIFACTS_ENCRYPTED", Value: "true"})
+	}
+	return job
+}
+
+// jobFailureMessage returns the termination message of the first container
+// that exited with an error in one of the Job's pods, if any.
+func jobFailureMessage(c client.Client, ctx context.Context, job *batchv1.Job) (string, error) {
+	pods := &v1.PodList{}
+	err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			terminated := status.State.Terminated
+			if terminated != nil && terminated.ExitCode != 0 && terminated.Message != "" {
+				return terminated.Message, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func parseVerifyResult(message string) (verifyResult, error) {
+	result := verifyResult{}
+	err := json.Unmarshal([]byte(message), &result)
+	if err != nil {
+		return result, err
+	}
+	siteURL, err := hex.DecodeString(result.SiteURLHex)
+	if err != nil {
+		return result, fmt.Errorf("invalid siteURLHex: %w", err)
+	}
+	result.SiteURL = string(siteURL)
+	return result, nil
+}
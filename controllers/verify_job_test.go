@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"encoding/hex"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+func TestParseVerifyResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		siteURL string
+	}{
+		{name: "plain", siteURL: "https://example.com"},
+		{name: "quote", siteURL: `https://example.com/?q="a"`},
+		{name: "backslash", siteURL: `https://example.com/\`},
+		{name: "control characters", siteURL: "https://example.com/\n\r\t"},
+		{name: "unicode", siteURL: "https://exämple.com/ü"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The termination message as printed by verifyScript, with the
+			// value MySQL's HEX() returns.
+			message := fmt.Sprintf(`{"tables":%d,"siteURLHex":"%s"}`, 12, hex.EncodeToString([]byte(tt.siteURL)))
+			result, err := parseVerifyResult(message)
+			if err != nil {
+				t.Fatalf("parseVerifyResult(%q) error = %v", message, err)
+			}
+			if result.Tables != 12 || result.SiteURL != tt.siteURL {
+				t.Errorf("parseVerifyResult(%q) = %+v, want 12 tables of %q", message, result, tt.siteURL)
+			}
+		})
+	}
+
+	if _, err := parseVerifyResult(`{"tables":1,"siteURLHex":"zz"}`); err == nil {
+		t.Error("parseVerifyResult() accepted an invalid siteURLHex")
+	}
+}
+
+// TestNewVerifyJobAffinity checks that the verify Job only follows the
+// frontend of an instance that exists, for a claim target.
+func TestNewVerifyJobAffinity(t *testing.T) {
+	pvc := wordpressv1.BackupTarget{PersistentVolumeClaim: &wordpressv1.PVCBackupTarget{ClaimName: "backups"}}
+	s3 := wordpressv1.BackupTarget{S3: &wordpressv1.S3BackupTarget{
+		Bucket:               "backups",
+		CredentialsSecretRef: v1.LocalObjectReference{Name: "s3-credentials"},
+	}}
+	wordpress := &wordpressv1.Wordpress{ObjectMeta: metav1.ObjectMeta{Name: "mysite", Namespace: "default"}}
+
+	tests := []struct {
+		name      string
+		target    wordpressv1.BackupTarget
+		wordpress *wordpressv1.Wordpress
+		affinity  bool
+	}{
+		{name: "claim", target: pvc, wordpress: wordpress, affinity: true},
+		{name: "claim of a deleted instance", target: pvc},
+		{name: "s3", target: s3, wordpress: wordpress},
+		{name: "s3 of a deleted instance", target: s3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := &wordpressv1.WordpressBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "mysite-backup", Namespace: "default"},
+				Spec: wordpressv1.WordpressBackupSpec{
+					WordpressRef: "mysite",
+					Target:       tt.target,
+					Verify:       true,
+				},
+			}
+			job := newVerifyJob(backup, tt.wordpress, "operator")
+			if affinity := job.Spec.Template.Spec.Affinity != nil; affinity != tt.affinity {
+				t.Errorf("newVerifyJob() affinity = %v, want %v", job.Spec.Template.Spec.Affinity, tt.affinity)
+			}
+		})
+	}
+}
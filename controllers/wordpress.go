@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
@@ -11,6 +12,8 @@ import (
 	wordpressv1 "wordpress-operator/api/v1"
 )
 
+const wordpressImage = "wordpress:4.8-apache"
+
 func createWordPress(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
 
 	res, err := createWordpressService(r, ctx, log, req, wordpress)
@@ -23,6 +26,16 @@ func createWordPress(r *WordpressReconciler, ctx context.Context, log logr.Logge
 		return res, err
 	}
 
+	res, err = createPHPConfigMap(r, ctx, log, req, wordpress)
+	if err != nil {
+		return res, err
+	}
+
+	res, err = createCronConfigMap(r, ctx, log, req, wordpress)
+	if err != nil {
+		return res, err
+	}
+
 	res, err = createWordpressDeployment(r, ctx, log, req, wordpress)
 	if err != nil {
 		return res, err
@@ -32,7 +45,7 @@ func createWordPress(r *WordpressReconciler, ctx context.Context, log logr.Logge
 }
 
 func createWordpressService(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
-	if objectNotFound(r, ctx, "wordpress", &v1.Service{}, *wordpress) {
+	if objectNotFound(r, ctx, childName(wordpress, "wordpress"), &v1.Service{}, *wordpress) {
 		service := newWordpressService(wordpress)
 		if err := controllerutil.SetControllerReference(wordpress, service, r.Scheme); err != nil {
 			return ctrl.Result{}, err
@@ -52,7 +65,7 @@ func createWordpressService(r *WordpressReconciler, ctx context.Context, log log
 func newWordpressService(wordpress *wordpressv1.Wordpress) *v1.Service {
 	return &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "wordpress",
+			Name:      childName(wordpress, "wordpress"),
 			Namespace: wordpress.Namespace,
 			Labels: map[string]string{
 				"app": "wordpress",
@@ -64,17 +77,14 @@ func newWordpressService(wordpress *wordpressv1.Wordpress) *v1.Service {
 					Port: 80,
 				},
 			},
-			Selector: map[string]string{
-				"app":  "wordpress",
-				"tier": "frontend",
-			},
-			Type: v1.ServiceTypeLoadBalancer,
+			Selector: tierLabels(wordpress, "frontend"),
+			Type:     v1.ServiceTypeLoadBalancer,
 		},
 	}
 }
 
 func createWordpressDeployment(r *WordpressReconciler, ctx context.Context, log logr.Logger, req ctrl.Request, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
-	if objectNotFound(r, ctx, "wordpress", &appsv1.Deployment{}, *wordpress) {
+	if objectNotFound(r, ctx, childName(wordpress, "wordpress"), &appsv1.Deployment{}, *wordpress) {
 		deployment := newWordpressDeployment(wordpress)
 
 		if err := controllerutil.SetControllerReference(wordpress, deployment, r.Scheme); err != nil {
@@ -89,14 +99,14 @@ func createWordpressDeployment(r *WordpressReconciler, ctx context.Context, log
 		log.Info("Returned custom Wordpress Deployment object ", "name", req.NamespacedName.Name)
 		return ctrl.Result{Requeue: true}, nil
 	}
-	return ctrl.Result{}, nil
+	return updateDeploymentTemplate(r, ctx, log, wordpress, newWordpressDeployment(wordpress))
 }
 
 func newWordpressDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment {
 
-	return &appsv1.Deployment{
+	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "wordpress",
+			Name:      childName(wordpress, "wordpress"),
 			Namespace: wordpress.Namespace,
 			Labels: map[string]string{
 				"app": "wordpress",
@@ -104,43 +114,49 @@ func newWordpressDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment
 		},
 		Spec: appsv1.DeploymentSpec{
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app":  "wordpress",
-					"tier": "frontend",
-				},
+				MatchLabels: tierLabels(wordpress, "frontend"),
 			},
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RecreateDeploymentStrategyType,
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":  "wordpress",
-						"tier": "frontend",
-					},
+					Labels: tierLabels(wordpress, "frontend"),
 				},
 				Spec: v1.PodSpec{
+					InitContainers: []v1.Container{
+						{
+							// The headless MySQL service only resolves once the
+							// database passes its readiness probe.
+							Image:   mysqlImage,
+							Name:    "wait-for-mysql",
+							Command: []string{"sh", "-c", fmt.Sprintf("until mysqladmin ping -h %[1]s --connect-timeout=2 --silent; do echo waiting for %[1]s; sleep 2; done", mysqlHost(wordpress))},
+						},
+					},
 					Containers: []v1.Container{
 						{
-							Image: "wordpress:4.8-apache",
+							Image: wordpressImage,
 							Name:  "wordpress",
 							Env: []v1.EnvVar{
 								{
 									Name:  "WORDPRESS_DB_HOST",
-									Value: "wordpress-mysql",
+									Value: mysqlHost(wordpress),
 								},
 								{
 									Name: "WORDPRESS_DB_PASSWORD",
 									ValueFrom: &v1.EnvVarSource{
 										SecretKeyRef: &v1.SecretKeySelector{
 											LocalObjectReference: v1.LocalObjectReference{
-												Name: "mysql-pass",
+												Name: childName(wordpress, "mysql-pass"),
 											},
 											Key: "password",
 										},
 									},
 								},
 							},
+							ReadinessProbe: wordpressReadinessProbe(wordpress),
+							LivenessProbe:  wordpressLivenessProbe(wordpress),
+							StartupProbe:   wordpressStartupProbe(wordpress),
 							Ports: []v1.ContainerPort{
 								{
 									Name:          "wordpress",
@@ -160,7 +176,7 @@ func newWordpressDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment
 							Name: "wordpress-persistent-storage",
 							VolumeSource: v1.VolumeSource{
 								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-									ClaimName: "wp-pv-claim",
+									ClaimName: childName(wordpress, "wp-pv-claim"),
 								},
 							},
 						},
@@ -169,4 +185,9 @@ func newWordpressDeployment(wordpress *wordpressv1.Wordpress) *appsv1.Deployment
 			},
 		},
 	}
+	addPHPConfig(&deployment.Spec.Template, wordpress)
+	if wordpress.Spec.Cron != nil {
+		addCronMuPlugin(&deployment.Spec.Template.Spec, wordpress)
+	}
+	return setPodTemplateHash(deployment)
 }
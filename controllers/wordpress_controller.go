@@ -18,8 +18,10 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -32,6 +34,10 @@ type WordpressReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// OperatorImage is the image of the operator, which Jobs and sidecars
+	// run to encrypt and decrypt backup artifacts
+	OperatorImage string
 }
 
 // +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresses,verbs=get;list;watch;create;update;patch;delete
@@ -39,6 +45,18 @@ type WordpressReconciler struct {
 // +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresses/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=Deployment,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=Service,verbs=get;list;watch;create;update;patch;deleted
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresssites,verbs=get;list;watch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses;csidrivers,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -63,21 +81,144 @@ func (r *WordpressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	if !wordpress.DeletionTimestamp.IsZero() {
+		return finalizeWordpress(r, ctx, log, wordpress)
+	}
+
+	updated, err := reconcileDeletionFinalizer(r, ctx, wordpress)
+	if err != nil || updated {
+		return ctrl.Result{}, err
+	}
+
+	// No condition is set on failure, as it would mark the instance as
+	// reconciled before its names are scoped.
+	updated, err = reconcileCloneAnnotations(r, ctx, log, wordpress)
+	if err != nil || updated {
+		return ctrl.Result{}, err
+	}
+
+	if restore := wordpress.Annotations[wordpressv1.AnnotationRestoring]; restore != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonRestoringSnapshots, fmt.Sprintf("Paused while WordpressRestore %s replaces the volumes", restore))
+	}
+
 	res, err := createSecret(r, ctx, log, req, wordpress)
 	if err != nil {
-		return res, err
+		return res, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonCreatingSecret, err)
 	}
 
-	res, err = createWordPress(r, ctx, log, req, wordpress)
+	res, err = createMySQLStorage(r, ctx, log, req, wordpress)
 	if err != nil {
-		return res, err
+		return res, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonCreatingDatabaseStorage, err)
 	}
 
 	res, err = createMySQL(r, ctx, log, req, wordpress)
 	if err != nil {
-		return res, err
+		return res, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonCreatingDatabase, err)
+	}
+
+	ready, err := deploymentReady(r.Client, ctx, childName(wordpress, "wordpress-mysql"), wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !ready {
+		log.Info("Waiting for MySQL to become ready before creating Wordpress")
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonWaitingForDatabase, fmt.Sprintf("Waiting for the %s deployment to become ready", childName(wordpress, "wordpress-mysql")))
+	}
+
+	imported, waiting, err := reconcileImport(r, ctx, log, req, wordpress)
+	if err != nil {
+		return ctrl.Result{}, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonImporting, err)
+	}
+	if !imported {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonImporting, waiting)
+	}
+
+	res, err = createWordPress(r, ctx, log, req, wordpress)
+	if err != nil {
+		return res, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonCreatingFrontend, err)
+	}
+
+	ready, err = deploymentReady(r.Client, ctx, childName(wordpress, "wordpress"), wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !ready {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonWaitingForFrontend, fmt.Sprintf("Waiting for the %s deployment to become ready", childName(wordpress, "wordpress")))
+	}
+
+	cloned, waiting, err := reconcileClone(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonCloning, err)
+	}
+	if !cloned {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonCloning, waiting)
+	}
+
+	installed, waiting, err := reconcileInstall(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonInstalling, err)
+	}
+	if !installed {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonInstalling, waiting)
+	}
+
+	enabled, waiting, err := reconcileMultisite(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, setNotReady(r, ctx, log, wordpress, wordpressv1.ReasonEnablingMultisite, err)
+	}
+	if !enabled {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setReadyCondition(r, ctx, wordpress, metav1.ConditionFalse,
+			wordpressv1.ReasonEnablingMultisite, waiting)
+	}
+
+	err = setReadyCondition(r, ctx, wordpress, metav1.ConditionTrue, wordpressv1.ReasonAvailable, "MySQL and Wordpress are ready")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Backups only need the instance to be up, so the optional stages
+	// below cannot hold them back.
+	schedule, err := reconcileBackupSchedule(r, ctx, log, wordpress)
+	if err != nil {
+		return schedule, err
+	}
+
+	for _, kind := range []string{extensionPlugin, extensionTheme} {
+		err = reconcileExtensions(r, ctx, log, wordpress, kind)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
+	err = reconcileMultisiteIngress(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = reconcileCron(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = reconcileObjectCache(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = reconcilePageCache(r, ctx, log, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if schedule.RequeueAfter > 0 {
+		return schedule, nil
+	}
 	return ctrl.Result{Requeue: true}, nil
 }
 
@@ -0,0 +1,539 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// artifactsFinalizer holds a backup with the Delete artifact policy until its
+// artifacts have been removed
+const artifactsFinalizer = "wordpress.example.com/artifacts"
+
+// WordpressBackupReconciler reconciles a WordpressBackup object
+type WordpressBackupReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// OperatorImage is the image of the operator, which Jobs and sidecars
+	// run to encrypt and decrypt backup artifacts
+	OperatorImage string
+}
+
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressbackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressbackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressbackups/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
+
+// Reconcile runs a Job taking a mysqldump and a wp-content archive of the
+// referenced Wordpress instance, or snapshots its volumes, and records the
+// outcome in the backup status. Completed archive backups with spec.verify
+// are then test-restored by another Job. Completed and failed backups are
+// never retried.
+func (r *WordpressBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("wordpressbackup", req.NamespacedName)
+
+	backup := &wordpressv1.WordpressBackup{}
+	err := r.Get(ctx, req.NamespacedName, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !backup.DeletionTimestamp.IsZero() {
+		return deleteBackupArtifacts(r, ctx, log, backup)
+	}
+
+	if backup.Spec.ArtifactPolicy == wordpressv1.ArtifactPolicyDelete && !controllerutil.ContainsFinalizer(backup, artifactsFinalizer) {
+		controllerutil.AddFinalizer(backup, artifactsFinalizer)
+		return ctrl.Result{}, r.Update(ctx, backup)
+	}
+
+	if backup.Status.Phase == wordpressv1.BackupPhaseCompleted && verificationRequested(backup) {
+		return verifyBackup(r, ctx, log, backup)
+	}
+	if backup.Status.Phase == wordpressv1.BackupPhaseCompleted || backup.Status.Phase == wordpressv1.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	if backup.Spec.Method == wordpressv1.BackupMethodSnapshot {
+		if backup.Spec.Encryption != nil {
+			return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseFailed, "snapshot backups cannot be encrypted")
+		}
+		return runSnapshotBackup(r, ctx, log, backup)
+	}
+
+	if err := validateBackupTarget(backup.Spec.Target); err != nil {
+		return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseFailed, err.Error())
+	}
+	if encryption := backup.Spec.Encryption; encryption != nil && backup.Status.JobName == "" {
+		if err := validateEncryption(r.Client, ctx, backup.Namespace, encryption, r.OperatorImage); err != nil {
+			return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseFailed, err.Error())
+		}
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: backupJobName(backup), Namespace: backup.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if errors.IsNotFound(err) {
+		return createBackupJob(r, ctx, log, backup)
+	}
+
+	return updateBackupFromJob(r, ctx, log, backup, job)
+}
+
+// deleteBackupArtifacts runs a Job removing the artifacts of a backup with the
+// Delete artifact policy and releases the finalizer once it has succeeded.
+func deleteBackupArtifacts(r *WordpressBackupReconciler, ctx context.Context, log logr.Logger, backup *wordpressv1.WordpressBackup) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(backup, artifactsFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if snapshots := backup.Status.Snapshots; snapshots != nil {
+		for _, name := range []string{snapshots.Database, snapshots.Content} {
+			err := deleteVolumeSnapshot(r.Client, ctx, backup.Namespace, name)
+			if err != nil {
+				log.Error(err, "Failed to delete VolumeSnapshot", "volumesnapshot.name", name)
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("Deleted backup VolumeSnapshots")
+	}
+
+	if backup.Status.Location != "" && backup.Spec.Target.S3 != nil {
+		err := deleteS3Artifacts(r, ctx, backup)
+		if err != nil {
+			log.Error(err, "Failed to remove backup artifacts", "location", backup.Status.Location)
+			return ctrl.Result{}, err
+		}
+		log.Info("Removed backup artifacts", "location", backup.Status.Location)
+	}
+
+	if backup.Status.Location != "" && backup.Spec.Target.PersistentVolumeClaim != nil {
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: artifactCleanupJobName(backup), Namespace: backup.Namespace}, job)
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if errors.IsNotFound(err) {
+			job = newArtifactCleanupJob(backup)
+			if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+				return ctrl.Result{}, err
+			}
+			err = r.Create(ctx, job)
+			if err != nil {
+				log.Error(err, "Failed to create artifact cleanup Job", "job.name", job.Name)
+				return ctrl.Result{}, err
+			}
+			log.Info("Returned custom artifact cleanup Job object", "job.name", job.Name)
+			return ctrl.Result{}, nil
+		}
+		if jobFailed(job) {
+			return ctrl.Result{}, fmt.Errorf("job %s failed to remove the artifacts at %s", job.Name, backup.Status.Location)
+		}
+		if job.Status.Succeeded == 0 {
+			return ctrl.Result{}, nil
+		}
+		log.Info("Removed backup artifacts", "location", backup.Status.Location)
+	}
+
+	controllerutil.RemoveFinalizer(backup, artifactsFinalizer)
+	return ctrl.Result{}, r.Update(ctx, backup)
+}
+
+// wordpressPending returns the instance to back up, and why it cannot be
+// backed up yet, if it cannot.
+func wordpressPending(r *WordpressBackupReconciler, ctx context.Context, backup *wordpressv1.WordpressBackup) (*wordpressv1.Wordpress, string, error) {
+	wordpress := &wordpressv1.Wordpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: backup.Spec.WordpressRef, Namespace: backup.Namespace}, wordpress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Wordpress %s not found", backup.Spec.WordpressRef), nil
+		}
+		return nil, "", err
+	}
+
+	if !meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionReady) {
+		return wordpress, fmt.Sprintf("Waiting for Wordpress %s to become ready", wordpress.Name), nil
+	}
+	return wordpress, "", nil
+}
+
+func createBackupJob(r *WordpressBackupReconciler, ctx context.Context, log logr.Logger, backup *wordpressv1.WordpressBackup) (ctrl.Result, error) {
+	wordpress, pending, err := wordpressPending(r, ctx, backup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setBackupPending(r, ctx, backup, pending)
+	}
+
+	job := newBackupJob(backup, wordpress, r.OperatorImage)
+	if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.Create(ctx, job)
+	if err != nil {
+		log.Error(err, "Failed to create backup Job", "job.name", job.Name)
+		return ctrl.Result{}, err
+	}
+	log.Info("Returned custom backup Job object", "job.name", job.Name)
+
+	return ctrl.Result{}, setBackupRunning(r, ctx, backup, job)
+}
+
+func updateBackupFromJob(r *WordpressBackupReconciler, ctx context.Context, log logr.Logger, backup *wordpressv1.WordpressBackup, job *batchv1.Job) (ctrl.Result, error) {
+	if jobFailed(job) {
+		log.Info("Backup Job failed", "job.name", job.Name)
+		if backup.Spec.Target.S3 != nil {
+			// Streamed uploads leave partial objects behind.
+			if err := deleteS3Artifacts(r, ctx, backup); err != nil {
+				log.Error(err, "Failed to remove partial backup artifacts", "location", backup.Status.Location)
+			}
+		}
+		now := metav1.Now()
+		backup.Status.CompletionTime = &now
+		return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseFailed, fmt.Sprintf("Job %s failed", job.Name))
+	}
+
+	if job.Status.Succeeded == 0 {
+		if backup.Status.Phase == wordpressv1.BackupPhaseRunning {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, setBackupRunning(r, ctx, backup, job)
+	}
+
+	message, err := jobTerminationMessage(r.Client, ctx, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	result, err := parseBackupResult(message)
+	if err != nil {
+		return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseFailed, fmt.Sprintf("invalid result from Job %s: %v", job.Name, err))
+	}
+
+	log.Info("Backup completed", "location", backup.Status.Location, "size", result.Size)
+	backup.Status.Size = result.Size
+	backup.Status.Checksum = result.Checksum
+	backup.Status.CompletionTime = job.Status.CompletionTime
+	return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseCompleted, "")
+}
+
+// runSnapshotBackup takes a snapshot backup. A helper Job holds FLUSH TABLES
+// WITH READ LOCK while VolumeSnapshots of both claims are created, and is
+// deleted, releasing the lock, as soon as both snapshots have been cut. The
+// backup completes once the snapshots are ready to use.
+func runSnapshotBackup(r *WordpressBackupReconciler, ctx context.Context, log logr.Logger, backup *wordpressv1.WordpressBackup) (ctrl.Result, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: lockJobName(backup), Namespace: backup.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	locking := err == nil
+
+	if backup.Status.Snapshots == nil {
+		wordpress, pending, err := wordpressPending(r, ctx, backup)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !locking {
+			if pending != "" {
+				return ctrl.Result{RequeueAfter: readinessPollInterval}, setBackupPending(r, ctx, backup, pending)
+			}
+
+			job = newLockJob(backup, wordpress)
+			if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+				return ctrl.Result{}, err
+			}
+			err = r.Create(ctx, job)
+			if err != nil {
+				log.Error(err, "Failed to create lock Job", "job.name", job.Name)
+				return ctrl.Result{}, err
+			}
+			log.Info("Returned custom lock Job object", "job.name", job.Name)
+
+			now := metav1.Now()
+			backup.Status.StartTime = &now
+			backup.Status.JobName = job.Name
+			return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseRunning, "Locking the database")
+		}
+
+		if jobFailed(job) || job.Status.Succeeded > 0 {
+			return failSnapshotBackup(r, ctx, log, backup, job, fmt.Sprintf("Job %s could not lock the database", job.Name))
+		}
+		if wordpress == nil {
+			return failSnapshotBackup(r, ctx, log, backup, job, pending)
+		}
+		locked, err := jobPodReady(r.Client, ctx, job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !locked {
+			return ctrl.Result{RequeueAfter: time.Second}, nil
+		}
+
+		snapshots := volumeSnapshotNames(backup)
+		for _, claim := range []struct{ name, claimName string }{
+			{snapshots.Database, childName(wordpress, "mysql-pv-claim")},
+			{snapshots.Content, childName(wordpress, "wp-pv-claim")},
+		} {
+			snapshot := newVolumeSnapshot(backup, claim.name, claim.claimName)
+			err = r.Create(ctx, snapshot)
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "Failed to create VolumeSnapshot", "volumesnapshot.name", claim.name)
+				return failSnapshotBackup(r, ctx, log, backup, job, err.Error())
+			}
+			log.Info("Created VolumeSnapshot", "volumesnapshot.name", claim.name, "pvc.name", claim.claimName)
+		}
+
+		backup.Status.Snapshots = snapshots
+		return ctrl.Result{RequeueAfter: time.Second}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseRunning, "Taking snapshots")
+	}
+
+	states := []*snapshotState{}
+	for _, name := range []string{backup.Status.Snapshots.Database, backup.Status.Snapshots.Content} {
+		state, err := getSnapshotState(r.Client, ctx, backup.Namespace, name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return failSnapshotBackup(r, ctx, log, backup, job, fmt.Sprintf("VolumeSnapshot %s not found", name))
+			}
+			return ctrl.Result{}, err
+		}
+		if state.err != "" {
+			return failSnapshotBackup(r, ctx, log, backup, job, fmt.Sprintf("VolumeSnapshot %s failed: %s", name, state.err))
+		}
+		states = append(states, state)
+	}
+
+	taken := states[0].taken && states[1].taken
+	if !taken {
+		if !locking || jobFailed(job) || job.Status.Succeeded > 0 {
+			return failSnapshotBackup(r, ctx, log, backup, job, "The database lock was released before the snapshots were taken")
+		}
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+
+	if locking {
+		err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		log.Info("Released the database lock", "job.name", job.Name)
+	}
+
+	if !states[0].ready || !states[1].ready {
+		if backup.Status.Message == "Waiting for snapshots to become ready" {
+			return ctrl.Result{RequeueAfter: readinessPollInterval}, nil
+		}
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseRunning,
+			"Waiting for snapshots to become ready")
+	}
+
+	size := int64(0)
+	for _, state := range states {
+		if state.restoreSize != nil {
+			size += state.restoreSize.Value()
+		}
+	}
+
+	log.Info("Backup completed", "snapshots", backup.Status.Snapshots, "size", size)
+	now := metav1.Now()
+	backup.Status.Size = size
+	backup.Status.CompletionTime = &now
+	return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseCompleted, "")
+}
+
+// failSnapshotBackup releases the database lock, deletes the snapshots taken
+// so far and fails the backup.
+func failSnapshotBackup(r *WordpressBackupReconciler, ctx context.Context, log logr.Logger, backup *wordpressv1.WordpressBackup, job *batchv1.Job, message string) (ctrl.Result, error) {
+	log.Info("Snapshot backup failed", "reason", message)
+	if job.Name != "" {
+		err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	snapshots := volumeSnapshotNames(backup)
+	for _, name := range []string{snapshots.Database, snapshots.Content} {
+		if err := deleteVolumeSnapshot(r.Client, ctx, backup.Namespace, name); err != nil {
+			log.Error(err, "Failed to delete partial VolumeSnapshot", "volumesnapshot.name", name)
+		}
+	}
+
+	now := metav1.Now()
+	backup.Status.Snapshots = nil
+	backup.Status.CompletionTime = &now
+	return ctrl.Result{}, setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseFailed, message)
+}
+
+// verifyBackup runs the Job test-restoring a completed backup and records the
+// outcome in the Verified condition. A verification is never retried.
+func verifyBackup(r *WordpressBackupReconciler, ctx context.Context, log logr.Logger, backup *wordpressv1.WordpressBackup) (ctrl.Result, error) {
+	verified := meta.FindStatusCondition(backup.Status.Conditions, wordpressv1.ConditionVerified)
+	if verified != nil && verified.Reason != wordpressv1.ReasonVerifying {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: verifyJobName(backup), Namespace: backup.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	if errors.IsNotFound(err) {
+		// The Job follows the frontend of the instance while it exists.
+		wordpress := &wordpressv1.Wordpress{}
+		err = r.Get(ctx, types.NamespacedName{Name: backup.Spec.WordpressRef, Namespace: backup.Namespace}, wordpress)
+		if errors.IsNotFound(err) {
+			wordpress = nil
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+		job = newVerifyJob(backup, wordpress, r.OperatorImage)
+		if err := controllerutil.SetControllerReference(backup, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create verification Job", "job.name", job.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("Returned custom verification Job object", "job.name", job.Name)
+		return ctrl.Result{}, setBackupCondition(r, ctx, backup, wordpressv1.ConditionVerified, metav1.ConditionUnknown,
+			wordpressv1.ReasonVerifying, "Test-restoring into a scratch MySQL server")
+	}
+
+	if jobFailed(job) {
+		message, err := jobFailureMessage(r.Client, ctx, job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if message == "" {
+			message = fmt.Sprintf("Job %s failed", job.Name)
+		}
+		log.Info("Backup verification failed", "job.name", job.Name, "reason", message)
+		return ctrl.Result{}, setBackupCondition(r, ctx, backup, wordpressv1.ConditionVerified, metav1.ConditionFalse,
+			wordpressv1.ReasonVerificationFailed, message)
+	}
+	if job.Status.Succeeded == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	message, err := jobTerminationMessage(r.Client, ctx, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	result, err := parseVerifyResult(message)
+	if err != nil {
+		return ctrl.Result{}, setBackupCondition(r, ctx, backup, wordpressv1.ConditionVerified, metav1.ConditionFalse,
+			wordpressv1.ReasonVerificationFailed, fmt.Sprintf("invalid result from Job %s: %v", job.Name, err))
+	}
+
+	log.Info("Backup verified", "tables", result.Tables, "siteurl", result.SiteURL)
+	return ctrl.Result{}, setBackupCondition(r, ctx, backup, wordpressv1.ConditionVerified, metav1.ConditionTrue,
+		wordpressv1.ReasonVerificationPassed, fmt.Sprintf("Restored %d tables of %s", result.Tables, result.SiteURL))
+}
+
+func setBackupCondition(r *WordpressBackupReconciler, ctx context.Context, backup *wordpressv1.WordpressBackup, conditionType string, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: backup.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	return r.Status().Update(ctx, backup)
+}
+
+// deleteS3Artifacts deletes every object under the key prefix of the backup.
+func deleteS3Artifacts(r *WordpressBackupReconciler, ctx context.Context, backup *wordpressv1.WordpressBackup) error {
+	s3, err := newS3Client(r.Client, ctx, backup.Namespace, backup.Spec.Target.S3)
+	if err != nil {
+		return err
+	}
+	return s3.deletePrefix(ctx, backupDir(backup)+"/")
+}
+
+func setBackupRunning(r *WordpressBackupReconciler, ctx context.Context, backup *wordpressv1.WordpressBackup, job *batchv1.Job) error {
+	now := metav1.Now()
+	backup.Status.StartTime = &now
+	backup.Status.JobName = job.Name
+	backup.Status.Location = backupLocation(backup)
+	if backup.Spec.Encryption != nil {
+		backup.Status.EncryptionKeyID = backup.Spec.Encryption.KeyID
+	}
+	return setBackupPhase(r, ctx, backup, wordpressv1.BackupPhaseRunning, "")
+}
+
+// setBackupPending records why the backup cannot start yet, skipping the
+// status update when nothing changed.
+func setBackupPending(r *WordpressBackupReconciler, ctx context.Context, backup *wordpressv1.WordpressBackup, message string) error {
+	if backup.Status.Phase == wordpressv1.BackupPhasePending && backup.Status.Message == message {
+		return nil
+	}
+	return setBackupPhase(r, ctx, backup, wordpressv1.BackupPhasePending, message)
+}
+
+// setBackupPhase records the phase and message along with any other status
+// fields set by the caller.
+func setBackupPhase(r *WordpressBackupReconciler, ctx context.Context, backup *wordpressv1.WordpressBackup, phase wordpressv1.BackupPhase, message string) error {
+	backup.Status.Phase = phase
+	backup.Status.Message = message
+	return r.Status().Update(ctx, backup)
+}
+
+func jobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WordpressBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&wordpressv1.WordpressBackup{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
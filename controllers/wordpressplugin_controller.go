@@ -0,0 +1,246 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// pluginFinalizer holds a WordpressPlugin until its plugin has been removed
+// from the instance
+const pluginFinalizer = "wordpress.example.com/plugin"
+
+// WordpressPluginReconciler reconciles a WordpressPlugin object
+type WordpressPluginReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressplugins,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressplugins/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressplugins/finalizers,verbs=update
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile runs a wp-cli Job installing, upgrading and activating or
+// deactivating the plugin on the referenced instance whenever the spec
+// changes, and again every extensionResyncInterval. A slug is managed by
+// spec.plugins of the instance if listed there, and otherwise by the oldest
+// WordpressPlugin claiming it; the others are reported in conflict. Deleting
+// a WordpressPlugin removes its plugin from the instance.
+func (r *WordpressPluginReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("wordpressplugin", req.NamespacedName)
+
+	plugin := &wordpressv1.WordpressPlugin{}
+	err := r.Get(ctx, req.NamespacedName, plugin)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !plugin.DeletionTimestamp.IsZero() {
+		return removePlugin(r, ctx, log, plugin)
+	}
+	if added, err := addManagedFinalizer(r.Client, ctx, managedPlugin{plugin}); added || err != nil {
+		return ctrl.Result{}, err
+	}
+
+	wordpress, pending, err := referencedWordpress(r.Client, ctx, plugin.Namespace, plugin.Spec.WordpressRef)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedPlugin{plugin}, phasePending, pending)
+	}
+
+	owner, err := pluginOwner(r, ctx, plugin, wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if owner != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedPlugin{plugin}, phaseConflict,
+			fmt.Sprintf("Plugin %s of Wordpress %s is managed by %s", plugin.Spec.Slug, wordpress.Name, owner))
+	}
+
+	sync := &extensionSync{Extensions: []desiredExtension{}}
+	if err := sync.addPlugin(plugin.Spec.PluginSpec); err != nil {
+		return ctrl.Result{}, setManagedPhase(r.Client, ctx, managedPlugin{plugin}, phaseFailed, err.Error())
+	}
+	return syncPlugin(r, ctx, log, plugin, wordpress, sync)
+}
+
+// managedPlugin adapts a WordpressPlugin to managedObject.
+type managedPlugin struct {
+	*wordpressv1.WordpressPlugin
+}
+
+func (p managedPlugin) object() client.Object { return p.WordpressPlugin }
+func (p managedPlugin) kind() string          { return "WordpressPlugin" }
+func (p managedPlugin) what() string          { return "plugin" }
+func (p managedPlugin) finalizer() string     { return pluginFinalizer }
+func (p managedPlugin) phase() string         { return string(p.Status.Phase) }
+func (p managedPlugin) jobName() string       { return p.Status.JobName }
+func (p managedPlugin) status() interface{}   { return p.Status }
+func (p managedPlugin) fresh() managedObject  { return managedPlugin{&wordpressv1.WordpressPlugin{}} }
+
+func (p managedPlugin) setPhase(phase, message string) {
+	p.Status.Phase = wordpressv1.PluginPhase(phase)
+	p.Status.Message = message
+}
+
+func (p managedPlugin) setJobName(name string) {
+	p.Status.JobName = name
+}
+
+// pluginOwner returns what manages the slug of the plugin instead of it, if
+// anything does: spec.plugins of the instance, or an older WordpressPlugin.
+func pluginOwner(r *WordpressPluginReconciler, ctx context.Context, plugin *wordpressv1.WordpressPlugin, wordpress *wordpressv1.Wordpress) (string, error) {
+	for _, declared := range wordpress.Spec.Plugins {
+		if declared.Slug == plugin.Spec.Slug {
+			return "spec.plugins", nil
+		}
+	}
+
+	owner, err := managedOwner(r.Client, ctx, managedPlugin{plugin}, &wordpressv1.WordpressPluginList{}, func(item runtime.Object) bool {
+		other := item.(*wordpressv1.WordpressPlugin)
+		return other.Spec.WordpressRef == plugin.Spec.WordpressRef && other.Spec.Slug == plugin.Spec.Slug
+	})
+	if owner == "" || err != nil {
+		return "", err
+	}
+	return "WordpressPlugin " + owner, nil
+}
+
+// syncPlugin runs the sync Job of the plugin and records its outcome.
+func syncPlugin(r *WordpressPluginReconciler, ctx context.Context, log logr.Logger, plugin *wordpressv1.WordpressPlugin, wordpress *wordpressv1.Wordpress, sync *extensionSync) (ctrl.Result, error) {
+	job, resync, err := runManagedJob(r.Client, r.Scheme, ctx, log, managedPlugin{plugin}, pluginJobName(plugin, sync), func() *batchv1.Job {
+		return newSyncJob(wordpress, extensionPlugin, "wordpressplugin", pluginJobName(plugin, sync), sync)
+	})
+	if job == nil || err != nil {
+		return resync, err
+	}
+
+	if jobFailed(job) {
+		message, err := managedJobFailure(r.Client, ctx, job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return resync, setManagedPhase(r.Client, ctx, managedPlugin{plugin}, phaseFailed, message)
+	}
+	result, err := readSyncResult(r.Client, ctx, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(result.Items) != 1 {
+		return ctrl.Result{}, fmt.Errorf("invalid result of job %s: %d plugins reported", job.Name, len(result.Items))
+	}
+
+	item := result.Items[0]
+	if plugin.Status.Version != item.Version || plugin.Status.Active != item.Active {
+		purgePageCache(r.Client, ctx, log, wordpress)
+	}
+	plugin.Status.Version = item.Version
+	plugin.Status.Active = item.Active
+	plugin.Status.LastSyncTime = jobFinishedAt(job)
+	if item.Error != "" {
+		return resync, setManagedPhase(r.Client, ctx, managedPlugin{plugin}, phaseFailed, item.Error)
+	}
+	return resync, setManagedPhase(r.Client, ctx, managedPlugin{plugin}, phaseSynced, "")
+}
+
+// removePlugin uninstalls the plugin of a deleted WordpressPlugin, see
+// removeManaged.
+func removePlugin(r *WordpressPluginReconciler, ctx context.Context, log logr.Logger, plugin *wordpressv1.WordpressPlugin) (ctrl.Result, error) {
+	wordpress, pending, err := referencedWordpress(r.Client, ctx, plugin.Namespace, plugin.Spec.WordpressRef)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	newJob := func(name string) *batchv1.Job {
+		sync := &extensionSync{Extensions: []desiredExtension{{Slug: plugin.Spec.Slug, Remove: true}}}
+		return newSyncJob(wordpress, extensionPlugin, "wordpressplugin", name, sync)
+	}
+	check := func(job *batchv1.Job) (string, error) {
+		result, err := readSyncResult(r.Client, ctx, job)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range result.Items {
+			if item.Error != "" {
+				return item.Error, nil
+			}
+		}
+		return "", nil
+	}
+	return removeManaged(r.Client, r.Scheme, ctx, log, managedPlugin{plugin}, wordpress, pending, newJob, check)
+}
+
+// pluginJobName names the sync Job after the desired plugin, so that a change
+// to the spec starts a new sync.
+func pluginJobName(plugin *wordpressv1.WordpressPlugin, sync *extensionSync) string {
+	data, _ := json.Marshal(sync)
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-plugin-%x", plugin.Name, sum[:5])
+}
+
+// pluginsOfWordpress requeues the WordpressPlugins of an instance, so that
+// they notice it becoming ready and slugs added to its spec.plugins.
+func (r *WordpressPluginReconciler) pluginsOfWordpress(object client.Object) []reconcile.Request {
+	list := &wordpressv1.WordpressPluginList{}
+	err := r.List(context.Background(), list, client.InNamespace(object.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list WordpressPlugins", "namespace", object.GetNamespace())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, plugin := range list.Items {
+		if plugin.Spec.WordpressRef == object.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: plugin.Name, Namespace: plugin.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WordpressPluginReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&wordpressv1.WordpressPlugin{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &wordpressv1.Wordpress{}}, handler.EnqueueRequestsFromMapFunc(r.pluginsOfWordpress)).
+		Complete(r)
+}
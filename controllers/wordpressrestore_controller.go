@@ -0,0 +1,591 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"time"
+
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// WordpressRestoreReconciler reconciles a WordpressRestore object
+type WordpressRestoreReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// OperatorImage is the image of the operator, which Jobs and sidecars
+	// run to encrypt and decrypt backup artifacts
+	OperatorImage string
+}
+
+// restoreSource locates the artifacts of a restore
+type restoreSource struct {
+	target wordpressv1.BackupTarget
+	// dir is the directory, or S3 key prefix, holding the artifacts
+	dir string
+	// checksum is the expected SHA-256 of SHA256SUMS, if known
+	checksum string
+	// snapshots is set instead for snapshot backups
+	snapshots *wordpressv1.BackupSnapshots
+	// backup is the WordpressBackup holding the artifacts, if any
+	backup string
+	// encrypted is set when the artifacts are encrypted. keys are the
+	// Secrets holding the keys of the artifacts and binlogs.
+	encrypted bool
+	keys      []v1.LocalObjectReference
+	// binlogs and binlogDir locate the binlogs replayed by point-in-time
+	// restores
+	binlogs   *wordpressv1.BackupTarget
+	binlogDir string
+}
+
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressrestores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressrestores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressrestores/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch
+
+// Reconcile walks a restore through its phases: the frontend is scaled down,
+// a Job imports the database dump and wp-content and rewrites the site URL,
+// and the frontend is scaled back up. The frontend is scaled back up on
+// failure too. Snapshot backups are restored by scaling down the database as
+// well and replacing both claims with ones provisioned from the snapshots.
+func (r *WordpressRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("wordpressrestore", req.NamespacedName)
+
+	restore := &wordpressv1.WordpressRestore{}
+	err := r.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	switch restore.Status.Phase {
+	case wordpressv1.RestorePhaseCompleted, wordpressv1.RestorePhaseFailed:
+		return ctrl.Result{}, nil
+	case wordpressv1.RestorePhaseScalingDown:
+		return scaleDownForRestore(r, ctx, log, restore)
+	case wordpressv1.RestorePhaseRestoring:
+		source, pending, err := resolveRestoreSource(r, ctx, restore)
+		if err == nil && pending == "" && source.snapshots != nil {
+			return restoreSnapshots(r, ctx, log, restore, source.snapshots)
+		}
+		return runRestoreJob(r, ctx, log, restore)
+	case wordpressv1.RestorePhaseScalingUp:
+		return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseCompleted, "")
+	default:
+		return startRestore(r, ctx, log, restore)
+	}
+}
+
+// startRestore waits for the source and the database to be available.
+func startRestore(r *WordpressRestoreReconciler, ctx context.Context, log logr.Logger, restore *wordpressv1.WordpressRestore) (ctrl.Result, error) {
+	source, pending, err := resolveRestoreSource(r, ctx, restore)
+	if err != nil {
+		return ctrl.Result{}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseFailed, err.Error())
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setRestorePending(r, ctx, restore, pending)
+	}
+
+	wordpress := &wordpressv1.Wordpress{}
+	err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.WordpressRef, Namespace: restore.Namespace}, wordpress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{RequeueAfter: readinessPollInterval}, setRestorePending(r, ctx, restore,
+				fmt.Sprintf("Wordpress %s not found", restore.Spec.WordpressRef))
+		}
+		return ctrl.Result{}, err
+	}
+
+	ready, err := deploymentReady(r.Client, ctx, childName(wordpress, "wordpress-mysql"), wordpress)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !ready {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setRestorePending(r, ctx, restore,
+			fmt.Sprintf("Waiting for the %s deployment to become ready", childName(wordpress, "wordpress-mysql")))
+	}
+
+	now := metav1.Now()
+	restore.Status.StartTime = &now
+	if source.snapshots == nil {
+		restore.Status.Location = targetLocation(source.target, source.dir)
+	}
+	restore.Status.Backup = source.backup
+	log.Info("Starting restore", "location", restore.Status.Location, "snapshots", source.snapshots)
+	return ctrl.Result{Requeue: true}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseScalingDown, "")
+}
+
+// resolveRestoreSource returns where the artifacts are. A non-empty pending
+// message means the source is not usable yet; an error means it never will be.
+func resolveRestoreSource(r *WordpressRestoreReconciler, ctx context.Context, restore *wordpressv1.WordpressRestore) (*restoreSource, string, error) {
+	if restore.Spec.PointInTime != nil {
+		return resolvePointInTimeSource(r, ctx, restore)
+	}
+	if (restore.Spec.BackupRef == "") == (restore.Spec.Source == nil) {
+		return nil, "", fmt.Errorf("exactly one of backupRef and source must be set")
+	}
+
+	if source := restore.Spec.Source; source != nil {
+		if err := validateBackupTarget(*source); err != nil {
+			return nil, "", err
+		}
+		dir := ""
+		if source.S3 != nil {
+			dir = source.S3.Prefix
+		} else {
+			dir = source.PersistentVolumeClaim.Path
+		}
+		resolved := &restoreSource{target: *source, dir: dir}
+		if secret := restore.Spec.DecryptionSecretRef; secret != nil {
+			resolved.encrypted = true
+			resolved.keys = []v1.LocalObjectReference{*secret}
+		}
+		return resolved, "", nil
+	}
+
+	backup := &wordpressv1.WordpressBackup{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.BackupRef, Namespace: restore.Namespace}, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("WordpressBackup %s not found", restore.Spec.BackupRef), nil
+		}
+		return nil, "", err
+	}
+	return backupRestoreSource(restore, backup)
+}
+
+// backupRestoreSource returns the artifacts of backup.
+func backupRestoreSource(restore *wordpressv1.WordpressRestore, backup *wordpressv1.WordpressBackup) (*restoreSource, string, error) {
+	switch backup.Status.Phase {
+	case wordpressv1.BackupPhaseCompleted:
+		if backup.Status.Snapshots != nil {
+			if restore.Spec.SiteURL != "" {
+				return nil, "", fmt.Errorf("siteURL is not supported when restoring snapshot backups")
+			}
+			return &restoreSource{snapshots: backup.Status.Snapshots, backup: backup.Name}, "", nil
+		}
+		source := &restoreSource{target: backup.Spec.Target, dir: backupDir(backup), checksum: backup.Status.Checksum, backup: backup.Name}
+		if backup.Status.EncryptionKeyID != "" {
+			if backup.Spec.Encryption == nil {
+				return nil, "", fmt.Errorf("WordpressBackup %s is encrypted but has no spec.encryption naming its keys", backup.Name)
+			}
+			source.encrypted = true
+			source.keys = []v1.LocalObjectReference{backup.Spec.Encryption.SecretRef}
+		}
+		return source, "", nil
+	case wordpressv1.BackupPhaseFailed:
+		return nil, "", fmt.Errorf("WordpressBackup %s failed", backup.Name)
+	default:
+		return nil, fmt.Sprintf("Waiting for WordpressBackup %s to complete", backup.Name), nil
+	}
+}
+
+// resolvePointInTimeSource returns the archive backup to roll forward, either
+// backupRef or the latest one completed before the point in time, along with
+// the binlogs of the instance.
+func resolvePointInTimeSource(r *WordpressRestoreReconciler, ctx context.Context, restore *wordpressv1.WordpressRestore) (*restoreSource, string, error) {
+	pointInTime := restore.Spec.PointInTime.Time
+	if restore.Spec.Source != nil {
+		return nil, "", fmt.Errorf("source cannot be combined with pointInTime")
+	}
+	if pointInTime.After(time.Now()) {
+		return nil, "", fmt.Errorf("pointInTime %s is in the future", pointInTime.UTC().Format(time.RFC3339))
+	}
+
+	wordpress := &wordpressv1.Wordpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.WordpressRef, Namespace: restore.Namespace}, wordpress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Wordpress %s not found", restore.Spec.WordpressRef), nil
+		}
+		return nil, "", err
+	}
+	if !pointInTimeEnabled(wordpress) {
+		return nil, "", fmt.Errorf("Wordpress %s does not ship binlogs; set spec.backup.pointInTime", wordpress.Name)
+	}
+
+	list := &wordpressv1.WordpressBackupList{}
+	err = r.List(ctx, list, client.InNamespace(restore.Namespace))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var backup *wordpressv1.WordpressBackup
+	for i := range list.Items {
+		candidate := &list.Items[i]
+		if restore.Spec.BackupRef != "" && candidate.Name != restore.Spec.BackupRef {
+			continue
+		}
+		if candidate.Spec.WordpressRef != wordpress.Name || candidate.Spec.Method == wordpressv1.BackupMethodSnapshot ||
+			candidate.Status.Phase != wordpressv1.BackupPhaseCompleted || candidate.Status.CompletionTime == nil {
+			continue
+		}
+		if candidate.Status.CompletionTime.Time.After(pointInTime) {
+			continue
+		}
+		if backup == nil || backup.Status.CompletionTime.Before(candidate.Status.CompletionTime) {
+			backup = candidate
+		}
+	}
+	if backup == nil {
+		if restore.Spec.BackupRef != "" {
+			return nil, "", fmt.Errorf("WordpressBackup %s is not a completed archive backup of %s taken before pointInTime",
+				restore.Spec.BackupRef, wordpress.Name)
+		}
+		return nil, "", fmt.Errorf("no archive backup of %s completed before pointInTime", wordpress.Name)
+	}
+
+	source, pending, err := backupRestoreSource(restore, backup)
+	if source != nil {
+		binlogs, dir := binlogTarget(wordpress)
+		source.binlogs = &binlogs
+		source.binlogDir = dir
+		if encryption := wordpress.Spec.Backup.Encryption; encryption != nil {
+			source.keys = append(source.keys, encryption.SecretRef)
+		}
+	}
+	return source, pending, err
+}
+
+// scaleDownForRestore records the replica count of the frontend and scales it
+// to zero, so that nothing writes to the site while it is restored. For
+// snapshot restores the Wordpress controller is paused and the database is
+// scaled down too, so that both claims can be replaced.
+func scaleDownForRestore(r *WordpressRestoreReconciler, ctx context.Context, log logr.Logger, restore *wordpressv1.WordpressRestore) (ctrl.Result, error) {
+	source, pending, err := resolveRestoreSource(r, ctx, restore)
+	if err != nil || pending != "" {
+		message := pending
+		if err != nil {
+			message = err.Error()
+		}
+		return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed, message)
+	}
+
+	wordpress := &wordpressv1.Wordpress{}
+	err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.WordpressRef, Namespace: restore.Namespace}, wordpress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed,
+				fmt.Sprintf("Wordpress %s not found", restore.Spec.WordpressRef))
+		}
+		return ctrl.Result{}, err
+	}
+
+	if source.snapshots != nil {
+		if current := wordpress.Annotations[wordpressv1.AnnotationRestoring]; current != restore.Name {
+			if current != "" {
+				return ctrl.Result{RequeueAfter: readinessPollInterval}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseScalingDown,
+					fmt.Sprintf("Waiting for WordpressRestore %s to finish", current))
+			}
+			if wordpress.Annotations == nil {
+				wordpress.Annotations = map[string]string{}
+			}
+			wordpress.Annotations[wordpressv1.AnnotationRestoring] = restore.Name
+			err = r.Update(ctx, wordpress)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Info("Paused Wordpress for snapshot restore", "wordpress.name", wordpress.Name)
+		}
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: childName(wordpress, "wordpress"), Namespace: restore.Namespace}, deployment)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	if err == nil {
+		if restore.Status.FrontendReplicas == nil {
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+			restore.Status.FrontendReplicas = &replicas
+			return ctrl.Result{Requeue: true}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseScalingDown, "")
+		}
+
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 0 {
+			zero := int32(0)
+			deployment.Spec.Replicas = &zero
+			err = r.Update(ctx, deployment)
+			if err != nil {
+				log.Error(err, "Failed to scale down Wordpress Deployment", "deployment.name", deployment.Name)
+				return ctrl.Result{}, err
+			}
+			log.Info("Scaled down Wordpress Deployment for restore", "deployment.name", deployment.Name)
+		}
+
+		if deployment.Status.Replicas != 0 {
+			return ctrl.Result{RequeueAfter: readinessPollInterval}, nil
+		}
+	}
+
+	if source.snapshots != nil {
+		scaled, err := scaleDeployment(r.Client, ctx, log, restore.Namespace, childName(wordpress, "wordpress-mysql"), 0)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !scaled {
+			return ctrl.Result{RequeueAfter: readinessPollInterval}, nil
+		}
+	}
+
+	return ctrl.Result{Requeue: true}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseRestoring, "")
+}
+
+func runRestoreJob(r *WordpressRestoreReconciler, ctx context.Context, log logr.Logger, restore *wordpressv1.WordpressRestore) (ctrl.Result, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: restoreJobName(restore), Namespace: restore.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	if errors.IsNotFound(err) {
+		source, pending, err := resolveRestoreSource(r, ctx, restore)
+		if err != nil || pending != "" {
+			message := pending
+			if err != nil {
+				message = err.Error()
+			}
+			return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed, message)
+		}
+
+		if len(source.keys) > 0 && r.OperatorImage == "" {
+			return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed,
+				"decryption needs the operator image; start the manager with --operator-image")
+		}
+
+		wordpress := &wordpressv1.Wordpress{}
+		err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.WordpressRef, Namespace: restore.Namespace}, wordpress)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed,
+					fmt.Sprintf("Wordpress %s not found", restore.Spec.WordpressRef))
+			}
+			return ctrl.Result{}, err
+		}
+
+		job = newRestoreJob(restore, wordpress, source, r.OperatorImage)
+		if err := controllerutil.SetControllerReference(restore, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create restore Job", "job.name", job.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("Returned custom restore Job object", "job.name", job.Name)
+		restore.Status.JobName = job.Name
+		return ctrl.Result{}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseRestoring, "")
+	}
+
+	if jobFailed(job) {
+		log.Info("Restore Job failed", "job.name", job.Name)
+		return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed, fmt.Sprintf("Job %s failed", job.Name))
+	}
+	if job.Status.Succeeded == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	return ctrl.Result{Requeue: true}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseScalingUp, "")
+}
+
+// restoreSnapshots replaces both claims of the instance with ones provisioned
+// from the snapshots. Claims provisioned for this restore carry its label, so
+// they are not replaced again.
+func restoreSnapshots(r *WordpressRestoreReconciler, ctx context.Context, log logr.Logger, restore *wordpressv1.WordpressRestore, snapshots *wordpressv1.BackupSnapshots) (ctrl.Result, error) {
+	wordpress := &wordpressv1.Wordpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.WordpressRef, Namespace: restore.Namespace}, wordpress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed,
+				fmt.Sprintf("Wordpress %s not found", restore.Spec.WordpressRef))
+		}
+		return ctrl.Result{}, err
+	}
+
+	restored := true
+	for _, claim := range []struct{ kind, snapshot string }{
+		{"mysql", snapshots.Database},
+		{"wp", snapshots.Content},
+	} {
+		pvc := &v1.PersistentVolumeClaim{}
+		err := r.Get(ctx, types.NamespacedName{Name: childName(wordpress, claim.kind+"-pv-claim"), Namespace: restore.Namespace}, pvc)
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		if err == nil {
+			if pvc.Labels["wordpress.example.com/restore"] == restore.Name {
+				continue
+			}
+			restored = false
+			if pvc.DeletionTimestamp == nil {
+				err = r.Delete(ctx, pvc)
+				if err != nil {
+					log.Error(err, "Failed to delete PVC", "pvc.name", pvc.Name)
+					return ctrl.Result{}, err
+				}
+				log.Info("Deleted PVC for snapshot restore", "pvc.name", pvc.Name)
+			}
+			continue
+		}
+
+		state, err := getSnapshotState(r.Client, ctx, restore.Namespace, claim.snapshot)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return scaleUpAfterRestore(r, ctx, log, restore, wordpressv1.RestorePhaseFailed,
+					fmt.Sprintf("VolumeSnapshot %s not found", claim.snapshot))
+			}
+			return ctrl.Result{}, err
+		}
+
+		pvc = newPVCFromSnapshot(wordpress, claim.kind, claim.snapshot, state.restoreSize)
+		pvc.Labels["wordpress.example.com/restore"] = restore.Name
+		if err := controllerutil.SetControllerReference(wordpress, pvc, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.Create(ctx, pvc)
+		if err != nil {
+			log.Error(err, "Failed to create PVC from snapshot", "pvc.name", pvc.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("Returned custom PVC object from snapshot", "pvc.name", pvc.Name, "volumesnapshot.name", claim.snapshot)
+	}
+
+	if !restored {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, nil
+	}
+	return ctrl.Result{Requeue: true}, setRestorePhase(r, ctx, restore, wordpressv1.RestorePhaseScalingUp, "")
+}
+
+// scaleDeployment sets the replica count of the named Deployment and reports
+// whether it has been reached.
+func scaleDeployment(c client.Client, ctx context.Context, log logr.Logger, namespace, name string, replicas int32) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != replicas {
+		deployment.Spec.Replicas = &replicas
+		err = c.Update(ctx, deployment)
+		if err != nil {
+			log.Error(err, "Failed to scale Deployment", "deployment.name", name)
+			return false, err
+		}
+		log.Info("Scaled Deployment", "deployment.name", name, "replicas", replicas)
+	}
+	return deployment.Status.Replicas == replicas, nil
+}
+
+// scaleUpAfterRestore scales the frontend back to the recorded replica count,
+// resumes an instance paused for a snapshot restore and moves the restore to
+// its final phase.
+func scaleUpAfterRestore(r *WordpressRestoreReconciler, ctx context.Context, log logr.Logger, restore *wordpressv1.WordpressRestore, phase wordpressv1.RestorePhase, message string) (ctrl.Result, error) {
+	wordpress := &wordpressv1.Wordpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.WordpressRef, Namespace: restore.Namespace}, wordpress)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if err == nil && wordpress.Annotations[wordpressv1.AnnotationRestoring] == restore.Name {
+		if _, err := scaleDeployment(r.Client, ctx, log, restore.Namespace, childName(wordpress, "wordpress-mysql"), 1); err != nil {
+			return ctrl.Result{}, err
+		}
+		delete(wordpress.Annotations, wordpressv1.AnnotationRestoring)
+		err = r.Update(ctx, wordpress)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Resumed Wordpress after snapshot restore", "wordpress.name", wordpress.Name)
+	}
+
+	// The frontend of a deleted instance is gone along with it.
+	if err == nil && restore.Status.FrontendReplicas != nil {
+		deployment := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Name: childName(wordpress, "wordpress"), Namespace: restore.Namespace}, deployment)
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if err == nil && (deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != *restore.Status.FrontendReplicas) {
+			deployment.Spec.Replicas = restore.Status.FrontendReplicas
+			err = r.Update(ctx, deployment)
+			if err != nil {
+				log.Error(err, "Failed to scale up Wordpress Deployment", "deployment.name", deployment.Name)
+				return ctrl.Result{}, err
+			}
+			log.Info("Scaled up Wordpress Deployment after restore", "deployment.name", deployment.Name)
+		}
+	}
+
+	if err == nil && phase == wordpressv1.RestorePhaseCompleted {
+		purgePageCache(r.Client, ctx, log, wordpress)
+	}
+
+	now := metav1.Now()
+	restore.Status.CompletionTime = &now
+	return ctrl.Result{}, setRestorePhase(r, ctx, restore, phase, message)
+}
+
+// setRestorePending records why the restore cannot start yet, skipping the
+// status update when nothing changed.
+func setRestorePending(r *WordpressRestoreReconciler, ctx context.Context, restore *wordpressv1.WordpressRestore, message string) error {
+	if restore.Status.Phase == wordpressv1.RestorePhasePending && restore.Status.Message == message {
+		return nil
+	}
+	return setRestorePhase(r, ctx, restore, wordpressv1.RestorePhasePending, message)
+}
+
+// setRestorePhase records the phase and message along with any other status
+// fields set by the caller.
+func setRestorePhase(r *WordpressRestoreReconciler, ctx context.Context, restore *wordpressv1.WordpressRestore, phase wordpressv1.RestorePhase, message string) error {
+	restore.Status.Phase = phase
+	restore.Status.Message = message
+	return r.Status().Update(ctx, restore)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WordpressRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&wordpressv1.WordpressRestore{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
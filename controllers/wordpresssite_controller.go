@@ -0,0 +1,348 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// siteFinalizer holds a WordpressSite until its sub-site has been deleted from
+// the network
+const siteFinalizer = "wordpress.example.com/site"
+
+// siteScript creates the sub-site at SLUG, or at MAPPED_DOMAIN when set, or
+// moves, renames and archives or unarchives the sub-site BLOG_ID. With
+// REMOVE set it deletes the sub-site and its tables instead. The ID and URL
+// of the sub-site, 0 once deleted, are reported through the termination
+// message.
+const siteScript = `<?php
+require_once ABSPATH . 'wp-admin/includes/ms.php';
+
+function fail( $message ) {
+	file_put_contents( '/dev/termination-log', $message );
+	WP_CLI::error( $message );
+}
+
+function finish( $id, $url ) {
+	file_put_contents( '/dev/termination-log', json_encode( array( 'id' => $id, 'url' => $url ) ) );
+}
+
+$network = get_network();
+$slug    = getenv( 'SLUG' );
+if ( '' !== getenv( 'MAPPED_DOMAIN' ) ) {
+	$domain = getenv( 'MAPPED_DOMAIN' );
+	$path   = '/';
+} elseif ( is_subdomain_install() ) {
+	$domain = $slug . '.' . $network->domain;
+	$path   = $network->path;
+} else {
+	$domain = $network->domain;
+	$path   = $network->path . $slug . '/';
+}
+
+// The sub-site is found by its ID first, so that it can be moved.
+$id = (int) getenv( 'BLOG_ID' );
+if ( $id && ! get_site( $id ) ) {
+	$id = 0;
+}
+if ( ! $id ) {
+	$id = (int) get_blog_id_from_url( $domain, $path );
+}
+if ( $id && is_main_site( $id ) ) {
+	fail( 'the address of the sub-site is the main site of the network' );
+}
+
+if ( 'true' === getenv( 'REMOVE' ) ) {
+	if ( $id ) {
+		wpmu_delete_blog( $id, true );
+	}
+	finish( 0, '' );
+	return;
+}
+
+$taken = (int) get_blog_id_from_url( $domain, $path );
+if ( $taken && $taken !== $id ) {
+	fail( $domain . $path . ' is taken by sub-site ' . $taken );
+}
+
+if ( ! $id ) {
+	$email = getenv( 'ADMIN_EMAIL' );
+	if ( '' === $email ) {
+		$admins = get_super_admins();
+		$user   = get_user_by( 'login', reset( $admins ) );
+	} else {
+		$user = get_user_by( 'email', $email );
+		if ( ! $user ) {
+			$user_id = wpmu_create_user( sanitize_user( strtok( $email, '@' ), true ), wp_generate_password( 24 ), $email );
+			if ( ! $user_id ) {
+				fail( 'creating the administrator ' . $email . ' failed' );
+			}
+			$user = get_user_by( 'id', $user_id );
+		}
+	}
+	if ( ! $user ) {
+		fail( 'the network has no administrator' );
+	}
+	$id = wpmu_create_blog( $domain, $path, getenv( 'TITLE' ), $user->ID, array( 'public' => 1 ), $network->id );
+	if ( is_wp_error( $id ) ) {
+		fail( 'creating the sub-site failed: ' . $id->get_error_message() );
+	}
+} else {
+	$site = get_site( $id );
+	if ( $site->domain !== $domain || $site->path !== $path ) {
+		$url = parse_url( get_blog_option( $id, 'home' ), PHP_URL_SCHEME ) . '://' . $domain . untrailingslashit( $path );
+		update_blog_details( $id, array( 'domain' => $domain, 'path' => $path ) );
+		update_blog_option( $id, 'home', $url );
+		update_blog_option( $id, 'siteurl', $url );
+	}
+	update_blog_option( $id, 'blogname', getenv( 'TITLE' ) );
+}
+update_blog_status( $id, 'archived', 'true' === getenv( 'ARCHIVED' ) ? '1' : '0' );
+finish( $id, get_home_url( $id ) );
+`
+
+// siteResult is the termination message of a site Job
+type siteResult struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+}
+
+// WordpressSiteReconciler reconciles a WordpressSite object
+type WordpressSiteReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresssites,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresssites/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresssites/finalizers,verbs=update
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile runs a wp-cli Job creating or updating the sub-site on the network
+// of the referenced instance whenever the spec changes, and again every
+// extensionResyncInterval, which undoes changes made in the network admin.
+// An address is managed by the oldest WordpressSite claiming it; the others
+// are reported in conflict. Deleting a WordpressSite deletes its sub-site.
+func (r *WordpressSiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("wordpresssite", req.NamespacedName)
+
+	site := &wordpressv1.WordpressSite{}
+	err := r.Get(ctx, req.NamespacedName, site)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !site.DeletionTimestamp.IsZero() {
+		return removeSite(r, ctx, log, site)
+	}
+	if added, err := addManagedFinalizer(r.Client, ctx, managedSite{site}); added || err != nil {
+		return ctrl.Result{}, err
+	}
+
+	wordpress, pending, err := networkWordpress(r.Client, ctx, site)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedSite{site}, phasePending, pending)
+	}
+
+	// Sub-sites conflict over their slug, or their domain when mapped.
+	owner, err := managedOwner(r.Client, ctx, managedSite{site}, &wordpressv1.WordpressSiteList{}, func(item runtime.Object) bool {
+		other := item.(*wordpressv1.WordpressSite)
+		return other.Spec.WordpressRef == site.Spec.WordpressRef &&
+			(other.Spec.Slug == site.Spec.Slug || (site.Spec.Domain != "" && other.Spec.Domain == site.Spec.Domain))
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if owner != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedSite{site}, phaseConflict,
+			fmt.Sprintf("The address of the sub-site on Wordpress %s is managed by WordpressSite %s", wordpress.Name, owner))
+	}
+	return syncSite(r, ctx, log, site, wordpress)
+}
+
+// managedSite adapts a WordpressSite to managedObject.
+type managedSite struct {
+	*wordpressv1.WordpressSite
+}
+
+func (s managedSite) object() client.Object { return s.WordpressSite }
+func (s managedSite) kind() string          { return "WordpressSite" }
+func (s managedSite) what() string          { return "sub-site" }
+func (s managedSite) finalizer() string     { return siteFinalizer }
+func (s managedSite) phase() string         { return string(s.Status.Phase) }
+func (s managedSite) jobName() string       { return s.Status.JobName }
+func (s managedSite) status() interface{}   { return s.Status }
+func (s managedSite) fresh() managedObject  { return managedSite{&wordpressv1.WordpressSite{}} }
+
+func (s managedSite) setPhase(phase, message string) {
+	s.Status.Phase = wordpressv1.SitePhase(phase)
+	s.Status.Message = message
+}
+
+func (s managedSite) setJobName(name string) {
+	s.Status.JobName = name
+}
+
+// networkWordpress returns the instance of a WordpressSite. A non-empty
+// pending message means the instance is not a ready network.
+func networkWordpress(c client.Client, ctx context.Context, site *wordpressv1.WordpressSite) (*wordpressv1.Wordpress, string, error) {
+	wordpress, pending, err := referencedWordpress(c, ctx, site.Namespace, site.Spec.WordpressRef)
+	if err != nil || pending != "" {
+		return wordpress, pending, err
+	}
+	if wordpress.Spec.Multisite == nil {
+		return wordpress, fmt.Sprintf("Wordpress %s has no spec.multisite", wordpress.Name), nil
+	}
+	if !meta.IsStatusConditionTrue(wordpress.Status.Conditions, wordpressv1.ConditionMultisite) {
+		return wordpress, fmt.Sprintf("Waiting for Multisite to be enabled on Wordpress %s", wordpress.Name), nil
+	}
+	return wordpress, "", nil
+}
+
+// syncSite runs the Job bringing the sub-site to its declared state and
+// records its outcome.
+func syncSite(r *WordpressSiteReconciler, ctx context.Context, log logr.Logger, site *wordpressv1.WordpressSite, wordpress *wordpressv1.Wordpress) (ctrl.Result, error) {
+	name := siteJobName(site)
+	job, resync, err := runManagedJob(r.Client, r.Scheme, ctx, log, managedSite{site}, name, func() *batchv1.Job {
+		return newSiteJob(wordpress, site, name, false)
+	})
+	if job == nil || err != nil {
+		return resync, err
+	}
+
+	if jobFailed(job) {
+		message, err := managedJobFailure(r.Client, ctx, job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return resync, setManagedPhase(r.Client, ctx, managedSite{site}, phaseFailed, message)
+	}
+	result, err := readSiteResult(r.Client, ctx, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	site.Status.BlogID = result.ID
+	site.Status.URL = result.URL
+	site.Status.LastSyncTime = jobFinishedAt(job)
+	return resync, setManagedPhase(r.Client, ctx, managedSite{site}, phaseSynced, "")
+}
+
+// removeSite deletes the sub-site of a deleted WordpressSite, see
+// removeManaged.
+func removeSite(r *WordpressSiteReconciler, ctx context.Context, log logr.Logger, site *wordpressv1.WordpressSite) (ctrl.Result, error) {
+	wordpress, pending, err := networkWordpress(r.Client, ctx, site)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	newJob := func(name string) *batchv1.Job {
+		return newSiteJob(wordpress, site, name, true)
+	}
+	return removeManaged(r.Client, r.Scheme, ctx, log, managedSite{site}, wordpress, pending, newJob, nil)
+}
+
+// siteJobName names the sync Job after the spec, so that a change to it
+// starts a new sync.
+func siteJobName(site *wordpressv1.WordpressSite) string {
+	data, _ := json.Marshal(site.Spec)
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-site-%x", site.Name, sum[:5])
+}
+
+func readSiteResult(c client.Client, ctx context.Context, job *batchv1.Job) (*siteResult, error) {
+	message, err := jobTerminationMessage(c, ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	result := &siteResult{}
+	if err := json.Unmarshal([]byte(message), result); err != nil {
+		return nil, fmt.Errorf("invalid result of job %s: %v", job.Name, err)
+	}
+	return result, nil
+}
+
+// newSiteJob returns the Job running siteScript with wp-cli against the
+// network of the running instance.
+func newSiteJob(wordpress *wordpressv1.Wordpress, site *wordpressv1.WordpressSite, name string, remove bool) *batchv1.Job {
+	job := newWPCLIJob(wordpress, name, "site", wpCLIEvalFile("site", siteScript), []v1.EnvVar{
+		{Name: "SLUG", Value: site.Spec.Slug},
+		{Name: "TITLE", Value: site.Spec.Title},
+		{Name: "ADMIN_EMAIL", Value: site.Spec.AdminEmail},
+		{Name: "MAPPED_DOMAIN", Value: site.Spec.Domain},
+		{Name: "ARCHIVED", Value: fmt.Sprint(site.Spec.Archived)},
+		{Name: "BLOG_ID", Value: fmt.Sprint(site.Status.BlogID)},
+		{Name: "REMOVE", Value: fmt.Sprint(remove)},
+	})
+	setWPCLIJobLabel(job, labelSync, "wordpresssite")
+	return job
+}
+
+// sitesOfWordpress requeues the WordpressSites of an instance when it
+// changes.
+func (r *WordpressSiteReconciler) sitesOfWordpress(object client.Object) []reconcile.Request {
+	list := &wordpressv1.WordpressSiteList{}
+	err := r.List(context.Background(), list, client.InNamespace(object.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list WordpressSites", "namespace", object.GetNamespace())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, site := range list.Items {
+		if site.Spec.WordpressRef == object.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: site.Name, Namespace: site.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WordpressSiteReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&wordpressv1.WordpressSite{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &wordpressv1.Wordpress{}}, handler.EnqueueRequestsFromMapFunc(r.sitesOfWordpress)).
+		Complete(r)
+}
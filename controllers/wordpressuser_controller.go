@@ -0,0 +1,374 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// userFinalizer holds a WordpressUser until its account has been deleted from
+// the instance
+const userFinalizer = "wordpress.example.com/user"
+
+// userScript creates the account LOGIN, or updates its email, display name
+// and role, and sets its password when RESET_PASSWORD is true. With REMOVE
+// set it deletes the account instead, giving its posts to REASSIGN_TO. The
+// ID of the account, 0 once deleted, is reported through the termination
+// message. The password is passed on stdin rather than as an argument, which
+// would show it in the process list.
+const userScript = wpCLIPrelude + `
+id=$(wp user get "$LOGIN" --field=ID 2>/dev/null || true)
+
+if [ "$REMOVE" = true ]; then
+  if [ -n "$id" ]; then
+    if [ -n "$REASSIGN_TO" ]; then
+      to=$(wp user get "$REASSIGN_TO" --field=ID 2>/dev/null) || fail "account $REASSIGN_TO to reassign the posts to not found"
+      wp user delete "$id" --reassign="$to" --yes || fail "deleting $LOGIN failed"
+    else
+      posts=$(wp post list --author="$id" --post_type=any --post_status=any --format=count)
+      [ "$posts" = 0 ] || fail "$LOGIN has $posts posts; set spec.reassignPostsTo to keep them"
+      wp user delete "$id" --yes || fail "deleting $LOGIN failed"
+    fi
+  fi
+  printf '{"id":0}' > /dev/termination-log
+  exit 0
+fi
+
+display_name=${DISPLAY_NAME:-$LOGIN}
+if [ -z "$id" ]; then
+  id=$(printf '%s\n' "$PASSWORD" | wp user create "$LOGIN" "$EMAIL" --role="$ROLE" \
+    --display_name="$display_name" --porcelain --prompt=user_pass) || fail "creating $LOGIN failed"
+else
+  wp user update "$id" --user_email="$EMAIL" --display_name="$display_name" --role="$ROLE" \
+    --skip-email || fail "updating $LOGIN failed"
+  if [ "$RESET_PASSWORD" = true ]; then
+    printf '%s\n' "$PASSWORD" | wp user update "$id" --skip-email --prompt=user_pass ||
+      fail "resetting the password of $LOGIN failed"
+  fi
+fi
+printf '{"id":%s}' "$id" > /dev/termination-log
+`
+
+// userResult is the termination message of a user Job
+type userResult struct {
+	ID int64 `json:"id"`
+}
+
+// WordpressUserReconciler reconciles a WordpressUser object
+type WordpressUserReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressusers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressusers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpressusers/finalizers,verbs=update
+// +kubebuilder:rbac:groups=wordpress.example.com,resources=wordpresses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create
+
+// Reconcile runs a wp-cli Job creating or updating the account on the
+// referenced instance whenever the spec or the password Secret changes, and
+// again every extensionResyncInterval, which undoes changes made in
+// wp-admin. A login is managed by the oldest WordpressUser claiming it; the
+// others are reported in conflict. Deleting a WordpressUser deletes its
+// account.
+func (r *WordpressUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("wordpressuser", req.NamespacedName)
+
+	user := &wordpressv1.WordpressUser{}
+	err := r.Get(ctx, req.NamespacedName, user)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !user.DeletionTimestamp.IsZero() {
+		return removeUser(r, ctx, log, user)
+	}
+	if added, err := addManagedFinalizer(r.Client, ctx, managedUser{user}); added || err != nil {
+		return ctrl.Result{}, err
+	}
+
+	wordpress, pending, err := referencedWordpress(r.Client, ctx, user.Namespace, user.Spec.WordpressRef)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedUser{user}, phasePending, pending)
+	}
+
+	owner, err := managedOwner(r.Client, ctx, managedUser{user}, &wordpressv1.WordpressUserList{}, func(item runtime.Object) bool {
+		other := item.(*wordpressv1.WordpressUser)
+		return other.Spec.WordpressRef == user.Spec.WordpressRef && other.Spec.Login == user.Spec.Login
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if owner != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedUser{user}, phaseConflict,
+			fmt.Sprintf("Account %s of Wordpress %s is managed by WordpressUser %s", user.Spec.Login, wordpress.Name, owner))
+	}
+
+	secret, pending, err := userPasswordSecret(r, ctx, log, user)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending != "" {
+		return ctrl.Result{RequeueAfter: readinessPollInterval}, setManagedPhase(r.Client, ctx, managedUser{user}, phasePending, pending)
+	}
+	return syncUser(r, ctx, log, user, wordpress, secret)
+}
+
+// managedUser adapts a WordpressUser to managedObject.
+type managedUser struct {
+	*wordpressv1.WordpressUser
+}
+
+func (u managedUser) object() client.Object { return u.WordpressUser }
+func (u managedUser) kind() string          { return "WordpressUser" }
+func (u managedUser) what() string          { return "account" }
+func (u managedUser) finalizer() string     { return userFinalizer }
+func (u managedUser) phase() string         { return string(u.Status.Phase) }
+func (u managedUser) jobName() string       { return u.Status.JobName }
+func (u managedUser) status() interface{}   { return u.Status }
+func (u managedUser) fresh() managedObject  { return managedUser{&wordpressv1.WordpressUser{}} }
+
+func (u managedUser) setPhase(phase, message string) {
+	u.Status.Phase = wordpressv1.UserPhase(phase)
+	u.Status.Message = message
+}
+
+func (u managedUser) setJobName(name string) {
+	u.Status.JobName = name
+}
+
+// userPasswordSecret returns the Secret holding the password of the account,
+// generating it when spec.passwordSecretRef is empty. A non-empty pending
+// message means the Secret is not usable yet.
+func userPasswordSecret(r *WordpressUserReconciler, ctx context.Context, log logr.Logger, user *wordpressv1.WordpressUser) (*v1.Secret, string, error) {
+	name := user.Name + "-password"
+	if ref := user.Spec.PasswordSecretRef; ref != nil {
+		name = ref.Name
+	}
+
+	secret := &v1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: user.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, "", err
+	}
+	if errors.IsNotFound(err) {
+		if user.Spec.PasswordSecretRef != nil {
+			return nil, fmt.Sprintf("Secret %s not found", name), nil
+		}
+		password, err := generatePassword()
+		if err != nil {
+			return nil, "", err
+		}
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: user.Namespace,
+			},
+			Type: "Opaque",
+			Data: map[string][]byte{
+				"password": []byte(password),
+			},
+		}
+		if err := controllerutil.SetControllerReference(user, secret, r.Scheme); err != nil {
+			return nil, "", err
+		}
+		err = r.Create(ctx, secret)
+		if err != nil {
+			log.Error(err, "Failed to create password Secret", "secret.name", name)
+			return nil, "", err
+		}
+		log.Info("Returned custom password Secret object", "secret.name", name)
+	}
+
+	if len(secret.Data["password"]) == 0 {
+		return nil, fmt.Sprintf("Secret %s has no password key", name), nil
+	}
+	return secret, "", nil
+}
+
+// syncUser runs the Job bringing the account to its declared state and
+// records its outcome. The password is only set when the Secret changed since
+// it was last set, since setting it logs the account out.
+func syncUser(r *WordpressUserReconciler, ctx context.Context, log logr.Logger, user *wordpressv1.WordpressUser, wordpress *wordpressv1.Wordpress, secret *v1.Secret) (ctrl.Result, error) {
+	name := userJobName(user, secret)
+	job, resync, err := runManagedJob(r.Client, r.Scheme, ctx, log, managedUser{user}, name, func() *batchv1.Job {
+		reset := user.Status.PasswordVersion != secret.ResourceVersion
+		return newUserJob(wordpress, user, name, secret.Name, reset, false)
+	})
+	if job == nil || err != nil {
+		return resync, err
+	}
+
+	if jobFailed(job) {
+		message, err := managedJobFailure(r.Client, ctx, job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return resync, setManagedPhase(r.Client, ctx, managedUser{user}, phaseFailed, message)
+	}
+	result, err := readUserResult(r.Client, ctx, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	user.Status.UserID = result.ID
+	user.Status.PasswordSecret = secret.Name
+	if jobEnv(job, "RESET_PASSWORD") == "true" {
+		user.Status.PasswordVersion = secret.ResourceVersion
+	}
+	user.Status.LastSyncTime = jobFinishedAt(job)
+	return resync, setManagedPhase(r.Client, ctx, managedUser{user}, phaseSynced, "")
+}
+
+// removeUser deletes the account of a deleted WordpressUser, see
+// removeManaged.
+func removeUser(r *WordpressUserReconciler, ctx context.Context, log logr.Logger, user *wordpressv1.WordpressUser) (ctrl.Result, error) {
+	wordpress, pending, err := referencedWordpress(r.Client, ctx, user.Namespace, user.Spec.WordpressRef)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	newJob := func(name string) *batchv1.Job {
+		return newUserJob(wordpress, user, name, "", false, true)
+	}
+	return removeManaged(r.Client, r.Scheme, ctx, log, managedUser{user}, wordpress, pending, newJob, nil)
+}
+
+// userJobName names the sync Job after the spec and the version of the
+// password Secret, so that a change to either starts a new sync.
+func userJobName(user *wordpressv1.WordpressUser, secret *v1.Secret) string {
+	data, _ := json.Marshal(struct {
+		Spec            wordpressv1.WordpressUserSpec
+		PasswordVersion string
+	}{user.Spec, secret.ResourceVersion})
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s-user-%x", user.Name, sum[:5])
+}
+
+func readUserResult(c client.Client, ctx context.Context, job *batchv1.Job) (*userResult, error) {
+	message, err := jobTerminationMessage(c, ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	result := &userResult{}
+	if err := json.Unmarshal([]byte(message), result); err != nil {
+		return nil, fmt.Errorf("invalid result of job %s: %v", job.Name, err)
+	}
+	return result, nil
+}
+
+// jobEnv returns the value of an environment variable of the first container
+// of a Job.
+func jobEnv(job *batchv1.Job, name string) string {
+	for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+// newUserJob returns the Job running userScript with wp-cli against the
+// running instance. The password is read from passwordSecret unless the
+// account is removed.
+func newUserJob(wordpress *wordpressv1.Wordpress, user *wordpressv1.WordpressUser, name, passwordSecret string, reset, remove bool) *batchv1.Job {
+	env := []v1.EnvVar{
+		{Name: "LOGIN", Value: user.Spec.Login},
+		{Name: "EMAIL", Value: user.Spec.Email},
+		{Name: "ROLE", Value: string(user.Spec.Role)},
+		{Name: "DISPLAY_NAME", Value: user.Spec.DisplayName},
+		{Name: "REASSIGN_TO", Value: user.Spec.ReassignPostsTo},
+		{Name: "RESET_PASSWORD", Value: fmt.Sprint(reset)},
+		{Name: "REMOVE", Value: fmt.Sprint(remove)},
+	}
+	if !remove {
+		env = append(env, v1.EnvVar{
+			Name: "PASSWORD",
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: passwordSecret},
+					Key:                  "password",
+				},
+			},
+		})
+	}
+
+	job := newWPCLIJob(wordpress, name, "user", userScript, env)
+	setWPCLIJobLabel(job, labelSync, "wordpressuser")
+	return job
+}
+
+// usersOf requeues the WordpressUsers of an instance, or those whose
+// password is held by a Secret, when it changes.
+func (r *WordpressUserReconciler) usersOf(object client.Object) []reconcile.Request {
+	list := &wordpressv1.WordpressUserList{}
+	err := r.List(context.Background(), list, client.InNamespace(object.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list WordpressUsers", "namespace", object.GetNamespace())
+		return nil
+	}
+
+	_, isSecret := object.(*v1.Secret)
+	requests := []reconcile.Request{}
+	for _, user := range list.Items {
+		if (isSecret && user.Status.PasswordSecret == object.GetName()) ||
+			(isSecret && user.Spec.PasswordSecretRef != nil && user.Spec.PasswordSecretRef.Name == object.GetName()) ||
+			(!isSecret && user.Spec.WordpressRef == object.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: user.Name, Namespace: user.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WordpressUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&wordpressv1.WordpressUser{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &wordpressv1.Wordpress{}}, handler.EnqueueRequestsFromMapFunc(r.usersOf)).
+		Watches(&source.Kind{Type: &v1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.usersOf)).
+		Complete(r)
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	wordpressv1 "wordpress-operator/api/v1"
+)
+
+// wpCLIPrelude starts the shell scripts of wp-cli Jobs. fail reports its
+// argument through the termination message, where the controllers pick it
+// up, and exits.
+const wpCLIPrelude = `set -eu
+fail() {
+  printf '%s' "$1" > /dev/termination-log
+  echo "$1" >&2
+  exit 1
+}
+`
+
+// wpCLIEvalFile returns the shell script running the PHP script with
+// wp eval-file, for Jobs that are easier written against the WordPress API.
+func wpCLIEvalFile(name, script string) string {
+	file := "/tmp/" + name + ".php"
+	return "cat > " + file + " <<'PHP'\n" + script + "PHP\nexec wp eval-file " + file + "\n"
+}
+
+// newWPCLIJob returns the Job running script with wp-cli against the running
+// instance. Callers add labels, volumes and mounts of their own to the
+// returned Job.
+func newWPCLIJob(wordpress *wordpressv1.Wordpress, name, container, script string, env []v1.EnvVar) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: wordpress.Namespace,
+			Labels:    wpCLIJobLabels(wordpress),
+		},
+		Spec: wpCLIJobSpec(wordpress, container, script, env),
+	}
+}
+
+func wpCLIJobLabels(wordpress *wordpressv1.Wordpress) map[string]string {
+	return map[string]string{
+		"app":                     "wordpress",
+		wordpressv1.LabelInstance: wordpress.Name,
+	}
+}
+
+// wpCLIJobSpec returns the spec of a Job running script in a wp-cli
+// container named container. The pod runs as www-data next to the frontend,
+// whose wp-pv-claim it mounts, and is not retried.
+func wpCLIJobSpec(wordpress *wordpressv1.Wordpress, container, script string, env []v1.EnvVar) batchv1.JobSpec {
+	backoffLimit := int32(0)
+	runAsUser := wwwDataUID
+
+	return batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: v1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: wpCLIJobLabels(wordpress),
+			},
+			Spec: v1.PodSpec{
+				RestartPolicy: v1.RestartPolicyNever,
+				Affinity:      frontendNodeAffinity(wordpress),
+				Containers: []v1.Container{
+					{
+						Image:   wpCLIImage,
+						Name:    container,
+						Command: []string{"sh", "-c", script},
+						Env:     env,
+						SecurityContext: &v1.SecurityContext{
+							RunAsUser: &runAsUser,
+						},
+						VolumeMounts: []v1.VolumeMount{
+							{
+								Name:      "wordpress-persistent-storage",
+								MountPath: "/var/www/html",
+							},
+						},
+					},
+				},
+				Volumes: []v1.Volume{
+					{
+						Name: "wordpress-persistent-storage",
+						VolumeSource: v1.VolumeSource{
+							PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+								ClaimName: childName(wordpress, "wp-pv-claim"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// setWPCLIJobLabel sets a label on the Job and its pods.
+func setWPCLIJobLabel(job *batchv1.Job, key, value string) {
+	job.Labels[key] = value
+	job.Spec.Template.Labels[key] = value
+}
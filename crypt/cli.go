@@ -0,0 +1,117 @@
+package crypt
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Main runs the crypt command with args and returns the exit code:
+//
+//	crypt encrypt --keys DIR --key-id ID < plaintext > artifact
+//	crypt decrypt --keys DIR < artifact > plaintext
+//	crypt install PATH
+//
+// DIR holds a file per key, named after its ID, as mounted from a Secret.
+// install copies the running binary to PATH so that Jobs can run it in
+// images other than the operator's.
+func Main(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: crypt encrypt|decrypt|install")
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "encrypt", "decrypt":
+		flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+		keysDir := flags.String("keys", "", "Directory holding a file per key, named after the key ID.")
+		keyID := flags.String("key-id", "", "ID of the key to encrypt with.")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		keys := dirKeys(*keysDir)
+		if args[0] == "decrypt" {
+			err = Decrypt(os.Stdout, os.Stdin, keys)
+			break
+		}
+		var key []byte
+		if key, err = keys(*keyID); err == nil {
+			err = Encrypt(os.Stdout, os.Stdin, *keyID, key)
+		}
+	case "install":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: crypt install PATH")
+			return 2
+		}
+		err = install(args[1])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown crypt command %q\n", args[0])
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "crypt %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// ParseKey accepts a key as raw bytes or base64.
+func ParseKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, raw or base64 encoded", KeySize)
+	}
+	return key, nil
+}
+
+func dirKeys(dir string) KeyFunc {
+	return func(keyID string) ([]byte, error) {
+		if keyID == "" || strings.ContainsAny(keyID, "/\x00") || keyID == "." || keyID == ".." {
+			return nil, fmt.Errorf("invalid key ID %q", keyID)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, keyID))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("key %q not found", keyID)
+			}
+			return nil, err
+		}
+		key, err := ParseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", keyID, err)
+		}
+		return key, nil
+	}
+}
+
+func install(dest string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(self)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
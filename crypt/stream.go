@@ -0,0 +1,195 @@
+// Package crypt implements the client-side encryption of backup artifacts.
+//
+// An encrypted artifact starts with a header naming the key it was encrypted
+// with and a random salt. A per-artifact key is derived from the named key
+// and the salt with HKDF-SHA256, and the payload is split into 64 KiB chunks
+// sealed with AES-256-GCM. The nonce of a chunk is its counter followed by a
+// flag marking the last chunk, so that chunks cannot be reordered, dropped or
+// truncated without decryption failing.
+package crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	magic     = "WPCRYPT1"
+	saltSize  = 16
+	chunkSize = 64 * 1024
+	// KeySize is the size of an encryption key in bytes
+	KeySize = 32
+
+	hkdfInfo = "wordpress-operator backup artifact"
+)
+
+// Encrypt reads plaintext from r and writes the encrypted artifact to w,
+// using key, identified by keyID.
+func Encrypt(w io.Writer, r io.Reader, keyID string, key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("key %q must be %d bytes", keyID, KeySize)
+	}
+	if len(keyID) == 0 || len(keyID) > 255 {
+		return fmt.Errorf("key ID %q must be 1 to 255 bytes", keyID)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return err
+	}
+
+	header := append([]byte(magic), byte(len(keyID)))
+	header = append(header, keyID...)
+	header = append(header, salt...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, chunkSize)
+	buf := make([]byte, chunkSize)
+	out := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		// The chunk is the last one when the input ended within it or
+		// nothing follows it.
+		last := err != nil
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		out = aead.Seal(out[:0], chunkNonce(counter, last), buf[:n], nil)
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// KeyFunc returns the key with the given ID
+type KeyFunc func(keyID string) ([]byte, error)
+
+// ReadKeyID returns the ID of the key an artifact was encrypted with.
+func ReadKeyID(r io.Reader) (string, error) {
+	keyID, _, err := readHeader(r)
+	return keyID, err
+}
+
+// Decrypt reads an encrypted artifact from r and writes the plaintext to w,
+// looking up the key named in its header with keys. Nothing is written for a
+// chunk that fails to authenticate, but earlier chunks may have been written
+// already, so callers must treat the output as invalid on error.
+func Decrypt(w io.Writer, r io.Reader, keys KeyFunc) error {
+	keyID, salt, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	key, err := keys(keyID)
+	if err != nil {
+		return err
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("key %q must be %d bytes", keyID, KeySize)
+	}
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, chunkSize+aead.Overhead())
+	buf := make([]byte, chunkSize+aead.Overhead())
+	out := make([]byte, 0, chunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		out, err = aead.Open(out[:0], chunkNonce(counter, last), buf[:n], nil)
+		if err != nil {
+			return errors.New("artifact is corrupt, truncated or encrypted with a different key")
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func readHeader(r io.Reader) (string, []byte, error) {
+	prefix := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return "", nil, errors.New("artifact is not encrypted")
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return "", nil, errors.New("artifact is not encrypted")
+	}
+
+	rest := make([]byte, int(prefix[len(magic)])+saltSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return "", nil, errors.New("artifact header is truncated")
+	}
+	keyID := string(rest[:len(rest)-saltSize])
+	return keyID, rest[len(rest)-saltSize:], nil
+}
+
+func newAEAD(key, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(hkdf(key, salt, []byte(hkdfInfo)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the 11-byte big-endian chunk counter followed by the last
+// chunk flag.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// hkdf derives a KeySize key with HKDF-SHA256 (RFC 5869).
+func hkdf(secret, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:KeySize]
+}
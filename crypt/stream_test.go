@@ -0,0 +1,236 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+const testKeyID = "backup-key"
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func keyFunc(keys map[string][]byte) KeyFunc {
+	return func(keyID string) ([]byte, error) {
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", keyID)
+		}
+		return key, nil
+	}
+}
+
+func encrypt(t *testing.T, plaintext, key []byte) []byte {
+	var artifact bytes.Buffer
+	if err := Encrypt(&artifact, bytes.NewReader(plaintext), testKeyID, key); err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	return artifact.Bytes()
+}
+
+// headerSize is the size of the header written for testKeyID
+var headerSize = len(magic) + 1 + len(testKeyID) + saltSize
+
+// sealedChunkSize is the size of a full chunk once sealed
+const sealedChunkSize = chunkSize + 16
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{name: "empty", size: 0, chunks: 1},
+		{name: "one byte", size: 1, chunks: 1},
+		{name: "less than a chunk", size: chunkSize - 1, chunks: 1},
+		{name: "exactly one chunk", size: chunkSize, chunks: 1},
+		{name: "one byte over a chunk", size: chunkSize + 1, chunks: 2},
+		{name: "exactly three chunks", size: 3 * chunkSize, chunks: 3},
+		{name: "several chunks", size: 4*chunkSize + chunkSize/2, chunks: 5},
+	}
+
+	key := testKey(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := make([]byte, tt.size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatal(err)
+			}
+
+			artifact := encrypt(t, plaintext, key)
+			if want := headerSize + tt.size + tt.chunks*16; len(artifact) != want {
+				t.Errorf("artifact is %d bytes, want %d for %d chunks", len(artifact), want, tt.chunks)
+			}
+
+			keyID, err := ReadKeyID(bytes.NewReader(artifact))
+			if err != nil || keyID != testKeyID {
+				t.Errorf("ReadKeyID() = %q, %v, want %q", keyID, err, testKeyID)
+			}
+
+			var out bytes.Buffer
+			if err := Decrypt(&out, bytes.NewReader(artifact), keyFunc(map[string][]byte{testKeyID: key})); err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), plaintext) {
+				t.Errorf("Decrypt() returned %d bytes that differ from the %d bytes encrypted", out.Len(), len(plaintext))
+			}
+		})
+	}
+}
+
+func TestEncryptSaltsEachArtifact(t *testing.T) {
+	key := testKey(t)
+	plaintext := []byte("the same plaintext")
+	if bytes.Equal(encrypt(t, plaintext, key), encrypt(t, plaintext, key)) {
+		t.Error("Encrypt() returned the same artifact twice")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := testKey(t)
+	plaintext := make([]byte, 3*chunkSize+100)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	artifact := encrypt(t, plaintext, key)
+	chunk := func(i int) []byte {
+		start := headerSize + i*sealedChunkSize
+		end := start + sealedChunkSize
+		if end > len(artifact) {
+			end = len(artifact)
+		}
+		return artifact[start:end]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	header := artifact[:headerSize]
+
+	tests := []struct {
+		name     string
+		artifact []byte
+		keys     map[string][]byte
+	}{
+		{
+			name: "flipped ciphertext byte",
+			artifact: func() []byte {
+				tampered := join(artifact)
+				tampered[headerSize+sealedChunkSize+10] ^= 0x01
+				return tampered
+			}(),
+		},
+		{
+			name: "flipped salt byte",
+			artifact: func() []byte {
+				tampered := join(artifact)
+				tampered[headerSize-1] ^= 0x01
+				return tampered
+			}(),
+		},
+		{
+			name:     "truncated final chunk",
+			artifact: artifact[:len(artifact)-1],
+		},
+		{
+			name:     "final chunk dropped",
+			artifact: join(header, chunk(0), chunk(1), chunk(2)),
+		},
+		{
+			name:     "truncated within a chunk",
+			artifact: artifact[:headerSize+sealedChunkSize+100],
+		},
+		{
+			name:     "reordered chunks",
+			artifact: join(header, chunk(1), chunk(0), chunk(2), chunk(3)),
+		},
+		{
+			name:     "duplicated chunk",
+			artifact: join(header, chunk(0), chunk(0), chunk(1), chunk(2), chunk(3)),
+		},
+		{
+			name:     "trailing data",
+			artifact: join(artifact, []byte{0}),
+		},
+		{
+			name:     "wrong key",
+			artifact: artifact,
+			keys:     map[string][]byte{testKeyID: testKey(t)},
+		},
+		{
+			name:     "unknown key",
+			artifact: artifact,
+			keys:     map[string][]byte{"other-key": key},
+		},
+		{
+			name:     "not encrypted",
+			artifact: plaintext,
+		},
+		{
+			name:     "truncated header",
+			artifact: artifact[:headerSize-1],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := tt.keys
+			if keys == nil {
+				keys = map[string][]byte{testKeyID: key}
+			}
+			var out bytes.Buffer
+			if err := Decrypt(&out, bytes.NewReader(tt.artifact), keyFunc(keys)); err == nil {
+				t.Errorf("Decrypt() accepted the artifact and returned %d bytes", out.Len())
+			}
+		})
+	}
+}
+
+func TestEncryptRejectsInvalidKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		keyID string
+		key   []byte
+	}{
+		{name: "short key", keyID: testKeyID, key: make([]byte, KeySize-1)},
+		{name: "long key", keyID: testKeyID, key: make([]byte, KeySize+1)},
+		{name: "empty key ID", keyID: "", key: make([]byte, KeySize)},
+		{name: "long key ID", keyID: string(make([]byte, 256)), key: make([]byte, KeySize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var artifact bytes.Buffer
+			if err := Encrypt(&artifact, bytes.NewReader(nil), tt.keyID, tt.key); err == nil {
+				t.Error("Encrypt() accepted the key")
+			}
+		})
+	}
+}
+
+// TestHKDF checks the key derivation against the first KeySize bytes of the
+// output of test case 1 of RFC 5869.
+func TestHKDF(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	ikm := decode("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt := decode("000102030405060708090a0b0c")
+	info := decode("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+
+	if got := hex.EncodeToString(hkdf(ikm, salt, info)); got != want {
+		t.Errorf("hkdf() = %s, want %s", got, want)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 
@@ -31,8 +32,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
 	wordpressv1 "wordpress-operator/api/v1"
 	"wordpress-operator/controllers"
+	"wordpress-operator/crypt"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,7 +55,13 @@ func init() {
 }
 
 func main() {
+	// Jobs run the manager binary to encrypt and decrypt backup artifacts.
+	if len(os.Args) > 1 && os.Args[1] == "crypt" {
+		os.Exit(crypt.Main(os.Args[2:]))
+	}
+
 	var metricsAddr string
+	var operatorImage string
 	var enableLeaderElection bool
 	var probeAddr string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
@@ -57,6 +69,9 @@ func main() {
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&operatorImage, "operator-image", "",
+		"Image of the operator, run by backup Jobs to encrypt and decrypt artifacts. "+
+			"Defaults to the image of the manager pod named by POD_NAME and POD_NAMESPACE.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,12 +93,62 @@ func main() {
 		os.Exit(1)
 	}
 
+	if operatorImage == "" {
+		operatorImage, err = lookupOperatorImage(mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "unable to determine the operator image, backup encryption is disabled")
+		}
+	}
+
 	if err = (&controllers.WordpressReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("Wordpress"),
+		Scheme:        mgr.GetScheme(),
+		OperatorImage: operatorImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Wordpress")
+		os.Exit(1)
+	}
+	if err = (&controllers.WordpressBackupReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("WordpressBackup"),
+		Scheme:        mgr.GetScheme(),
+		OperatorImage: operatorImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WordpressBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.WordpressRestoreReconciler{
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("WordpressRestore"),
+		Scheme:        mgr.GetScheme(),
+		OperatorImage: operatorImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WordpressRestore")
+		os.Exit(1)
+	}
+	if err = (&controllers.WordpressPluginReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Wordpress"),
+		Log:    ctrl.Log.WithName("controllers").WithName("WordpressPlugin"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Wordpress")
+		setupLog.Error(err, "unable to create controller", "controller", "WordpressPlugin")
+		os.Exit(1)
+	}
+	if err = (&controllers.WordpressUserReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("WordpressUser"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WordpressUser")
+		os.Exit(1)
+	}
+	if err = (&controllers.WordpressSiteReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("WordpressSite"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WordpressSite")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
@@ -103,3 +168,24 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// lookupOperatorImage returns the image of the manager container of the pod
+// the operator runs in.
+func lookupOperatorImage(c client.Reader) (string, error) {
+	name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return "", nil
+	}
+
+	pod := &corev1.Pod{}
+	err := c.Get(context.Background(), types.NamespacedName{Name: name, Namespace: namespace}, pod)
+	if err != nil {
+		return "", err
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == "manager" {
+			return container.Image, nil
+		}
+	}
+	return "", nil
+}